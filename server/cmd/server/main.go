@@ -2,15 +2,19 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"gollaboratex/server/internal/api/graph"
 	"gollaboratex/server/internal/api/handlers"
 	"gollaboratex/server/internal/api/handlers/download"
+	"gollaboratex/server/internal/assetpolicy"
 	"gollaboratex/server/internal/middleware"
+	"gollaboratex/server/internal/safezip"
 	"gollaboratex/server/internal/websockets"
 
 	"github.com/99designs/gqlgen/graphql/handler"
@@ -107,15 +111,63 @@ func main() {
 		}
 	}
 
+	// Abandoned presigned uploads expire on their own
+	graph.SetupUploadExpiry(ctx, database, minioClient, bucketName)
+
+	// Versions saved before content-addressed storage move their contents to blobs
+	go graph.MigrateVersionBlobs(context.Background(), database)
+
+	// Files and assets are paged by path; older assets get their path recorded
+	go graph.MigrateProjectEntries(context.Background(), database)
+
+	// Which assets may be uploaded, and how large they may be
+	assetPolicy := assetpolicy.DefaultPolicy()
+	if types := os.Getenv("ASSET_ALLOWED_TYPES"); types != "" {
+		assetPolicy.AllowedTypes = assetpolicy.ParseTypes(types)
+	}
+	assetPolicy.MaxFileSize = int64(envInt("ASSET_MAX_FILE_MB", int(assetPolicy.MaxFileSize>>20))) << 20
+	assetPolicy.MaxProjectSize = int64(envInt("ASSET_MAX_PROJECT_MB", int(assetPolicy.MaxProjectSize>>20))) << 20
+
+	// Bounds on what an uploaded ZIP may expand to
+	zipLimits := safezip.DefaultLimits()
+	zipLimits.MaxTotalSize = int64(envInt("ZIP_MAX_UNCOMPRESSED_MB", int(zipLimits.MaxTotalSize>>20))) << 20
+	zipLimits.MaxEntries = envInt("ZIP_MAX_ENTRIES", zipLimits.MaxEntries)
+
+	// Automatic versions and how long they are kept
+	autoSnapshotCfg := graph.DefaultAutoSnapshotConfig()
+	autoSnapshotCfg.Idle = time.Duration(envInt("AUTOSNAPSHOT_IDLE_MIN", int(autoSnapshotCfg.Idle/time.Minute))) * time.Minute
+	autoSnapshotCfg.MaxAge = time.Duration(envInt("AUTOSNAPSHOT_MAX_AGE_HOURS", int(autoSnapshotCfg.MaxAge/time.Hour))) * time.Hour
+	retention := graph.DefaultRetentionPolicy()
+	retention.HourlyFor = time.Duration(envInt("AUTOSNAPSHOT_KEEP_HOURLY_HOURS", int(retention.HourlyFor/time.Hour))) * time.Hour
+	retention.DailyFor = time.Duration(envInt("AUTOSNAPSHOT_KEEP_DAILY_DAYS", int(retention.DailyFor/(24*time.Hour)))) * 24 * time.Hour
+
+	// Bare repositories projects are exported to; Git sync is off without one
+	gitCfg := graph.GitConfig{
+		Dir:        os.Getenv("GIT_REPO_DIR"),
+		AllowLocal: os.Getenv("GIT_ALLOW_LOCAL_REMOTES") == "true",
+		Limits:     zipLimits,
+	}
+
 	// Create GraphQL resolver
-resolver := &graph.Resolver{
-	DB:     database,
-	Minio:  minioClient,
-	Bucket: bucketName,
-}
+	resolver := &graph.Resolver{
+		DB:          database,
+		Minio:       minioClient,
+		Bucket:      bucketName,
+		AssetPolicy: assetPolicy,
+		Retention:   retention,
+		Git:         gitCfg,
+	}
 
 	// upload handler instance
 	uploadHandler := &handlers.UploadHandler{
+		DB:        database,
+		Minio:     minioClient,
+		Bucket:    bucketName,
+		Policy:    assetPolicy,
+		ZIPLimits: zipLimits,
+	}
+
+	assetHandler := &handlers.AssetHandler{
 		DB:     database,
 		Minio:  minioClient,
 		Bucket: bucketName,
@@ -164,6 +216,7 @@ resolver := &graph.Resolver{
 		MemoryBytes:     750 << 20,
 		NanoCPUs:        500000000,
 		Timeout:         60 * time.Second,
+		OnCompiled:      resolver.SnapshotCompiled,
 	}
 
 	// Start the compile worker in background (server continues serving)
@@ -178,6 +231,10 @@ resolver := &graph.Resolver{
 
 	// Add transports
 	srv.AddTransport(transport.POST{})
+	srv.AddTransport(transport.MultipartForm{
+		MaxUploadSize: 100 << 20,
+		MaxMemory:     32 << 20,
+	})
 	srv.AddTransport(transport.Websocket{
 		KeepAlivePingInterval: 10 * time.Second,
 		Upgrader: websocket.Upgrader{
@@ -204,7 +261,7 @@ resolver := &graph.Resolver{
 		AllowOrigins:     []string{"http://localhost:5173", "http://localhost:3000", "http://localhost:1234"}, // Add your frontend URLs
 		AllowMethods:     []string{"GET", "POST", "OPTIONS", "DELETE", "PUT"},
 		AllowHeaders:     []string{"Authorization", "Content-Type"},
-		ExposeHeaders:    []string{"Content-Disposition", "Content-Length"},
+		ExposeHeaders:    []string{"Content-Disposition", "Content-Length", "ETag", "Last-Modified", "X-Compile-Job-Id"},
 		AllowCredentials: true,
 	}))
 
@@ -250,16 +307,8 @@ resolver := &graph.Resolver{
 
 	assets := api.Group("/assets")
 	{
-		assets.GET("/:id", func(c *gin.Context) {
-			c.JSON(http.StatusNotImplemented, gin.H{
-				"error": "Asset download not yet implemented",
-			})
-		})
-		assets.DELETE("/:id", func(c *gin.Context) {
-			c.JSON(http.StatusNotImplemented, gin.H{
-				"error": "Asset deletion not yet implemented",
-			})
-		})
+		assets.GET("/:id", assetHandler.GetAsset)
+		assets.DELETE("/:id", assetHandler.DeleteAsset)
 	}
 
 	// Register worker HTTP handlers (compile endpoints) on a public API group so
@@ -275,14 +324,37 @@ resolver := &graph.Resolver{
 		workerCfg.RedisQueueName,
 		workerCfg.MinioBucketPDFs, // Removed logsBucket parameter
 	)
+	resolver.Compile = compileHandler
+
+	projects := api.Group("/projects")
+	{
+		// Stable URL for the latest compileProject build of a project; builds
+		// from the public compile endpoints below never show up here
+		projects.GET("/:id/pdf/latest", compileHandler.DownloadLatestPDF)
+	}
+
+	// Retention for compile outputs (sources, logs, PDFs)
+	janitorCfg := worker.JanitorConfig{
+		Interval:      time.Duration(envInt("JANITOR_INTERVAL_MIN", 60)) * time.Minute,
+		KeepLast:      envInt("COMPILE_RETENTION_KEEP_LAST", 10),
+		MaxAge:        time.Duration(envInt("COMPILE_RETENTION_DAYS", 30)) * 24 * time.Hour,
+		SourcesBucket: "compile-sources",
+		LogsBucket:    "compile-logs",
+		PdfsBucket:    workerCfg.MinioBucketPDFs,
+	}
+	go worker.RunJanitor(context.Background(), janitorCfg, minioClient, jobColl)
+
+	// Save edited projects as automatic versions
+	go resolver.RunAutoSnapshots(context.Background(), autoSnapshotCfg)
 
 	// Register compile endpoints directly under /api paths (public).
 	// Doing direct registrations avoids potential router group ordering issues.
-		r.POST("/api/compile-inline", compileHandler.EnqueueCompileInline)
-		r.POST("/api/compile", compileHandler.EnqueueCompile)
-		r.GET("/api/compile/:id", compileHandler.GetJobStatus)
-		r.GET("/api/:id/logs", compileHandler.GetJobLogs) // New: Get logs separately
-		r.GET("/api/compile/:id/pdf", compileHandler.DownloadPDF) // New: Download PDF directly
+	r.POST("/api/compile-inline", compileHandler.EnqueueCompileInline)
+	r.POST("/api/compile", compileHandler.EnqueueCompile)
+	r.GET("/api/compile/:id", compileHandler.GetJobStatus)
+	r.GET("/api/:id/logs", compileHandler.GetJobLogs)         // New: Get logs separately
+	r.GET("/api/compile/:id/pdf", compileHandler.DownloadPDF) // New: Download PDF directly
+
 	// Health check endpoint
 	r.GET("/health", func(c *gin.Context) {
 		// Test Redis connection
@@ -299,6 +371,19 @@ resolver := &graph.Resolver{
 		})
 	})
 
+	// Runtime metrics (janitor reclaimed bytes, ...) on an internal listener,
+	// as they expose the command line and memory statistics
+	if metricsAddr := os.Getenv("METRICS_ADDR"); metricsAddr != "" {
+		metrics := http.NewServeMux()
+		metrics.Handle("/debug/vars", expvar.Handler())
+		go func() {
+			log.Printf("Metrics on http://%s/debug/vars", metricsAddr)
+			if err := http.ListenAndServe(metricsAddr, metrics); err != nil {
+				log.Printf("Metrics listener failed: %v", err)
+			}
+		}()
+	}
+
 	log.Printf("Server starting on http://localhost:%s/", port)
 	log.Printf("GraphQL Playground: http://localhost:%s/", port)
 	log.Printf("GraphQL Endpoint: http://localhost:%s/query", port)
@@ -307,3 +392,12 @@ resolver := &graph.Resolver{
 		log.Fatal("Server failed to start:", err)
 	}
 }
+
+// envInt reads an integer environment variable, falling back to def when unset or invalid
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
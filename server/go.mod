@@ -18,7 +18,10 @@ require (
 	go.mongodb.org/mongo-driver/v2 v2.4.0
 )
 
-require github.com/google/uuid v1.6.0
+require (
+	github.com/gabriel-vasile/mimetype v1.4.9
+	github.com/google/uuid v1.6.0
+)
 
 require (
 	github.com/Microsoft/go-winio v0.4.21 // indirect
@@ -36,7 +39,6 @@ require (
 	github.com/docker/go-units v0.5.0 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/felixge/httpsnoop v1.0.4 // indirect
-	github.com/gabriel-vasile/mimetype v1.4.9 // indirect
 	github.com/gin-contrib/sse v1.1.0 // indirect
 	github.com/go-ini/ini v1.67.0 // indirect
 	github.com/go-jose/go-jose/v3 v3.0.4 // indirect
@@ -0,0 +1,385 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"gollaboratex/server/internal/latex"
+	"gollaboratex/server/internal/worker"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// AutoSnapshotConfig controls automatic versions of edited projects.
+type AutoSnapshotConfig struct {
+	// Interval between two scans for projects with unsaved edits
+	Interval time.Duration
+	// Idle is how long a project must go unedited before its edits are saved
+	Idle time.Duration
+	// MaxAge saves projects edited without pause at least this often
+	MaxAge time.Duration
+}
+
+// RetentionPolicy thins automatic versions as they age: one per hour is kept
+// for HourlyFor, one per day up to DailyFor and one per week beyond that.
+// Manual versions are never thinned.
+type RetentionPolicy struct {
+	HourlyFor time.Duration
+	DailyFor  time.Duration
+}
+
+// DefaultAutoSnapshotConfig saves after 10 idle minutes and at least daily
+func DefaultAutoSnapshotConfig() AutoSnapshotConfig {
+	return AutoSnapshotConfig{
+		Interval: time.Minute,
+		Idle:     10 * time.Minute,
+		MaxAge:   24 * time.Hour,
+	}
+}
+
+// DefaultRetentionPolicy keeps hourly versions for a day and daily ones for 30 days
+func DefaultRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		HourlyFor: 24 * time.Hour,
+		DailyFor:  30 * 24 * time.Hour,
+	}
+}
+
+// RunAutoSnapshots periodically saves projects with edits that are not in
+// any version yet, once editing paused or the latest version got too old.
+func (r *Resolver) RunAutoSnapshots(ctx context.Context, cfg AutoSnapshotConfig) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Minute
+	}
+
+	log.Printf("auto snapshots started (idle=%s, maxAge=%s)", cfg.Idle, cfg.MaxAge)
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := r.autoSnapshotPass(ctx, cfg); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("auto snapshot pass failed: %v", err)
+		}
+	}
+}
+
+func (r *Resolver) autoSnapshotPass(ctx context.Context, cfg AutoSnapshotConfig) error {
+	// Projects edited since they were last considered
+	cursor, err := r.DB.Collection("projects").Find(ctx, bson.M{
+		"$expr": bson.M{"$gt": bson.A{"$lastEditedAt", bson.M{"$ifNull": bson.A{"$autoSnapshotAt", time.Time{}}}}},
+	})
+	if err != nil {
+		return err
+	}
+	var projects []ProjectDoc
+	if err := cursor.All(ctx, &projects); err != nil {
+		return err
+	}
+
+	for _, project := range projects {
+		now := time.Now()
+		latest, err := r.latestVersion(ctx, project.ID)
+		if err != nil {
+			log.Printf("auto snapshot of project %s: %v", project.ID.Hex(), err)
+			continue
+		}
+
+		since := project.CreatedAt
+		if latest != nil {
+			since = latest.CreatedAt
+		}
+		idle := cfg.Idle > 0 && now.Sub(project.LastEditedAt) >= cfg.Idle
+		overdue := cfg.MaxAge > 0 && now.Sub(since) >= cfg.MaxAge
+		if !idle && !overdue {
+			continue
+		}
+
+		// A new project's empty main.tex is not worth a version, and would keep
+		// an imported ZIP from replacing it
+		if latest == nil {
+			untouched, err := r.untouchedPlaceholder(ctx, project.ID)
+			if err != nil {
+				log.Printf("auto snapshot of project %s: %v", project.ID.Hex(), err)
+				continue
+			}
+			if untouched {
+				r.DB.Collection("projects").UpdateOne(ctx,
+					bson.M{"_id": project.ID},
+					bson.M{"$set": bson.M{"autoSnapshotAt": now}},
+				)
+				continue
+			}
+		}
+
+		message := "Autosave"
+		version := VersionDoc{ProjectID: project.ID, Kind: VersionAuto, Message: &message}
+		if _, err := r.autoSnapshot(ctx, version, latest); err != nil {
+			log.Printf("auto snapshot of project %s: %v", project.ID.Hex(), err)
+			continue
+		}
+		r.DB.Collection("projects").UpdateOne(ctx,
+			bson.M{"_id": project.ID},
+			bson.M{"$set": bson.M{"autoSnapshotAt": now}},
+		)
+	}
+	return nil
+}
+
+// SnapshotCompiled saves the working tree of a project after a successful
+// compile and links the build's PDF to the new version. It is called by the
+// worker for builds compileProject enqueued; when the tree was edited while
+// the build ran, the PDF no longer matches it and nothing is saved.
+func (r *Resolver) SnapshotCompiled(ctx context.Context, job worker.JobPayload) {
+	projectID, err := toObjectID(job.ProjectID)
+	if err != nil || job.Source != worker.SourceProject || job.SourceHash == "" {
+		return
+	}
+
+	current, err := r.workingTreeHash(ctx, projectID)
+	if err != nil {
+		log.Printf("compile snapshot of project %s: %v", job.ProjectID, err)
+		return
+	}
+	if current != job.SourceHash {
+		return
+	}
+
+	latest, err := r.latestVersion(ctx, projectID)
+	if err != nil {
+		log.Printf("compile snapshot of project %s: %v", job.ProjectID, err)
+		return
+	}
+
+	// Builds are attributed to whoever started them
+	message := "Compiled"
+	version := VersionDoc{ProjectID: projectID, Kind: VersionCompile, Message: &message}
+	if authorID, err := toObjectID(job.UserID); err == nil {
+		version.AuthorID = authorID
+	}
+
+	saved, err := r.autoSnapshot(ctx, version, latest)
+	if err != nil {
+		log.Printf("compile snapshot of project %s: %v", job.ProjectID, err)
+		return
+	}
+	if saved == nil || r.Compile == nil {
+		return
+	}
+	// Pinned like compileVersion builds, so the janitor keeps the PDF
+	if err := r.Compile.LinkVersionPDF(ctx, saved.ID.Hex(), job.JobID); err != nil {
+		log.Printf("compile snapshot of project %s: %v", job.ProjectID, err)
+	}
+}
+
+// untouchedPlaceholder reports whether a project still only holds the empty
+// main.tex it was created with
+func (r *Resolver) untouchedPlaceholder(ctx context.Context, projectID bson.ObjectID) (bool, error) {
+	files, err := r.workingSnapshot(ctx, projectID)
+	if err != nil {
+		return false, err
+	}
+	assets, err := r.workingAssetSnapshot(ctx, projectID)
+	if err != nil {
+		return false, err
+	}
+	return isPlaceholderTree(files, assets), nil
+}
+
+func isPlaceholderTree(files []snapshotFile, assets []snapshotAsset) bool {
+	return len(assets) == 0 && len(files) == 1 && latex.IsPlaceholder(files[0].Name, files[0].Content)
+}
+
+// workingTreeHash identifies the text files and assets of a project's working
+// tree, so a build can be matched to the tree it compiled
+func (r *Resolver) workingTreeHash(ctx context.Context, projectID bson.ObjectID) (string, error) {
+	files, err := r.workingSnapshot(ctx, projectID)
+	if err != nil {
+		return "", err
+	}
+	assets, err := r.workingAssetSnapshot(ctx, projectID)
+	if err != nil {
+		return "", err
+	}
+	return treeHash(files, assets), nil
+}
+
+// treeHash hashes paths and contents, independent of the order given
+func treeHash(files []snapshotFile, assets []snapshotAsset) string {
+	entries := make([]string, 0, len(files)+len(assets))
+	for _, f := range files {
+		entries = append(entries, "file\x00"+f.Name+"\x00"+contentHash(f.Content))
+	}
+	for _, a := range assets {
+		entries = append(entries, "asset\x00"+a.Name+"\x00"+a.Hash)
+	}
+	sort.Strings(entries)
+	return contentHash(strings.Join(entries, "\n"))
+}
+
+// autoSnapshot saves the working tree as an automatic version and thins the
+// older ones. Nothing is saved, and nil returned, when the working tree equals
+// the latest version.
+func (r *Resolver) autoSnapshot(ctx context.Context, version VersionDoc, latest *VersionDoc) (*VersionDoc, error) {
+	if latest != nil {
+		changed, err := r.changedSince(ctx, version.ProjectID, *latest)
+		if err != nil {
+			return nil, err
+		}
+		if !changed {
+			return nil, nil
+		}
+	}
+
+	saved, err := r.createSnapshot(ctx, version)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.thinVersions(ctx, version.ProjectID, time.Now()); err != nil {
+		log.Printf("thinning versions of project %s: %v", version.ProjectID.Hex(), err)
+	}
+	return saved, nil
+}
+
+// latestVersion returns the newest version of a project, nil if it has none
+func (r *Resolver) latestVersion(ctx context.Context, projectID bson.ObjectID) (*VersionDoc, error) {
+	var version VersionDoc
+	err := r.DB.Collection("versions").FindOne(ctx,
+		bson.M{"projectId": projectID},
+		options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}}),
+	).Decode(&version)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// changedSince reports whether the working tree differs from a version in
+// its text files or assets
+func (r *Resolver) changedSince(ctx context.Context, projectID bson.ObjectID, version VersionDoc) (bool, error) {
+	if !version.Assets {
+		// The version cannot tell whether assets changed
+		return true, nil
+	}
+
+	working, err := r.workingSnapshot(ctx, projectID)
+	if err != nil {
+		return false, err
+	}
+	cursor, err := r.DB.Collection("version_files").Find(ctx, bson.M{"versionId": version.ID})
+	if err != nil {
+		return false, err
+	}
+	var versionFiles []VersionFileDoc
+	if err := cursor.All(ctx, &versionFiles); err != nil {
+		return false, err
+	}
+
+	recorded := make(map[string]string, len(versionFiles))
+	for _, vf := range versionFiles {
+		hash := vf.Hash
+		if hash == "" {
+			hash = contentHash(vf.Content)
+		}
+		recorded[vf.Name] = hash
+	}
+	if len(working) != len(recorded) {
+		return true, nil
+	}
+	for _, f := range working {
+		if recorded[f.Name] != contentHash(f.Content) {
+			return true, nil
+		}
+	}
+
+	workingAssets, err := r.workingAssetSnapshot(ctx, projectID)
+	if err != nil {
+		return false, err
+	}
+	versionAssets, err := r.versionAssetSnapshot(ctx, version.ID)
+	if err != nil {
+		return false, err
+	}
+	if len(workingAssets) != len(versionAssets) {
+		return true, nil
+	}
+	recordedAssets := make(map[string]string, len(versionAssets))
+	for _, a := range versionAssets {
+		recordedAssets[a.Name] = a.Hash
+	}
+	for _, a := range workingAssets {
+		if recordedAssets[a.Name] != a.Hash {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// thinVersions deletes the automatic versions of a project the retention
+// policy no longer keeps: per hour, day or week, depending on their age,
+// only the newest one survives. Pinned, locked and labeled ones always stay,
+// as do the versions forks were made from or last merged at.
+func (r *Resolver) thinVersions(ctx context.Context, projectID bson.ObjectID, now time.Time) error {
+	cursor, err := r.DB.Collection("versions").Find(ctx,
+		bson.M{"projectId": projectID, "kind": bson.M{"$in": bson.A{VersionAuto, VersionCompile}}},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}),
+	)
+	if err != nil {
+		return err
+	}
+	var versions []VersionDoc
+	if err := cursor.All(ctx, &versions); err != nil {
+		return err
+	}
+
+	ids := make([]bson.ObjectID, len(versions))
+	for i, v := range versions {
+		ids[i] = v.ID
+	}
+	bases, err := r.forkBases(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	kept := map[string]bool{}
+	for _, v := range versions {
+		if v.Pinned || v.Locked || len(v.Labels) > 0 || bases[v.ID] {
+			continue
+		}
+
+		var bucket string
+		created := v.CreatedAt.UTC()
+		switch age := now.Sub(v.CreatedAt); {
+		case age < r.Retention.HourlyFor:
+			bucket = created.Truncate(time.Hour).Format(time.RFC3339)
+		case age < r.Retention.DailyFor:
+			bucket = created.Format(time.DateOnly)
+		default:
+			year, week := created.ISOWeek()
+			bucket = fmt.Sprintf("%d-W%02d", year, week)
+		}
+
+		if kept[bucket] {
+			r.discardVersion(ctx, v.ID)
+			continue
+		}
+		kept[bucket] = true
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package graph
+
+import "testing"
+
+// A new project holds an empty main.tex. Saving it as a version would make
+// the upload handler's isEmptyPlaceholder see a version of the file, so a
+// ZIP import with main.tex would conflict instead of replacing it.
+func TestPlaceholderTreeIsNotSnapshotted(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  []snapshotFile
+		assets []snapshotAsset
+		want   bool
+	}{
+		{"new project", []snapshotFile{{Name: "main.tex", Content: ""}}, nil, true},
+		{"whitespace only", []snapshotFile{{Name: "main.tex", Content: " \n\t\n"}}, nil, true},
+		{"edited", []snapshotFile{{Name: "main.tex", Content: `\documentclass{article}`}}, nil, false},
+		{"renamed", []snapshotFile{{Name: "paper.tex", Content: ""}}, nil, false},
+		{"in a folder", []snapshotFile{{Name: "src/main.tex", Content: ""}}, nil, false},
+		{"second file", []snapshotFile{{Name: "main.tex"}, {Name: "refs.bib"}}, nil, false},
+		{"with an asset", []snapshotFile{{Name: "main.tex"}}, []snapshotAsset{{Name: "fig.png"}}, false},
+		{"empty tree", nil, nil, false},
+	}
+	for _, tt := range tests {
+		if got := isPlaceholderTree(tt.files, tt.assets); got != tt.want {
+			t.Errorf("%s: isPlaceholderTree = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
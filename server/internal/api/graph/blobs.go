@@ -0,0 +1,135 @@
+package graph
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// BlobDoc holds file content once per distinct content. Version files refer
+// to it by hash, so unchanged files cost nothing in later snapshots, across
+// versions and projects alike.
+type BlobDoc struct {
+	Hash      string    `bson:"_id"` // Hex SHA-256 of the content
+	Content   string    `bson:"content"`
+	Size      int       `bson:"size"`
+	CreatedAt time.Time `bson:"createdAt"`
+	UsedAt    time.Time `bson:"usedAt"` // Last time a version stored this content
+}
+
+// Unreferenced blobs stored more recently than this are kept, a snapshot in
+// progress may be about to reference them
+const blobGracePeriod = time.Hour
+
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// storeBlob saves content unless a blob with the same hash exists, and
+// returns its hash
+func storeBlob(ctx context.Context, db *mongo.Database, content string) (string, error) {
+	hash := contentHash(content)
+	now := time.Now()
+	_, err := db.Collection("blobs").UpdateOne(ctx,
+		bson.M{"_id": hash},
+		bson.M{
+			"$set":         bson.M{"usedAt": now},
+			"$setOnInsert": bson.M{"content": content, "size": len(content), "createdAt": now},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another writer stored the same content concurrently
+		return hash, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// loadBlobs returns the content of the given blobs by hash
+func loadBlobs(ctx context.Context, db *mongo.Database, hashes []string) (map[string]string, error) {
+	contents := make(map[string]string, len(hashes))
+	if len(hashes) == 0 {
+		return contents, nil
+	}
+
+	cursor, err := db.Collection("blobs").Find(ctx, bson.M{"_id": bson.M{"$in": hashes}})
+	if err != nil {
+		return nil, err
+	}
+	var blobs []BlobDoc
+	if err := cursor.All(ctx, &blobs); err != nil {
+		return nil, err
+	}
+	for _, b := range blobs {
+		contents[b.Hash] = b.Content
+	}
+	return contents, nil
+}
+
+// removeUnusedBlobs deletes the given blobs unless a version still refers to
+// them or they were stored recently
+func removeUnusedBlobs(ctx context.Context, db *mongo.Database, hashes []string) {
+	for _, hash := range hashes {
+		count, err := db.Collection("version_files").CountDocuments(ctx, bson.M{"hash": hash})
+		if err != nil || count > 0 {
+			continue
+		}
+		db.Collection("blobs").DeleteOne(ctx, bson.M{
+			"_id":    hash,
+			"usedAt": bson.M{"$not": bson.M{"$gte": time.Now().Add(-blobGracePeriod)}},
+		})
+	}
+}
+
+// migrateVersionFile moves the inline content of a version file written
+// before blobs existed into a blob
+func migrateVersionFile(ctx context.Context, db *mongo.Database, vf VersionFileDoc) error {
+	hash, err := storeBlob(ctx, db, vf.Content)
+	if err != nil {
+		return err
+	}
+	_, err = db.Collection("version_files").UpdateOne(ctx,
+		bson.M{"_id": vf.ID},
+		bson.M{"$set": bson.M{"hash": hash}, "$unset": bson.M{"content": ""}},
+	)
+	return err
+}
+
+// MigrateVersionBlobs converts all version files that still carry their
+// content inline. Reads keep working during the migration: loadVersionFiles
+// handles both forms and migrates what it reads.
+func MigrateVersionBlobs(ctx context.Context, db *mongo.Database) {
+	cursor, err := db.Collection("version_files").Find(ctx, bson.M{"hash": bson.M{"$exists": false}})
+	if err != nil {
+		log.Printf("Version blob migration failed: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var vf VersionFileDoc
+		if err := cursor.Decode(&vf); err != nil {
+			log.Printf("Version blob migration: %v", err)
+			continue
+		}
+		if err := migrateVersionFile(ctx, db, vf); err != nil {
+			log.Printf("Version blob migration of %s: %v", vf.ID.Hex(), err)
+			continue
+		}
+		migrated++
+	}
+	if migrated > 0 {
+		log.Printf("Moved %d version files to content-addressed blobs", migrated)
+	}
+}
@@ -0,0 +1,230 @@
+package graph
+
+import (
+	"context"
+	"errors"
+
+	"gollaboratex/server/internal/api/graph/model"
+	"gollaboratex/server/internal/diff"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Revisions returned by File.history without a limit
+const defaultHistoryLimit = 20
+
+// fileState is a file as recorded in one version; Present is false for
+// versions that do not contain it
+type fileState struct {
+	Version VersionDoc
+	Present bool
+	Name    string
+	Hash    string
+}
+
+// fileTimeline returns the state of a file in every version of its project,
+// oldest first, up to and including the version until when given. Contents
+// of versions stored before blobs existed are returned by hash.
+func (r *Resolver) fileTimeline(ctx context.Context, projectID, fileID bson.ObjectID, until *VersionDoc) ([]fileState, map[string]string, error) {
+	filter := bson.M{"projectId": projectID}
+	if until != nil {
+		filter["createdAt"] = bson.M{"$lte": until.CreatedAt}
+	}
+	cursor, err := r.DB.Collection("versions").Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	var versions []VersionDoc
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, nil, err
+	}
+
+	cursor, err = r.DB.Collection("version_files").Find(ctx, bson.M{"fileId": fileID})
+	if err != nil {
+		return nil, nil, err
+	}
+	var versionFiles []VersionFileDoc
+	if err := cursor.All(ctx, &versionFiles); err != nil {
+		return nil, nil, err
+	}
+
+	inline := map[string]string{}
+	byVersion := make(map[bson.ObjectID]VersionFileDoc, len(versionFiles))
+	for _, vf := range versionFiles {
+		if vf.Hash == "" {
+			vf.Hash = contentHash(vf.Content)
+			inline[vf.Hash] = vf.Content
+		}
+		byVersion[vf.VersionID] = vf
+	}
+
+	states := make([]fileState, 0, len(versions))
+	for _, v := range versions {
+		vf, ok := byVersion[v.ID]
+		states = append(states, fileState{Version: v, Present: ok, Name: vf.Name, Hash: vf.Hash})
+		if until != nil && v.ID == until.ID {
+			break
+		}
+	}
+	return states, inline, nil
+}
+
+// loadContents returns the contents of the given hashes, taking those of
+// inline version files from inline
+func (r *Resolver) loadContents(ctx context.Context, hashes []string, inline map[string]string) (map[string]string, error) {
+	var missing []string
+	for _, h := range hashes {
+		if _, ok := inline[h]; !ok {
+			missing = append(missing, h)
+		}
+	}
+	contents, err := loadBlobs(ctx, r.DB, missing)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hashes {
+		if c, ok := inline[h]; ok {
+			contents[h] = c
+		} else if _, ok := contents[h]; !ok {
+			return nil, errors.New("version content is missing")
+		}
+	}
+	return contents, nil
+}
+
+// fileChange is a version in which a file changed, with its previous state
+type fileChange struct {
+	state    fileState
+	previous fileState
+	status   model.FileChangeStatus
+}
+
+// fileHistory returns the versions in which a file changed, newest first
+func (r *Resolver) fileHistory(ctx context.Context, projectID, fileID bson.ObjectID, limit int) ([]*model.FileRevision, error) {
+	states, inline, err := r.fileTimeline(ctx, projectID, fileID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []fileChange
+	var last fileState
+	for _, s := range states {
+		var status model.FileChangeStatus
+		switch {
+		case s.Present && !last.Present:
+			status = model.FileChangeStatusAdded
+		case !s.Present && last.Present:
+			status = model.FileChangeStatusRemoved
+		case !s.Present:
+			continue
+		case s.Hash != last.Hash:
+			status = model.FileChangeStatusModified
+		case s.Name != last.Name:
+			status = model.FileChangeStatusRenamed
+		default:
+			continue
+		}
+		changes = append(changes, fileChange{state: s, previous: last, status: status})
+		last = s
+	}
+
+	// Newest first, cut to the limit before reading any content
+	for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+		changes[i], changes[j] = changes[j], changes[i]
+	}
+	if len(changes) > limit {
+		changes = changes[:limit]
+	}
+
+	var hashes []string
+	for _, c := range changes {
+		if c.state.Present {
+			hashes = append(hashes, c.state.Hash)
+		}
+		if c.previous.Present {
+			hashes = append(hashes, c.previous.Hash)
+		}
+	}
+	contents, err := r.loadContents(ctx, hashes, inline)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]*model.FileRevision, len(changes))
+	for i, c := range changes {
+		name := c.state.Name
+		if !c.state.Present {
+			name = c.previous.Name
+		}
+		additions, deletions := diff.Stats(diff.Lines(contents[c.previous.Hash], contents[c.state.Hash]))
+		revisions[i] = &model.FileRevision{
+			Version:   versionDocToModel(c.state.Version),
+			Name:      name,
+			Status:    c.status,
+			Additions: int32(additions),
+			Deletions: int32(deletions),
+		}
+	}
+	return revisions, nil
+}
+
+// fileBlame attributes each line of a file in a version to the version that
+// last changed it, replaying the file's changes from its first version on
+func (r *Resolver) fileBlame(ctx context.Context, fileID bson.ObjectID, version VersionDoc) (*model.FileBlame, error) {
+	states, inline, err := r.fileTimeline(ctx, version.ProjectID, fileID, &version)
+	if err != nil {
+		return nil, err
+	}
+	if len(states) == 0 || !states[len(states)-1].Present {
+		return nil, errors.New("file is not part of this version")
+	}
+
+	var hashes []string
+	for _, s := range states {
+		if s.Present {
+			hashes = append(hashes, s.Hash)
+		}
+	}
+	contents, err := r.loadContents(ctx, hashes, inline)
+	if err != nil {
+		return nil, err
+	}
+
+	// origins[i] is the version that introduced line i of the current content
+	var origins []*model.Version
+	current := ""
+	lastHash := ""
+	for _, s := range states {
+		if !s.Present || s.Hash == lastHash {
+			continue
+		}
+		introduced := versionDocToModel(s.Version)
+		next := make([]*model.Version, 0, len(origins))
+		for _, l := range diff.Lines(current, contents[s.Hash]) {
+			switch l.Kind {
+			case diff.Equal:
+				next = append(next, origins[l.Old-1])
+			case diff.Insert:
+				next = append(next, introduced)
+			}
+		}
+		origins = next
+		current = contents[s.Hash]
+		lastHash = s.Hash
+	}
+
+	lines := diff.SplitLines(current)
+	blame := &model.FileBlame{
+		FileID:    fileID.Hex(),
+		VersionID: version.ID.Hex(),
+		Name:      states[len(states)-1].Name,
+		Lines:     make([]*model.BlameLine, len(lines)),
+	}
+	for i, text := range lines {
+		blame.Lines[i] = &model.BlameLine{Line: int32(i + 1), Content: text, Version: origins[i]}
+	}
+	return blame, nil
+}
@@ -0,0 +1,190 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gollaboratex/server/internal/paths"
+
+	"github.com/minio/minio-go/v7"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// forkVersion creates a project owned by userID holding the files and assets
+// of a version of source. Files remember the file they were copied from, so
+// changes can later be merged back.
+func (r *mutationResolver) forkVersion(ctx context.Context, source ProjectDoc, version VersionDoc, name string, userID bson.ObjectID) (*ProjectDoc, error) {
+	versionFiles, err := r.loadVersionFiles(ctx, version.ID)
+	if err != nil {
+		return nil, err
+	}
+	versionAssets, err := r.forkAssets(ctx, source.ID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	project := ProjectDoc{
+		ID:              bson.NewObjectID(),
+		ProjectName:     name,
+		OwnerID:         userID,
+		CollaboratorIDs: []bson.ObjectID{},
+		LastEditedAt:    now,
+		CreatedAt:       now,
+		ForkOf:          source.ID,
+		ForkVersionID:   version.ID,
+		ForkedAt:        now,
+	}
+
+	rootName := versionMainFile(version.rootFile(source.RootFileID), versionFiles)
+	for _, vf := range versionFiles {
+		file := FileDoc{
+			ProjectID: project.ID,
+			Name:      vf.Name,
+			Type:      vf.Type,
+			CreatedAt: now,
+			UpdatedAt: now,
+			UpdatedBy: userID,
+			OriginID:  vf.FileID,
+		}
+		result, err := r.DB.Collection("files").InsertOne(ctx, file)
+		if err != nil {
+			r.discardProject(ctx, project.ID)
+			return nil, err
+		}
+		file.ID = result.InsertedID.(bson.ObjectID)
+		if vf.Name == rootName {
+			project.RootFileID = file.ID
+		}
+
+		_, err = r.DB.Collection("working_files").InsertOne(ctx, WorkingFileDoc{
+			FileID:    file.ID,
+			ProjectID: project.ID,
+			Content:   vf.Content,
+			UpdatedAt: now,
+		})
+		if err != nil {
+			r.discardProject(ctx, project.ID)
+			return nil, err
+		}
+	}
+
+	for _, va := range versionAssets {
+		key := paths.AssetObject("project", project.ID.Hex(), va.Name)
+		if err := r.copyMinioObject(ctx, va.Object, key); err != nil {
+			r.discardProject(ctx, project.ID)
+			return nil, fmt.Errorf("failed to copy asset %s: %w", va.Name, err)
+		}
+		_, err := r.DB.Collection("assets").InsertOne(ctx, AssetDoc{
+			ProjectID: project.ID,
+			Name:      va.Name,
+			Path:      key,
+			MimeType:  va.MimeType,
+			Size:      va.Size,
+			CreatedAt: now,
+			Hash:      va.Hash,
+		})
+		if err != nil {
+			r.discardProject(ctx, project.ID)
+			return nil, err
+		}
+	}
+
+	if _, err := r.DB.Collection("projects").InsertOne(ctx, project); err != nil {
+		r.discardProject(ctx, project.ID)
+		return nil, err
+	}
+
+	// The fork's history starts with what it was forked from
+	message := fmt.Sprintf("Forked from %q", source.ProjectName)
+	_, err = r.createSnapshot(ctx, VersionDoc{
+		ProjectID: project.ID,
+		Kind:      VersionManual,
+		Message:   &message,
+		AuthorID:  userID,
+	})
+	if err != nil {
+		log.Printf("failed to create the first version of fork %s: %v", project.ID.Hex(), err)
+	}
+
+	return &project, nil
+}
+
+// forkAssets returns the assets to copy into a fork of version. Versions
+// created before assets were recorded fork the project's current assets.
+func (r *mutationResolver) forkAssets(ctx context.Context, projectID bson.ObjectID, version VersionDoc) ([]VersionAssetDoc, error) {
+	if version.Assets {
+		return r.loadVersionAssets(ctx, version.ID)
+	}
+
+	cursor, err := r.DB.Collection("assets").Find(ctx, bson.M{"projectId": projectID})
+	if err != nil {
+		return nil, err
+	}
+	var assets []AssetDoc
+	if err := cursor.All(ctx, &assets); err != nil {
+		return nil, err
+	}
+
+	versionAssets := make([]VersionAssetDoc, len(assets))
+	for i, a := range assets {
+		versionAssets[i] = VersionAssetDoc{
+			AssetID:  a.ID,
+			Name:     assetName(a.Name, a.Path),
+			Object:   a.Path,
+			MimeType: a.MimeType,
+			Size:     a.Size,
+		}
+	}
+	return versionAssets, nil
+}
+
+// discardProject removes what a failed fork or import created so far
+func (r *mutationResolver) discardProject(ctx context.Context, projectID bson.ObjectID) {
+	cursor, err := r.DB.Collection("assets").Find(ctx, bson.M{"projectId": projectID})
+	if err == nil {
+		var assets []AssetDoc
+		if cursor.All(ctx, &assets) == nil {
+			for _, a := range assets {
+				_ = r.Minio.RemoveObject(ctx, r.Bucket, a.Path, minio.RemoveObjectOptions{})
+			}
+		}
+	}
+
+	r.DB.Collection("assets").DeleteMany(ctx, bson.M{"projectId": projectID})
+	r.DB.Collection("working_files").DeleteMany(ctx, bson.M{"projectId": projectID})
+	r.DB.Collection("files").DeleteMany(ctx, bson.M{"projectId": projectID})
+	r.DB.Collection("projects").DeleteOne(ctx, bson.M{"_id": projectID})
+}
+
+// forkBases returns which of the given versions a fork was made from or last
+// merged at. The next merge needs them as its common ancestor, so they are
+// neither thinned nor deleted.
+func (r *Resolver) forkBases(ctx context.Context, versionIDs []bson.ObjectID) (map[bson.ObjectID]bool, error) {
+	bases := map[bson.ObjectID]bool{}
+	if len(versionIDs) == 0 {
+		return bases, nil
+	}
+
+	cursor, err := r.DB.Collection("projects").Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"forkVersionId": bson.M{"$in": versionIDs}},
+		bson.M{"mergeBaseVersionId": bson.M{"$in": versionIDs}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	var forks []ProjectDoc
+	if err := cursor.All(ctx, &forks); err != nil {
+		return nil, err
+	}
+
+	for _, f := range forks {
+		bases[f.ForkVersionID] = true
+		if !f.MergeBaseVersionID.IsZero() {
+			bases[f.MergeBaseVersionID] = true
+		}
+	}
+	return bases, nil
+}
@@ -0,0 +1,517 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gollaboratex/server/internal/api/graph/model"
+	"gollaboratex/server/internal/assetpolicy"
+	"gollaboratex/server/internal/assetstore"
+	"gollaboratex/server/internal/gitsync"
+	"gollaboratex/server/internal/latex"
+	"gollaboratex/server/internal/paths"
+	"gollaboratex/server/internal/safezip"
+
+	"github.com/minio/minio-go/v7"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// GitConfig controls exporting projects to Git.
+type GitConfig struct {
+	// Dir holds a bare repository per project; empty disables Git sync
+	Dir string
+	// AllowLocal accepts paths on the server as remotes, e.g. to sync with a
+	// bare repository on the same machine
+	AllowLocal bool
+	// Limits bounds what a commit may bring into a project, as for uploaded
+	// ZIPs; the compression ratio does not apply
+	Limits safezip.Limits
+}
+
+const defaultGitBranch = "main"
+
+// Commits of versions without an author are made by the server
+const (
+	gitServerName  = "Collaboratex"
+	gitServerEmail = "noreply@collaboratex"
+)
+
+// gitVersionTrailer names the version a commit was exported from
+const gitVersionTrailer = "Collaboratex-Version"
+
+var errGitDisabled = errors.New("git sync is not enabled on this server")
+
+// Branch names git accepts, minus the rarely used characters
+var gitBranchPattern = regexp.MustCompile(`^[A-Za-z0-9._][A-Za-z0-9._/-]*$`)
+
+func validGitBranch(branch string) bool {
+	return gitBranchPattern.MatchString(branch) &&
+		!strings.Contains(branch, "..") && !strings.Contains(branch, "//") &&
+		!strings.HasSuffix(branch, "/") && !strings.HasSuffix(branch, ".lock")
+}
+
+// checkRemote accepts HTTPS URLs and, where allowed, local repositories
+func (r *Resolver) checkRemote(remote string) error {
+	u, err := url.Parse(remote)
+	if err == nil && u.Scheme == "https" && u.Host != "" {
+		return nil
+	}
+	if r.Git.AllowLocal && (filepath.IsAbs(remote) || (err == nil && u.Scheme == "file")) {
+		return nil
+	}
+	return fmt.Errorf("invalid remote %q: use an https:// URL", redactRemote(remote))
+}
+
+// redactRemote drops the credentials a remote URL may hold
+func redactRemote(remote string) string {
+	u, err := url.Parse(remote)
+	if err != nil || u.User == nil {
+		return remote
+	}
+	u.User = nil
+	return u.String()
+}
+
+// gitRemoteError reports a failed operation on a remote without the
+// credentials its URL may hold, which git can echo back
+func gitRemoteError(action, remote string, err error) error {
+	redacted := redactRemote(remote)
+	return fmt.Errorf("%s %s: %s", action, redacted, strings.ReplaceAll(err.Error(), remote, redacted))
+}
+
+func gitSyncToModel(sync *GitSyncDoc) *model.GitSync {
+	if sync == nil {
+		return nil
+	}
+	result := &model.GitSync{Branch: sync.Branch}
+	if sync.Remote != "" {
+		remote := redactRemote(sync.Remote)
+		result.Remote = &remote
+	}
+	if sync.Head != "" {
+		result.Head = &sync.Head
+	}
+	if !sync.SyncedAt.IsZero() {
+		syncedAt := sync.SyncedAt.Format(time.RFC3339)
+		result.SyncedAt = &syncedAt
+	}
+	return result
+}
+
+func (r *Resolver) repoDir(projectID bson.ObjectID) string {
+	return filepath.Join(r.Git.Dir, projectID.Hex()+".git")
+}
+
+// projectRepo opens the bare repository of a project, creating it if needed
+func (r *Resolver) projectRepo(ctx context.Context, projectID bson.ObjectID) (*gitsync.Repo, error) {
+	if r.Git.Dir == "" {
+		return nil, errGitDisabled
+	}
+	return gitsync.Init(ctx, r.repoDir(projectID))
+}
+
+// exportVersions commits the versions created since the last exported one,
+// oldest first, to the project's branch and records the commit of each.
+// Versions created before assets were recorded are exported without them.
+func (r *Resolver) exportVersions(ctx context.Context, project ProjectDoc, repo *gitsync.Repo) (*GitSyncDoc, error) {
+	sync := *project.Git
+
+	filter := bson.M{"projectId": project.ID, "gitCommit": bson.M{"$exists": false}}
+	var last VersionDoc
+	err := r.DB.Collection("versions").FindOne(ctx,
+		bson.M{"projectId": project.ID, "gitCommit": bson.M{"$exists": true}},
+		options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}}),
+	).Decode(&last)
+	switch {
+	case err == nil:
+		filter["createdAt"] = bson.M{"$gt": last.CreatedAt}
+	case !errors.Is(err, mongo.ErrNoDocuments):
+		return nil, err
+	}
+
+	cursor, err := r.DB.Collection("versions").Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var versions []VersionDoc
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return &sync, nil
+	}
+
+	w, err := repo.NewWriter(ctx, sync.Branch, sync.Head)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.writeVersions(ctx, w, versions); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	commits, err := w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, v := range versions {
+		r.DB.Collection("versions").UpdateOne(ctx,
+			bson.M{"_id": v.ID},
+			bson.M{"$set": bson.M{"gitCommit": commits[i]}},
+		)
+	}
+	sync.Head = commits[len(commits)-1]
+	return &sync, nil
+}
+
+// writeVersions writes a commit per version. Text files and assets with the
+// same content share one blob.
+func (r *Resolver) writeVersions(ctx context.Context, w *gitsync.Writer, versions []VersionDoc) error {
+	blobs := map[string]string{}
+	authors := map[bson.ObjectID]gitsync.Signature{}
+
+	for _, v := range versions {
+		versionFiles, err := r.loadVersionFiles(ctx, v.ID)
+		if err != nil {
+			return err
+		}
+		var files []gitsync.File
+		for _, vf := range versionFiles {
+			hash := contentHash(vf.Content)
+			mark, ok := blobs[hash]
+			if !ok {
+				mark, err = w.Blob(strings.NewReader(vf.Content), int64(len(vf.Content)))
+				if err != nil {
+					return err
+				}
+				blobs[hash] = mark
+			}
+			files = append(files, gitsync.File{Path: vf.Name, Blob: mark})
+		}
+
+		if v.Assets {
+			versionAssets, err := r.loadVersionAssets(ctx, v.ID)
+			if err != nil {
+				return err
+			}
+			for _, va := range versionAssets {
+				mark, ok := blobs[va.Hash]
+				if !ok {
+					mark, err = r.writeAssetBlob(ctx, w, va)
+					if err != nil {
+						return fmt.Errorf("failed to export asset %s: %w", va.Name, err)
+					}
+					blobs[va.Hash] = mark
+				}
+				files = append(files, gitsync.File{Path: va.Name, Blob: mark})
+			}
+		}
+
+		author, ok := authors[v.AuthorID]
+		if !ok {
+			author = r.gitAuthor(ctx, v.AuthorID)
+			authors[v.AuthorID] = author
+		}
+		author.When = v.CreatedAt
+
+		err = w.Commit(gitsync.Commit{Author: author, Message: versionCommitMessage(v), Files: files})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Resolver) writeAssetBlob(ctx context.Context, w *gitsync.Writer, va VersionAssetDoc) (string, error) {
+	obj, err := r.Minio.GetObject(ctx, r.Bucket, va.Object, minio.GetObjectOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer obj.Close()
+	return w.Blob(obj, int64(va.Size))
+}
+
+// gitAuthor returns the identity commits of a user's versions are made with
+func (r *Resolver) gitAuthor(ctx context.Context, userID bson.ObjectID) gitsync.Signature {
+	if !userID.IsZero() {
+		var user UserDoc
+		err := r.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err == nil && user.ClerkUserID != "" {
+			return gitsync.Signature{Name: user.ClerkUserID, Email: user.ClerkUserID + "@users.noreply.collaboratex"}
+		}
+	}
+	return gitsync.Signature{Name: gitServerName, Email: gitServerEmail}
+}
+
+// versionCommitMessage is the version's message with a trailer naming it
+func versionCommitMessage(v VersionDoc) string {
+	message := "Version of " + v.CreatedAt.UTC().Format(time.RFC3339)
+	if v.Message != nil && strings.TrimSpace(*v.Message) != "" {
+		message = strings.TrimSpace(*v.Message)
+	}
+	return fmt.Sprintf("%s\n\n%s: %s\n", message, gitVersionTrailer, v.ID.Hex())
+}
+
+// applyTree makes the files and assets of a project those of a commit: text
+// files by extension, like uploads, everything else as assets under the
+// asset policy. Entries already holding the content are left alone. When the
+// root file is gone, a document that compiles on its own takes its place.
+func (r *mutationResolver) applyTree(ctx context.Context, project ProjectDoc, repo *gitsync.Repo, commit string, userID bson.ObjectID) error {
+	entries, err := repo.Tree(ctx, commit)
+	if err != nil {
+		return err
+	}
+	if err := checkTreeLimits(entries, r.Git.Limits); err != nil {
+		return err
+	}
+
+	files, err := r.workingSnapshot(ctx, project.ID)
+	if err != nil {
+		return err
+	}
+	fileByName := make(map[string]snapshotFile, len(files))
+	for _, f := range files {
+		fileByName[f.Name] = f
+	}
+
+	cursor, err := r.DB.Collection("assets").Find(ctx, bson.M{"projectId": project.ID})
+	if err != nil {
+		return err
+	}
+	var assets []AssetDoc
+	if err := cursor.All(ctx, &assets); err != nil {
+		return err
+	}
+	assetByName := make(map[string]AssetDoc, len(assets))
+	for _, a := range assets {
+		assetByName[assetName(a.Name, a.Path)] = a
+	}
+
+	kept := map[bson.ObjectID]bool{}
+	rootDocs := map[string]bson.ObjectID{}
+	var used int64
+	now := time.Now()
+
+	for _, e := range entries {
+		name, err := paths.Clean(e.Path)
+		if err != nil {
+			return fmt.Errorf("invalid path %q: %w", e.Path, err)
+		}
+
+		fileType, isText := textFileType(name)
+		if !isText {
+			asset, exists := assetByName[name]
+			if exists {
+				kept[asset.ID] = true
+			}
+			if err := r.AssetPolicy.CheckQuota(name, used, e.Size); err != nil {
+				return err
+			}
+			used += e.Size
+			if err := r.applyTreeAsset(ctx, project.ID, repo, e, name, asset, exists, userID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := repo.ReadBlob(ctx, e.Blob)
+		if err != nil {
+			return err
+		}
+		content := string(data)
+
+		fileID := bson.ObjectID{}
+		if f, ok := fileByName[name]; ok {
+			fileID = f.FileID
+			if f.Content != content {
+				_, err = r.DB.Collection("working_files").UpdateMany(ctx,
+					bson.M{"fileId": f.FileID},
+					bson.M{"$set": bson.M{"content": content, "updatedAt": now}},
+				)
+				if err != nil {
+					return err
+				}
+				r.DB.Collection("files").UpdateOne(ctx,
+					bson.M{"_id": f.FileID},
+					bson.M{"$set": bson.M{"updatedAt": now, "updatedBy": userID}},
+				)
+			}
+		} else {
+			result, err := r.DB.Collection("files").InsertOne(ctx, FileDoc{
+				ProjectID: project.ID,
+				Name:      name,
+				Type:      fileTypeToString(fileType),
+				CreatedAt: now,
+				UpdatedAt: now,
+				UpdatedBy: userID,
+			})
+			if err != nil {
+				return err
+			}
+			fileID = result.InsertedID.(bson.ObjectID)
+			_, err = r.DB.Collection("working_files").InsertOne(ctx, WorkingFileDoc{
+				FileID:    fileID,
+				ProjectID: project.ID,
+				Content:   content,
+				UpdatedAt: now,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		kept[fileID] = true
+		if fileType == model.FileTypeTex && latex.IsRootDocument(content) {
+			rootDocs[name] = fileID
+		}
+	}
+
+	// What the commit does not have
+	for _, f := range files {
+		if kept[f.FileID] {
+			continue
+		}
+		r.DB.Collection("working_files").DeleteMany(ctx, bson.M{"fileId": f.FileID})
+		if _, err := r.DB.Collection("files").DeleteOne(ctx, bson.M{"_id": f.FileID}); err != nil {
+			return err
+		}
+	}
+	for _, a := range assets {
+		if kept[a.ID] {
+			continue
+		}
+		r.removeAssetObject(ctx, a.Path)
+		if _, err := r.DB.Collection("assets").DeleteOne(ctx, bson.M{"_id": a.ID}); err != nil {
+			return err
+		}
+	}
+
+	if !kept[project.RootFileID] {
+		candidates := make([]string, 0, len(rootDocs))
+		for name := range rootDocs {
+			candidates = append(candidates, name)
+		}
+		if root := latex.PickRoot(candidates); root != "" {
+			_, err := r.DB.Collection("projects").UpdateOne(ctx,
+				bson.M{"_id": project.ID},
+				bson.M{"$set": bson.M{"rootFileId": rootDocs[root]}},
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkTreeLimits rejects a commit as a whole, before any content is read,
+// when it holds more files or more data than an uploaded ZIP may
+func checkTreeLimits(entries []gitsync.Entry, limits safezip.Limits) error {
+	if limits.MaxEntries > 0 && len(entries) > limits.MaxEntries {
+		return fmt.Errorf("%w: %d files, the limit is %d", safezip.ErrTooManyEntries, len(entries), limits.MaxEntries)
+	}
+	var total int64
+	for _, e := range entries {
+		if limits.MaxDepth > 0 && strings.Count(e.Path, "/")+1 > limits.MaxDepth {
+			return fmt.Errorf("%w: %s", safezip.ErrTooDeep, e.Path)
+		}
+		total += e.Size
+		if limits.MaxTotalSize > 0 && total > limits.MaxTotalSize {
+			return fmt.Errorf("%w: more than %d bytes", safezip.ErrTooLarge, limits.MaxTotalSize)
+		}
+	}
+	return nil
+}
+
+// resetToVersion makes a project exactly a version again, undoing a pull
+// that failed halfway through applying a commit
+func (r *mutationResolver) resetToVersion(ctx context.Context, project ProjectDoc, version VersionDoc, userID bson.ObjectID) error {
+	versionFiles, err := r.loadVersionFiles(ctx, version.ID)
+	if err != nil {
+		return err
+	}
+	if err := r.restoreFiles(ctx, project.ID, versionFiles, userID); err != nil {
+		return err
+	}
+	if err := r.restoreRootFile(ctx, project, version, versionFiles); err != nil {
+		return err
+	}
+	return r.restoreAssets(ctx, project.ID, version.ID, userID)
+}
+
+// applyTreeAsset stores a binary entry of a commit as the asset at name
+func (r *mutationResolver) applyTreeAsset(ctx context.Context, projectID bson.ObjectID, repo *gitsync.Repo, e gitsync.Entry, name string, asset AssetDoc, exists bool, userID bson.ObjectID) error {
+	if err := r.AssetPolicy.CheckSize(name, e.Size); err != nil {
+		return err
+	}
+	data, err := repo.ReadBlob(ctx, e.Blob)
+	if err != nil {
+		return err
+	}
+	head := data[:min(len(data), assetpolicy.SniffLen)]
+	mimeType, err := r.AssetPolicy.Check(name, assetpolicy.Detect(head), e.Size)
+	if err != nil {
+		return err
+	}
+
+	hash := contentHash(string(data))
+	key := paths.AssetObject("project", projectID.Hex(), name)
+	if exists {
+		if current, _, err := r.assetHash(ctx, asset); err == nil && current == hash && asset.Path == key {
+			return nil
+		}
+		// Templates created from this project keep the old content
+		if err := assetstore.DetachTemplates(ctx, r.DB, r.Minio, r.Bucket, key); err != nil {
+			return err
+		}
+	}
+
+	info, err := r.Minio.PutObject(ctx, r.Bucket, key, bytes.NewReader(data), e.Size, minio.PutObjectOptions{ContentType: mimeType})
+	if err != nil {
+		return fmt.Errorf("failed to store asset %s: %w", name, err)
+	}
+
+	now := time.Now()
+	if !exists {
+		_, err = r.DB.Collection("assets").InsertOne(ctx, AssetDoc{
+			ProjectID: projectID,
+			Name:      name,
+			Path:      key,
+			MimeType:  mimeType,
+			Size:      int(e.Size),
+			CreatedAt: now,
+			UpdatedAt: now,
+			UpdatedBy: userID,
+			Hash:      hash,
+			HashETag:  info.ETag,
+		})
+		return err
+	}
+
+	if asset.Path != key {
+		r.removeAssetObject(ctx, asset.Path)
+	}
+	_, err = r.DB.Collection("assets").UpdateOne(ctx,
+		bson.M{"_id": asset.ID},
+		bson.M{"$set": bson.M{
+			"path":      key,
+			"mimeType":  mimeType,
+			"size":      e.Size,
+			"updatedAt": now,
+			"updatedBy": userID,
+			"hash":      hash,
+			"hashETag":  info.ETag,
+		}},
+	)
+	return err
+}
@@ -0,0 +1,515 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gollaboratex/server/internal/api/graph/model"
+	"gollaboratex/server/internal/assetstore"
+	"gollaboratex/server/internal/diff"
+	"gollaboratex/server/internal/paths"
+
+	"github.com/minio/minio-go/v7"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// merger collects what a merge changes in the target and the conflicts it
+// leaves before anything is written
+type merger struct {
+	r              *mutationResolver
+	source, target ProjectDoc
+	userID         bson.ObjectID
+	resolutions    map[string]*model.MergeResolution
+	ops            []mergeOp
+	conflicts      []*model.MergeConflict
+	// Source files paired with a target file they were not copied from
+	links map[bson.ObjectID]bson.ObjectID
+}
+
+// mergeOp is a change to the target; path names it in MergeResult.applied
+type mergeOp struct {
+	path  string
+	apply func(ctx context.Context) error
+}
+
+// mergeFork merges the changes source made since its common ancestor with
+// target, its parent, into target's working tree. The merge completes, with
+// a merge version of the target, once no conflicts are left.
+func (r *mutationResolver) mergeFork(ctx context.Context, source, target ProjectDoc, resolutions []*model.MergeResolution, userID bson.ObjectID) (*model.MergeResult, error) {
+	// The version forked from, or the source as it was last merged
+	baseID := source.ForkVersionID
+	if !source.MergeBaseVersionID.IsZero() {
+		baseID = source.MergeBaseVersionID
+	}
+	var base VersionDoc
+	if err := r.DB.Collection("versions").FindOne(ctx, bson.M{"_id": baseID}).Decode(&base); err != nil {
+		return nil, errors.New("common ancestor version not found")
+	}
+
+	// What is merged is saved first; it is the next common ancestor
+	message := fmt.Sprintf("Merged into %q", target.ProjectName)
+	sourceVersion, err := r.createSnapshot(ctx, VersionDoc{
+		ProjectID: source.ID,
+		Kind:      VersionManual,
+		Message:   &message,
+		AuthorID:  userID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot project: %w", err)
+	}
+
+	m := &merger{
+		r:           r,
+		source:      source,
+		target:      target,
+		userID:      userID,
+		resolutions: make(map[string]*model.MergeResolution, len(resolutions)),
+		links:       map[bson.ObjectID]bson.ObjectID{},
+	}
+	for _, res := range resolutions {
+		m.resolutions[res.Path] = res
+	}
+	if err := m.planFiles(ctx, base, *sourceVersion); err != nil {
+		r.discardVersion(ctx, sourceVersion.ID)
+		return nil, err
+	}
+	if err := m.planAssets(ctx, base, *sourceVersion); err != nil {
+		r.discardVersion(ctx, sourceVersion.ID)
+		return nil, err
+	}
+
+	result := &model.MergeResult{Applied: []string{}, Conflicts: m.conflicts}
+	if result.Conflicts == nil {
+		result.Conflicts = []*model.MergeConflict{}
+	}
+
+	if len(m.ops) > 0 {
+		// Snapshot the target first so the merge can be undone
+		before := fmt.Sprintf("Before merging %q", source.ProjectName)
+		_, err := r.createSnapshot(ctx, VersionDoc{
+			ProjectID: target.ID,
+			Kind:      VersionManual,
+			Message:   &before,
+			AuthorID:  userID,
+		})
+		if err != nil {
+			r.discardVersion(ctx, sourceVersion.ID)
+			return nil, fmt.Errorf("failed to snapshot current state: %w", err)
+		}
+
+		for _, op := range m.ops {
+			if err := op.apply(ctx); err != nil {
+				r.discardVersion(ctx, sourceVersion.ID)
+				return nil, fmt.Errorf("failed to merge %s: %w", op.path, err)
+			}
+			result.Applied = append(result.Applied, op.path)
+		}
+		r.DB.Collection("projects").UpdateOne(ctx,
+			bson.M{"_id": target.ID},
+			bson.M{"$set": bson.M{"lastEditedAt": time.Now()}},
+		)
+	}
+
+	// Later merges pair these files by id
+	for sourceFileID, targetFileID := range m.links {
+		r.DB.Collection("files").UpdateOne(ctx,
+			bson.M{"_id": sourceFileID},
+			bson.M{"$set": bson.M{"originId": targetFileID}},
+		)
+	}
+
+	if len(m.conflicts) > 0 {
+		r.discardVersion(ctx, sourceVersion.ID)
+		return result, nil
+	}
+
+	merged := fmt.Sprintf("Merged %q", source.ProjectName)
+	version, err := r.createSnapshot(ctx, VersionDoc{
+		ProjectID:  target.ID,
+		Kind:       VersionMerge,
+		Message:    &merged,
+		AuthorID:   userID,
+		MergedFrom: sourceVersion.ID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create merge version: %w", err)
+	}
+	r.DB.Collection("projects").UpdateOne(ctx,
+		bson.M{"_id": source.ID},
+		bson.M{"$set": bson.M{"mergeBaseVersionId": sourceVersion.ID}},
+	)
+
+	result.Version = versionDocToModel(*version)
+	return result, nil
+}
+
+// conflict records c unless a resolution settles it: keeping the source runs
+// takeSource, resolved text goes to write, keeping the target changes nothing
+func (m *merger) conflict(c *model.MergeConflict, takeSource func(), write func(content string)) {
+	res, ok := m.resolutions[c.Path]
+	switch {
+	case !ok:
+	case res.Content != nil && write != nil:
+		write(*res.Content)
+		return
+	case res.Side != nil && *res.Side == model.MergeSideSource:
+		takeSource()
+		return
+	case res.Side != nil && *res.Side == model.MergeSideTarget:
+		return
+	}
+	if c.Hunks == nil {
+		c.Hunks = []*model.ConflictHunk{}
+	}
+	m.conflicts = append(m.conflicts, c)
+}
+
+// planFiles merges the text files. Source files are paired with the target
+// file they were copied from, then by name; so is the common ancestor when it
+// is a version of the source.
+func (m *merger) planFiles(ctx context.Context, base, sourceVersion VersionDoc) error {
+	baseFiles, err := m.r.versionSnapshot(ctx, base.ID)
+	if err != nil {
+		return err
+	}
+	sourceFiles, err := m.r.versionSnapshot(ctx, sourceVersion.ID)
+	if err != nil {
+		return err
+	}
+	targetFiles, err := m.r.workingSnapshot(ctx, m.target.ID)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := m.r.DB.Collection("files").Find(ctx, bson.M{"projectId": m.source.ID})
+	if err != nil {
+		return err
+	}
+	var sourceDocs []FileDoc
+	if err := cursor.All(ctx, &sourceDocs); err != nil {
+		return err
+	}
+	origins := make(map[bson.ObjectID]bson.ObjectID, len(sourceDocs))
+	for _, f := range sourceDocs {
+		if !f.OriginID.IsZero() {
+			origins[f.ID] = f.OriginID
+		}
+	}
+
+	sourceIDs := make(map[*snapshotFile]bson.ObjectID, len(sourceFiles))
+	for i := range sourceFiles {
+		f := &sourceFiles[i]
+		sourceIDs[f] = f.FileID
+		if origin, ok := origins[f.FileID]; ok {
+			f.FileID = origin
+		}
+	}
+	if base.ProjectID == m.source.ID {
+		for i := range baseFiles {
+			if origin, ok := origins[baseFiles[i].FileID]; ok {
+				baseFiles[i].FileID = origin
+			}
+		}
+	}
+
+	sameFile := func(b, h *snapshotFile) bool { return b.FileID == h.FileID }
+	sameName := func(b, h *snapshotFile) bool { return b.Name == h.Name }
+
+	inTarget := map[*snapshotFile]*snapshotFile{}
+	targetByName := make(map[string]*snapshotFile, len(targetFiles))
+	for _, p := range pairSnapshots(baseFiles, targetFiles, sameFile, sameName) {
+		if p.base != nil {
+			inTarget[p.base] = p.head
+		}
+		if p.head != nil {
+			targetByName[p.head.Name] = p.head
+		}
+	}
+	inSource := map[*snapshotFile]*snapshotFile{}
+	var addedInSource []*snapshotFile
+	for _, p := range pairSnapshots(baseFiles, sourceFiles, sameFile, sameName) {
+		switch {
+		case p.base == nil:
+			addedInSource = append(addedInSource, p.head)
+		case p.head != nil:
+			inSource[p.base] = p.head
+		}
+	}
+
+	for i := range baseFiles {
+		b := &baseFiles[i]
+		t, s := inTarget[b], inSource[b]
+		switch {
+		case t == nil && s == nil:
+			// Removed on both sides
+		case s == nil:
+			if t.Content == b.Content {
+				m.deleteFile(t)
+				continue
+			}
+			m.conflict(&model.MergeConflict{Path: t.Name, Kind: model.MergeConflictKindDelete},
+				func() { m.deleteFile(t) },
+				func(content string) { m.updateFile(t, t.Name, content) },
+			)
+		case t == nil:
+			if s.Content == b.Content {
+				continue
+			}
+			m.conflict(&model.MergeConflict{Path: s.Name, Kind: model.MergeConflictKindDelete},
+				func() { m.createFile(s, s.Content, sourceIDs[s]) },
+				func(content string) { m.createFile(s, content, sourceIDs[s]) },
+			)
+		default:
+			m.mergeFile(b, t, s, sourceIDs[s], targetByName)
+		}
+	}
+
+	// Added in the source, possibly at a path the target now uses as well
+	for _, s := range addedInSource {
+		t, ok := targetByName[s.Name]
+		if !ok {
+			m.createFile(s, s.Content, sourceIDs[s])
+			continue
+		}
+		m.mergeFile(&snapshotFile{}, t, s, sourceIDs[s], targetByName)
+	}
+	return nil
+}
+
+// mergeFile merges the changes to one file. A rename in the source is taken
+// unless the target renamed the file too or uses the new name already.
+func (m *merger) mergeFile(b, t, s *snapshotFile, sourceID bson.ObjectID, targetByName map[string]*snapshotFile) {
+	if s.FileID != t.FileID {
+		m.links[sourceID] = t.FileID
+	}
+
+	name := t.Name
+	if b.Name != "" && t.Name == b.Name && s.Name != b.Name && targetByName[s.Name] == nil {
+		name = s.Name
+	}
+
+	merged, conflicts := diff.Merge3(b.Content, t.Content, s.Content, m.target.ProjectName, m.source.ProjectName)
+	if len(conflicts) == 0 {
+		if merged != t.Content || name != t.Name {
+			m.updateFile(t, name, merged)
+		}
+		return
+	}
+
+	kind := model.MergeConflictKindContent
+	if b.Name == "" {
+		kind = model.MergeConflictKindAdd
+	}
+	c := &model.MergeConflict{
+		Path:    t.Name,
+		Kind:    kind,
+		Content: &merged,
+		Hunks:   make([]*model.ConflictHunk, len(conflicts)),
+	}
+	for i, h := range conflicts {
+		c.Hunks[i] = &model.ConflictHunk{
+			Line:   int32(h.Line),
+			Base:   h.Base,
+			Target: h.Ours,
+			Source: h.Theirs,
+		}
+	}
+	m.conflict(c,
+		func() { m.updateFile(t, t.Name, s.Content) },
+		func(content string) { m.updateFile(t, t.Name, content) },
+	)
+}
+
+func (m *merger) updateFile(t *snapshotFile, name, content string) {
+	m.ops = append(m.ops, mergeOp{path: name, apply: func(ctx context.Context) error {
+		now := time.Now()
+		_, err := m.r.DB.Collection("files").UpdateOne(ctx,
+			bson.M{"_id": t.FileID},
+			bson.M{"$set": bson.M{"name": name, "updatedAt": now, "updatedBy": m.userID}},
+		)
+		if err != nil {
+			return err
+		}
+		_, err = m.r.DB.Collection("working_files").UpdateMany(ctx,
+			bson.M{"fileId": t.FileID},
+			bson.M{"$set": bson.M{"content": content, "updatedAt": now}},
+		)
+		return err
+	}})
+}
+
+func (m *merger) createFile(s *snapshotFile, content string, sourceID bson.ObjectID) {
+	m.ops = append(m.ops, mergeOp{path: s.Name, apply: func(ctx context.Context) error {
+		now := time.Now()
+		result, err := m.r.DB.Collection("files").InsertOne(ctx, FileDoc{
+			ProjectID: m.target.ID,
+			Name:      s.Name,
+			Type:      s.Type,
+			CreatedAt: now,
+			UpdatedAt: now,
+			UpdatedBy: m.userID,
+		})
+		if err != nil {
+			return err
+		}
+		fileID := result.InsertedID.(bson.ObjectID)
+		m.links[sourceID] = fileID
+
+		_, err = m.r.DB.Collection("working_files").InsertOne(ctx, WorkingFileDoc{
+			FileID:    fileID,
+			ProjectID: m.target.ID,
+			Content:   content,
+			UpdatedAt: now,
+		})
+		return err
+	}})
+}
+
+func (m *merger) deleteFile(t *snapshotFile) {
+	m.ops = append(m.ops, mergeOp{path: t.Name, apply: func(ctx context.Context) error {
+		m.r.DB.Collection("working_files").DeleteMany(ctx, bson.M{"fileId": t.FileID})
+		_, err := m.r.DB.Collection("files").DeleteOne(ctx, bson.M{"_id": t.FileID})
+		return err
+	}})
+}
+
+// planAssets merges the assets, paired by name and compared by hash. A common
+// ancestor created before assets were recorded leaves them alone.
+func (m *merger) planAssets(ctx context.Context, base, sourceVersion VersionDoc) error {
+	if !base.Assets {
+		return nil
+	}
+
+	baseAssets, err := m.r.versionAssetSnapshot(ctx, base.ID)
+	if err != nil {
+		return err
+	}
+	sourceAssets, err := m.r.loadVersionAssets(ctx, sourceVersion.ID)
+	if err != nil {
+		return err
+	}
+	cursor, err := m.r.DB.Collection("assets").Find(ctx, bson.M{"projectId": m.target.ID})
+	if err != nil {
+		return err
+	}
+	var targetAssets []AssetDoc
+	if err := cursor.All(ctx, &targetAssets); err != nil {
+		return err
+	}
+
+	names := map[string]bool{}
+	baseHash := make(map[string]string, len(baseAssets))
+	for _, a := range baseAssets {
+		baseHash[a.Name] = a.Hash
+		names[a.Name] = true
+	}
+	bySource := make(map[string]VersionAssetDoc, len(sourceAssets))
+	for _, a := range sourceAssets {
+		bySource[a.Name] = a
+		names[a.Name] = true
+	}
+	byTarget := make(map[string]AssetDoc, len(targetAssets))
+	targetHash := make(map[string]string, len(targetAssets))
+	for _, a := range targetAssets {
+		name := assetName(a.Name, a.Path)
+		hash, _, err := m.r.assetHash(ctx, a)
+		if err != nil {
+			return fmt.Errorf("failed to hash asset %s: %w", name, err)
+		}
+		byTarget[name] = a
+		targetHash[name] = hash
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		s, inSource := bySource[name]
+		t, inTarget := byTarget[name]
+		// Unchanged in the source, or changed alike on both sides
+		if s.Hash == baseHash[name] || s.Hash == targetHash[name] {
+			continue
+		}
+
+		takeSource := func() {
+			if inSource {
+				m.putAsset(name, s, t, inTarget)
+			} else {
+				m.deleteAsset(name, t)
+			}
+		}
+		if targetHash[name] == baseHash[name] {
+			takeSource()
+			continue
+		}
+		m.conflict(&model.MergeConflict{Path: name, Kind: model.MergeConflictKindAsset}, takeSource, nil)
+	}
+	return nil
+}
+
+// putAsset copies an asset of the source version into the target
+func (m *merger) putAsset(name string, va VersionAssetDoc, asset AssetDoc, exists bool) {
+	m.ops = append(m.ops, mergeOp{path: name, apply: func(ctx context.Context) error {
+		key := paths.AssetObject("project", m.target.ID.Hex(), name)
+		if exists {
+			if err := assetstore.DetachTemplates(ctx, m.r.DB, m.r.Minio, m.r.Bucket, key); err != nil {
+				return err
+			}
+		}
+
+		src := minio.CopySrcOptions{Bucket: m.r.Bucket, Object: va.Object}
+		dst := minio.CopyDestOptions{Bucket: m.r.Bucket, Object: key}
+		info, err := m.r.Minio.CopyObject(ctx, dst, src)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if !exists {
+			_, err = m.r.DB.Collection("assets").InsertOne(ctx, AssetDoc{
+				ProjectID: m.target.ID,
+				Name:      name,
+				Path:      key,
+				MimeType:  va.MimeType,
+				Size:      va.Size,
+				CreatedAt: now,
+				UpdatedAt: now,
+				UpdatedBy: m.userID,
+				Hash:      va.Hash,
+				HashETag:  info.ETag,
+			})
+			return err
+		}
+
+		if asset.Path != key {
+			m.r.removeAssetObject(ctx, asset.Path)
+		}
+		_, err = m.r.DB.Collection("assets").UpdateOne(ctx,
+			bson.M{"_id": asset.ID},
+			bson.M{"$set": bson.M{
+				"path":      key,
+				"mimeType":  va.MimeType,
+				"size":      va.Size,
+				"updatedAt": now,
+				"updatedBy": m.userID,
+				"hash":      va.Hash,
+				"hashETag":  info.ETag,
+			}},
+		)
+		return err
+	}})
+}
+
+func (m *merger) deleteAsset(name string, asset AssetDoc) {
+	m.ops = append(m.ops, mergeOp{path: name, apply: func(ctx context.Context) error {
+		m.r.removeAssetObject(ctx, asset.Path)
+		_, err := m.r.DB.Collection("assets").DeleteOne(ctx, bson.M{"_id": asset.ID})
+		return err
+	}})
+}
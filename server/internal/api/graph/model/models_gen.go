@@ -9,13 +9,81 @@ import (
 	"strconv"
 )
 
+type ProjectEntry interface {
+	IsProjectEntry()
+	GetID() string
+	GetProjectID() string
+	GetTreePath() string
+	GetSize() int32
+	GetMimeType() string
+	GetKind() EntryKind
+	GetUpdatedAt() string
+	GetLastModifiedBy() *string
+}
+
 type Asset struct {
-	ID        string `json:"id"`
-	ProjectID string `json:"projectId"`
-	Path      string `json:"path"`
-	MimeType  string `json:"mimeType"`
-	Size      int32  `json:"size"`
-	CreatedAt string `json:"createdAt"`
+	ID             string    `json:"id"`
+	ProjectID      string    `json:"projectId"`
+	Name           string    `json:"name"`
+	Path           string    `json:"path"`
+	TreePath       string    `json:"treePath"`
+	MimeType       string    `json:"mimeType"`
+	Size           int32     `json:"size"`
+	Kind           EntryKind `json:"kind"`
+	CreatedAt      string    `json:"createdAt"`
+	UpdatedAt      string    `json:"updatedAt"`
+	LastModifiedBy *string   `json:"lastModifiedBy,omitempty"`
+}
+
+func (Asset) IsProjectEntry()                 {}
+func (this Asset) GetID() string              { return this.ID }
+func (this Asset) GetProjectID() string       { return this.ProjectID }
+func (this Asset) GetTreePath() string        { return this.TreePath }
+func (this Asset) GetSize() int32             { return this.Size }
+func (this Asset) GetMimeType() string        { return this.MimeType }
+func (this Asset) GetKind() EntryKind         { return this.Kind }
+func (this Asset) GetUpdatedAt() string       { return this.UpdatedAt }
+func (this Asset) GetLastModifiedBy() *string { return this.LastModifiedBy }
+
+type AssetDiff struct {
+	Path    string           `json:"path"`
+	OldPath *string          `json:"oldPath,omitempty"`
+	Status  FileChangeStatus `json:"status"`
+	OldSize *int32           `json:"oldSize,omitempty"`
+	NewSize *int32           `json:"newSize,omitempty"`
+}
+
+type AssetUpload struct {
+	UploadID  string `json:"uploadId"`
+	URL       string `json:"url"`
+	Method    string `json:"method"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+type BlameLine struct {
+	Line    int32    `json:"line"`
+	Content string   `json:"content"`
+	Version *Version `json:"version"`
+}
+
+type CompileJob struct {
+	ID          string   `json:"id"`
+	ProjectID   *string  `json:"projectId,omitempty"`
+	Status      string   `json:"status"`
+	MainFile    *string  `json:"mainFile,omitempty"`
+	CreatedAt   string   `json:"createdAt"`
+	FinishedAt  *string  `json:"finishedAt,omitempty"`
+	PDFURL      *string  `json:"pdfUrl,omitempty"`
+	Error       *string  `json:"error,omitempty"`
+	Pinned      bool     `json:"pinned"`
+	Diagnostics []string `json:"diagnostics"`
+}
+
+type ConflictHunk struct {
+	Line   int32    `json:"line"`
+	Base   []string `json:"base"`
+	Target []string `json:"target"`
+	Source []string `json:"source"`
 }
 
 type CreateAssetInput struct {
@@ -34,18 +102,121 @@ type CreateTemplateInput struct {
 }
 
 type CreateVersionInput struct {
-	ProjectID string  `json:"projectId"`
-	Message   *string `json:"message,omitempty"`
+	ProjectID string   `json:"projectId"`
+	Message   *string  `json:"message,omitempty"`
+	Labels    []string `json:"labels,omitempty"`
+}
+
+type DiffHunk struct {
+	Header   string      `json:"header"`
+	OldStart int32       `json:"oldStart"`
+	OldLines int32       `json:"oldLines"`
+	NewStart int32       `json:"newStart"`
+	NewLines int32       `json:"newLines"`
+	Lines    []*DiffLine `json:"lines"`
+}
+
+type DiffLine struct {
+	Kind    DiffLineKind `json:"kind"`
+	Content string       `json:"content"`
+	OldLine *int32       `json:"oldLine,omitempty"`
+	NewLine *int32       `json:"newLine,omitempty"`
 }
 
 type File struct {
-	ID          string       `json:"id"`
-	ProjectID   string       `json:"projectId"`
-	Name        string       `json:"name"`
-	Type        FileType     `json:"type"`
-	CreatedAt   string       `json:"createdAt"`
-	UpdatedAt   string       `json:"updatedAt"`
-	WorkingFile *WorkingFile `json:"workingFile"`
+	ID             string          `json:"id"`
+	ProjectID      string          `json:"projectId"`
+	Name           string          `json:"name"`
+	Type           FileType        `json:"type"`
+	CreatedAt      string          `json:"createdAt"`
+	UpdatedAt      string          `json:"updatedAt"`
+	WorkingFile    *WorkingFile    `json:"workingFile"`
+	TreePath       string          `json:"treePath"`
+	Size           int32           `json:"size"`
+	MimeType       string          `json:"mimeType"`
+	Kind           EntryKind       `json:"kind"`
+	LastModifiedBy *string         `json:"lastModifiedBy,omitempty"`
+	History        []*FileRevision `json:"history"`
+}
+
+func (File) IsProjectEntry()                 {}
+func (this File) GetID() string              { return this.ID }
+func (this File) GetProjectID() string       { return this.ProjectID }
+func (this File) GetTreePath() string        { return this.TreePath }
+func (this File) GetSize() int32             { return this.Size }
+func (this File) GetMimeType() string        { return this.MimeType }
+func (this File) GetKind() EntryKind         { return this.Kind }
+func (this File) GetUpdatedAt() string       { return this.UpdatedAt }
+func (this File) GetLastModifiedBy() *string { return this.LastModifiedBy }
+
+type FileBlame struct {
+	FileID    string       `json:"fileId"`
+	VersionID string       `json:"versionId"`
+	Name      string       `json:"name"`
+	Lines     []*BlameLine `json:"lines"`
+}
+
+type FileDiff struct {
+	Path      string           `json:"path"`
+	OldPath   *string          `json:"oldPath,omitempty"`
+	Status    FileChangeStatus `json:"status"`
+	Additions int32            `json:"additions"`
+	Deletions int32            `json:"deletions"`
+	Hunks     []*DiffHunk      `json:"hunks"`
+}
+
+type FileRevision struct {
+	Version   *Version         `json:"version"`
+	Name      string           `json:"name"`
+	Status    FileChangeStatus `json:"status"`
+	Additions int32            `json:"additions"`
+	Deletions int32            `json:"deletions"`
+}
+
+type Folder struct {
+	ID        string `json:"id"`
+	ProjectID string `json:"projectId"`
+	Path      string `json:"path"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type ForkOrigin struct {
+	ProjectID   string  `json:"projectId"`
+	ProjectName *string `json:"projectName,omitempty"`
+	VersionID   string  `json:"versionId"`
+	ForkedAt    string  `json:"forkedAt"`
+}
+
+type GitSync struct {
+	Remote   *string `json:"remote,omitempty"`
+	Branch   string  `json:"branch"`
+	Head     *string `json:"head,omitempty"`
+	SyncedAt *string `json:"syncedAt,omitempty"`
+}
+
+type GitSyncInput struct {
+	Remote *string `json:"remote,omitempty"`
+	Branch *string `json:"branch,omitempty"`
+}
+
+type MergeConflict struct {
+	Path    string            `json:"path"`
+	Kind    MergeConflictKind `json:"kind"`
+	Content *string           `json:"content,omitempty"`
+	Hunks   []*ConflictHunk   `json:"hunks"`
+}
+
+type MergeResolution struct {
+	Path    string     `json:"path"`
+	Side    *MergeSide `json:"side,omitempty"`
+	Content *string    `json:"content,omitempty"`
+}
+
+type MergeResult struct {
+	Version   *Version         `json:"version,omitempty"`
+	Applied   []string         `json:"applied"`
+	Conflicts []*MergeConflict `json:"conflicts"`
 }
 
 type Mutation struct {
@@ -61,22 +232,60 @@ type NewProjectInput struct {
 	ProjectName string `json:"projectName"`
 }
 
+type PDFDiff struct {
+	ID           string         `json:"id"`
+	BaseJobID    string         `json:"baseJobId"`
+	HeadJobID    string         `json:"headJobId"`
+	Status       string         `json:"status"`
+	Error        *string        `json:"error,omitempty"`
+	PageCount    int32          `json:"pageCount"`
+	ChangedPages []int32        `json:"changedPages"`
+	Pages        []*PDFPageDiff `json:"pages"`
+}
+
+type PDFPageDiff struct {
+	Page          int32   `json:"page"`
+	Status        string  `json:"status"`
+	ChangedPixels int32   `json:"changedPixels"`
+	ChangedRatio  float64 `json:"changedRatio"`
+	ImageURL      *string `json:"imageUrl,omitempty"`
+}
+
 type Project struct {
-	ID              string     `json:"id"`
-	ProjectName     string     `json:"projectName"`
-	CreatedAt       string     `json:"createdAt"`
-	LastEditedAt    string     `json:"lastEditedAt"`
-	OwnerID         string     `json:"ownerId"`
-	CollaboratorIds []string   `json:"collaboratorIds"`
-	RootFileID      string     `json:"rootFileId"`
-	Files           []*File    `json:"files"`
-	Assets          []*Asset   `json:"assets"`
-	Versions        []*Version `json:"versions"`
+	ID              string      `json:"id"`
+	ProjectName     string      `json:"projectName"`
+	CreatedAt       string      `json:"createdAt"`
+	LastEditedAt    string      `json:"lastEditedAt"`
+	OwnerID         string      `json:"ownerId"`
+	CollaboratorIds []string    `json:"collaboratorIds"`
+	RootFileID      string      `json:"rootFileId"`
+	Files           []*File     `json:"files"`
+	Assets          []*Asset    `json:"assets"`
+	Versions        []*Version  `json:"versions"`
+	LatestPDF       *CompileJob `json:"latestPdf,omitempty"`
+	Tree            []*TreeNode `json:"tree"`
+	ForkedFrom      *ForkOrigin `json:"forkedFrom,omitempty"`
+	Git             *GitSync    `json:"git,omitempty"`
+}
+
+type ProjectEntryConnection struct {
+	Entries     []ProjectEntry `json:"entries"`
+	TotalCount  int32          `json:"totalCount"`
+	EndCursor   *string        `json:"endCursor,omitempty"`
+	HasNextPage bool           `json:"hasNextPage"`
 }
 
 type Query struct {
 }
 
+type RequestAssetUploadInput struct {
+	ProjectID string  `json:"projectId"`
+	Path      string  `json:"path"`
+	Size      int32   `json:"size"`
+	MimeType  string  `json:"mimeType"`
+	Checksum  *string `json:"checksum,omitempty"`
+}
+
 type Subscription struct {
 }
 
@@ -110,6 +319,22 @@ type TemplateFile struct {
 	Content    string   `json:"content"`
 }
 
+type TreeNode struct {
+	Name     string       `json:"name"`
+	Path     string       `json:"path"`
+	Kind     TreeNodeKind `json:"kind"`
+	File     *File        `json:"file,omitempty"`
+	Asset    *Asset       `json:"asset,omitempty"`
+	Children []*TreeNode  `json:"children"`
+}
+
+type UpdateVersionInput struct {
+	Message *string  `json:"message,omitempty"`
+	Labels  []string `json:"labels,omitempty"`
+	Pinned  *bool    `json:"pinned,omitempty"`
+	Locked  *bool    `json:"locked,omitempty"`
+}
+
 type UpdateWorkingFileInput struct {
 	FileID  string `json:"fileId"`
 	Content string `json:"content"`
@@ -122,11 +347,39 @@ type User struct {
 }
 
 type Version struct {
-	ID        string         `json:"id"`
-	ProjectID string         `json:"projectId"`
-	CreatedAt string         `json:"createdAt"`
-	Message   *string        `json:"message,omitempty"`
-	Files     []*VersionFile `json:"files"`
+	ID                  string          `json:"id"`
+	ProjectID           string          `json:"projectId"`
+	CreatedAt           string          `json:"createdAt"`
+	Message             *string         `json:"message,omitempty"`
+	Kind                VersionKind     `json:"kind"`
+	AuthorID            *string         `json:"authorId,omitempty"`
+	Labels              []string        `json:"labels"`
+	Pinned              bool            `json:"pinned"`
+	Locked              bool            `json:"locked"`
+	Files               []*VersionFile  `json:"files"`
+	Assets              []*VersionAsset `json:"assets"`
+	PDF                 *CompileJob     `json:"pdf,omitempty"`
+	MergedFromVersionID *string         `json:"mergedFromVersionId,omitempty"`
+	GitCommit           *string         `json:"gitCommit,omitempty"`
+	Cursor              string          `json:"cursor"`
+}
+
+type VersionAsset struct {
+	ID        string `json:"id"`
+	VersionID string `json:"versionId"`
+	AssetID   string `json:"assetId"`
+	Name      string `json:"name"`
+	Hash      string `json:"hash"`
+	ObjectKey string `json:"objectKey"`
+	MimeType  string `json:"mimeType"`
+	Size      int32  `json:"size"`
+}
+
+type VersionDiff struct {
+	BaseVersionID string       `json:"baseVersionId"`
+	HeadVersionID *string      `json:"headVersionId,omitempty"`
+	Files         []*FileDiff  `json:"files"`
+	Assets        []*AssetDiff `json:"assets"`
 }
 
 type VersionFile struct {
@@ -146,6 +399,177 @@ type WorkingFile struct {
 	UpdatedAt string `json:"updatedAt"`
 }
 
+type DiffLineKind string
+
+const (
+	DiffLineKindContext DiffLineKind = "CONTEXT"
+	DiffLineKindAdded   DiffLineKind = "ADDED"
+	DiffLineKindRemoved DiffLineKind = "REMOVED"
+)
+
+var AllDiffLineKind = []DiffLineKind{
+	DiffLineKindContext,
+	DiffLineKindAdded,
+	DiffLineKindRemoved,
+}
+
+func (e DiffLineKind) IsValid() bool {
+	switch e {
+	case DiffLineKindContext, DiffLineKindAdded, DiffLineKindRemoved:
+		return true
+	}
+	return false
+}
+
+func (e DiffLineKind) String() string {
+	return string(e)
+}
+
+func (e *DiffLineKind) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = DiffLineKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid DiffLineKind", str)
+	}
+	return nil
+}
+
+func (e DiffLineKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *DiffLineKind) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e DiffLineKind) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type EntryKind string
+
+const (
+	EntryKindText   EntryKind = "TEXT"
+	EntryKindBinary EntryKind = "BINARY"
+)
+
+var AllEntryKind = []EntryKind{
+	EntryKindText,
+	EntryKindBinary,
+}
+
+func (e EntryKind) IsValid() bool {
+	switch e {
+	case EntryKindText, EntryKindBinary:
+		return true
+	}
+	return false
+}
+
+func (e EntryKind) String() string {
+	return string(e)
+}
+
+func (e *EntryKind) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = EntryKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid EntryKind", str)
+	}
+	return nil
+}
+
+func (e EntryKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *EntryKind) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e EntryKind) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type FileChangeStatus string
+
+const (
+	FileChangeStatusAdded    FileChangeStatus = "ADDED"
+	FileChangeStatusRemoved  FileChangeStatus = "REMOVED"
+	FileChangeStatusRenamed  FileChangeStatus = "RENAMED"
+	FileChangeStatusModified FileChangeStatus = "MODIFIED"
+)
+
+var AllFileChangeStatus = []FileChangeStatus{
+	FileChangeStatusAdded,
+	FileChangeStatusRemoved,
+	FileChangeStatusRenamed,
+	FileChangeStatusModified,
+}
+
+func (e FileChangeStatus) IsValid() bool {
+	switch e {
+	case FileChangeStatusAdded, FileChangeStatusRemoved, FileChangeStatusRenamed, FileChangeStatusModified:
+		return true
+	}
+	return false
+}
+
+func (e FileChangeStatus) String() string {
+	return string(e)
+}
+
+func (e *FileChangeStatus) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = FileChangeStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid FileChangeStatus", str)
+	}
+	return nil
+}
+
+func (e FileChangeStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *FileChangeStatus) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e FileChangeStatus) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
 type FileType string
 
 const (
@@ -208,3 +632,233 @@ func (e FileType) MarshalJSON() ([]byte, error) {
 	e.MarshalGQL(&buf)
 	return buf.Bytes(), nil
 }
+
+type MergeConflictKind string
+
+const (
+	MergeConflictKindContent MergeConflictKind = "CONTENT"
+	MergeConflictKindDelete  MergeConflictKind = "DELETE"
+	MergeConflictKindAdd     MergeConflictKind = "ADD"
+	MergeConflictKindAsset   MergeConflictKind = "ASSET"
+)
+
+var AllMergeConflictKind = []MergeConflictKind{
+	MergeConflictKindContent,
+	MergeConflictKindDelete,
+	MergeConflictKindAdd,
+	MergeConflictKindAsset,
+}
+
+func (e MergeConflictKind) IsValid() bool {
+	switch e {
+	case MergeConflictKindContent, MergeConflictKindDelete, MergeConflictKindAdd, MergeConflictKindAsset:
+		return true
+	}
+	return false
+}
+
+func (e MergeConflictKind) String() string {
+	return string(e)
+}
+
+func (e *MergeConflictKind) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = MergeConflictKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid MergeConflictKind", str)
+	}
+	return nil
+}
+
+func (e MergeConflictKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *MergeConflictKind) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e MergeConflictKind) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type MergeSide string
+
+const (
+	MergeSideTarget MergeSide = "TARGET"
+	MergeSideSource MergeSide = "SOURCE"
+)
+
+var AllMergeSide = []MergeSide{
+	MergeSideTarget,
+	MergeSideSource,
+}
+
+func (e MergeSide) IsValid() bool {
+	switch e {
+	case MergeSideTarget, MergeSideSource:
+		return true
+	}
+	return false
+}
+
+func (e MergeSide) String() string {
+	return string(e)
+}
+
+func (e *MergeSide) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = MergeSide(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid MergeSide", str)
+	}
+	return nil
+}
+
+func (e MergeSide) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *MergeSide) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e MergeSide) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type TreeNodeKind string
+
+const (
+	TreeNodeKindFolder TreeNodeKind = "FOLDER"
+	TreeNodeKindFile   TreeNodeKind = "FILE"
+	TreeNodeKindAsset  TreeNodeKind = "ASSET"
+)
+
+var AllTreeNodeKind = []TreeNodeKind{
+	TreeNodeKindFolder,
+	TreeNodeKindFile,
+	TreeNodeKindAsset,
+}
+
+func (e TreeNodeKind) IsValid() bool {
+	switch e {
+	case TreeNodeKindFolder, TreeNodeKindFile, TreeNodeKindAsset:
+		return true
+	}
+	return false
+}
+
+func (e TreeNodeKind) String() string {
+	return string(e)
+}
+
+func (e *TreeNodeKind) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = TreeNodeKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid TreeNodeKind", str)
+	}
+	return nil
+}
+
+func (e TreeNodeKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *TreeNodeKind) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e TreeNodeKind) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type VersionKind string
+
+const (
+	VersionKindManual  VersionKind = "MANUAL"
+	VersionKindAuto    VersionKind = "AUTO"
+	VersionKindCompile VersionKind = "COMPILE"
+	VersionKindMerge   VersionKind = "MERGE"
+)
+
+var AllVersionKind = []VersionKind{
+	VersionKindManual,
+	VersionKindAuto,
+	VersionKindCompile,
+	VersionKindMerge,
+}
+
+func (e VersionKind) IsValid() bool {
+	switch e {
+	case VersionKindManual, VersionKindAuto, VersionKindCompile, VersionKindMerge:
+		return true
+	}
+	return false
+}
+
+func (e VersionKind) String() string {
+	return string(e)
+}
+
+func (e *VersionKind) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = VersionKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid VersionKind", str)
+	}
+	return nil
+}
+
+func (e VersionKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *VersionKind) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e VersionKind) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
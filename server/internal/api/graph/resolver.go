@@ -2,8 +2,15 @@ package graph
 
 import (
 	"context"
+	"fmt"
 	"gollaboratex/server/internal/api/graph/model"
+	"gollaboratex/server/internal/assetpolicy"
+	"gollaboratex/server/internal/paths"
+	"gollaboratex/server/internal/worker"
+	"log"
+	"path"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/minio/minio-go/v7"
@@ -11,6 +18,12 @@ import (
 	"go.mongodb.org/mongo-driver/v2/mongo"
 )
 
+// Page sizes for paginated lists
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
 // This file will not be regenerated automatically.
 //
 // It serves as dependency injection for your app, add any dependencies you require here.
@@ -28,10 +41,15 @@ type UserDoc struct {
 type AssetDoc struct {
 	ID        bson.ObjectID `bson:"_id,omitempty"`
 	ProjectID bson.ObjectID `bson:"projectId"`
-	Path      string        `bson:"path"`
+	Name      string        `bson:"name,omitempty"` // Path inside the project tree, e.g. figures/plot.png
+	Path      string        `bson:"path"`           // Object key in MinIO
 	MimeType  string        `bson:"mimeType"`
 	Size      int           `bson:"size"`
 	CreatedAt time.Time     `bson:"createdAt"`
+	UpdatedAt time.Time     `bson:"updatedAt,omitempty"`
+	UpdatedBy bson.ObjectID `bson:"updatedBy,omitempty"`
+	Hash      string        `bson:"hash,omitempty"`     // SHA-256 of the content, cached for versions
+	HashETag  string        `bson:"hashETag,omitempty"` // Object ETag the cached hash belongs to
 }
 
 type ProjectDoc struct {
@@ -42,6 +60,24 @@ type ProjectDoc struct {
 	RootFileID      bson.ObjectID   `bson:"rootFileId"`
 	LastEditedAt    time.Time       `bson:"lastEditedAt"`
 	CreatedAt       time.Time       `bson:"createdAt"`
+	AutoSnapshotAt  time.Time       `bson:"autoSnapshotAt,omitempty"` // Last time pending edits were considered for an automatic version
+
+	// Lineage of forks: the parent project and the version forked from
+	ForkOf        bson.ObjectID `bson:"forkOf,omitempty"`
+	ForkVersionID bson.ObjectID `bson:"forkVersionId,omitempty"`
+	ForkedAt      time.Time     `bson:"forkedAt,omitempty"`
+	// Version of this fork last merged into its parent, the common ancestor
+	// of the next merge
+	MergeBaseVersionID bson.ObjectID `bson:"mergeBaseVersionId,omitempty"`
+	Git                *GitSyncDoc   `bson:"git,omitempty"`
+}
+
+// GitSyncDoc is where a project's versions are exported to in Git
+type GitSyncDoc struct {
+	Remote   string    `bson:"remote,omitempty"` // URL or, where allowed, path; may hold credentials
+	Branch   string    `bson:"branch"`
+	Head     string    `bson:"head,omitempty"` // Last commit exported or pulled
+	SyncedAt time.Time `bson:"syncedAt,omitempty"`
 }
 
 type FileDoc struct {
@@ -51,6 +87,8 @@ type FileDoc struct {
 	Type      string        `bson:"type"`
 	CreatedAt time.Time     `bson:"createdAt"`
 	UpdatedAt time.Time     `bson:"updatedAt"`
+	UpdatedBy bson.ObjectID `bson:"updatedBy,omitempty"`
+	OriginID  bson.ObjectID `bson:"originId,omitempty"` // File of the parent project a fork's file was copied from
 }
 
 type WorkingFileDoc struct {
@@ -62,10 +100,29 @@ type WorkingFileDoc struct {
 }
 
 type VersionDoc struct {
-	ID        bson.ObjectID `bson:"_id,omitempty"`
-	ProjectID bson.ObjectID `bson:"projectId"`
-	Message   *string       `bson:"message,omitempty"`
-	CreatedAt time.Time     `bson:"createdAt"`
+	ID         bson.ObjectID `bson:"_id,omitempty"`
+	ProjectID  bson.ObjectID `bson:"projectId"`
+	Message    *string       `bson:"message,omitempty"`
+	PdfJobID   string        `bson:"pdfJobId,omitempty"`
+	CreatedAt  time.Time     `bson:"createdAt"`
+	Kind       string        `bson:"kind,omitempty"`       // One of the Version* kinds; empty for manual versions of older releases
+	RootFileID bson.ObjectID `bson:"rootFileId,omitempty"` // Root file when the version was created
+	Assets     bool          `bson:"assets,omitempty"`     // Asset set recorded; older versions hold text files only
+	AuthorID   bson.ObjectID `bson:"authorId,omitempty"`
+	Labels     []string      `bson:"labels,omitempty"`
+	Pinned     bool          `bson:"pinned,omitempty"`     // Never thinned
+	Locked     bool          `bson:"locked,omitempty"`     // Neither changed nor deleted until unlocked
+	MergedFrom bson.ObjectID `bson:"mergedFrom,omitempty"` // Version of the fork a merge version merged
+	GitCommit  string        `bson:"gitCommit,omitempty"`  // Commit the version was exported to or pulled from
+}
+
+// rootFile returns the root file recorded with the version, or projectRoot
+// for versions created before it was recorded
+func (v VersionDoc) rootFile(projectRoot bson.ObjectID) bson.ObjectID {
+	if v.RootFileID.IsZero() {
+		return projectRoot
+	}
+	return v.RootFileID
 }
 
 type VersionFileDoc struct {
@@ -74,7 +131,22 @@ type VersionFileDoc struct {
 	FileID    bson.ObjectID `bson:"fileId"`
 	Name      string        `bson:"name"`
 	Type      string        `bson:"type"`
-	Content   string        `bson:"content"`
+	Hash      string        `bson:"hash,omitempty"`    // Blob holding the content
+	Content   string        `bson:"content,omitempty"` // Inline content of files not yet moved to a blob; filled from the blob on load
+}
+
+// VersionAssetDoc records an asset as it was when a version was created.
+// Object is a copy under the project's version prefix shared by every
+// version holding the same content.
+type VersionAssetDoc struct {
+	ID        bson.ObjectID `bson:"_id,omitempty"`
+	VersionID bson.ObjectID `bson:"versionId"`
+	AssetID   bson.ObjectID `bson:"assetId"`
+	Name      string        `bson:"name"`
+	Hash      string        `bson:"hash"`
+	Object    string        `bson:"object"`
+	MimeType  string        `bson:"mimeType"`
+	Size      int           `bson:"size"`
 }
 
 // =============================================
@@ -103,6 +175,7 @@ type TemplateFileDoc struct {
 type TemplateAssetDoc struct {
 	ID         bson.ObjectID `bson:"_id,omitempty"`
 	TemplateID bson.ObjectID `bson:"templateId"`
+	Name       string        `bson:"name,omitempty"`
 	Path       string        `bson:"path"`
 	MimeType   string        `bson:"mimeType"`
 	Size       int           `bson:"size"`
@@ -114,17 +187,23 @@ type TemplateAssetDoc struct {
 // =============================================
 
 type Resolver struct {
-	DB     *mongo.Database
-	Minio  *minio.Client
-	Bucket string
+	DB          *mongo.Database
+	Minio       *minio.Client
+	Bucket      string
+	Compile     *worker.Handler
+	AssetPolicy assetpolicy.Policy
+	Retention   RetentionPolicy
+	Git         GitConfig
 }
 
 // NewResolver creates a new resolver with MongoDB database
 func NewResolver(db *mongo.Database, minioClient *minio.Client, bucketName string) *Resolver {
 	return &Resolver{
-		DB:     db,
-		Minio:  minioClient,
-		Bucket: bucketName,
+		DB:          db,
+		Minio:       minioClient,
+		Bucket:      bucketName,
+		AssetPolicy: assetpolicy.DefaultPolicy(),
+		Retention:   DefaultRetentionPolicy(),
 	}
 }
 
@@ -179,6 +258,18 @@ func fileTypeToString(ft model.FileType) string {
 	return string(ft)
 }
 
+// fileMimeType returns the MIME type of a text file type
+func fileMimeType(t string) string {
+	switch t {
+	case "TEX", "CLS", "STY":
+		return "text/x-tex"
+	case "BIB":
+		return "text/x-bibtex"
+	default:
+		return "text/plain"
+	}
+}
+
 func stringToFileType(s string) model.FileType {
 	switch s {
 	case "TEX":
@@ -196,6 +287,120 @@ func stringToFileType(s string) model.FileType {
 	}
 }
 
+// textFileType returns the type of a text file by its extension, the way
+// uploads tell text files from assets; false for names stored as assets
+func textFileType(name string) (model.FileType, bool) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".tex":
+		return model.FileTypeTex, true
+	case ".bib":
+		return model.FileTypeBib, true
+	case ".cls":
+		return model.FileTypeCls, true
+	case ".fls":
+		return model.FileTypeFls, true
+	case ".sty":
+		return model.FileTypeSty, true
+	}
+	return model.FileTypeOther, false
+}
+
+// loadVersionFiles returns the files stored in a version snapshot with their
+// content. Files still stored inline are moved to blobs on the way.
+func (r *Resolver) loadVersionFiles(ctx context.Context, versionID bson.ObjectID) ([]VersionFileDoc, error) {
+	cursor, err := r.DB.Collection("version_files").Find(ctx, bson.M{"versionId": versionID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var versionFiles []VersionFileDoc
+	if err = cursor.All(ctx, &versionFiles); err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	for _, vf := range versionFiles {
+		if vf.Hash != "" {
+			hashes = append(hashes, vf.Hash)
+		}
+	}
+	contents, err := loadBlobs(ctx, r.DB, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, vf := range versionFiles {
+		if vf.Hash == "" {
+			// Best effort: the startup migration catches anything missed here
+			if err := migrateVersionFile(ctx, r.DB, vf); err != nil {
+				log.Printf("Failed to move version file %s to a blob: %v", vf.ID.Hex(), err)
+			}
+			continue
+		}
+		content, ok := contents[vf.Hash]
+		if !ok {
+			return nil, fmt.Errorf("content of %s is missing", vf.Name)
+		}
+		versionFiles[i].Content = content
+	}
+	return versionFiles, nil
+}
+
+// versionMainFile picks the file to compile from a snapshot: the project's root
+// file if it was part of the snapshot, then main.tex, then the first .tex file.
+func versionMainFile(rootFileID bson.ObjectID, files []VersionFileDoc) string {
+	var firstTex string
+	for _, vf := range files {
+		if vf.FileID == rootFileID {
+			return vf.Name
+		}
+		if firstTex == "" && vf.Type == "TEX" {
+			firstTex = vf.Name
+		}
+	}
+	for _, vf := range files {
+		if vf.Name == "main.tex" {
+			return vf.Name
+		}
+	}
+	return firstTex
+}
+
+// compileJobToModel converts a worker CompileJob to a GraphQL CompileJob model
+func compileJobToModel(job *worker.CompileJob) *model.CompileJob {
+	result := &model.CompileJob{
+		ID:          job.JobID,
+		Status:      job.Status,
+		CreatedAt:   job.CreatedAt.Format(time.RFC3339),
+		Pinned:      job.Pinned,
+		Diagnostics: job.Diagnostics,
+	}
+	if result.Diagnostics == nil {
+		result.Diagnostics = []string{}
+	}
+	if job.ProjectID != "" {
+		result.ProjectID = &job.ProjectID
+	} else if job.DocID != "" {
+		result.ProjectID = &job.DocID
+	}
+	if job.MainFile != "" {
+		result.MainFile = &job.MainFile
+	}
+	if !job.FinishedAt.IsZero() {
+		finishedAt := job.FinishedAt.Format(time.RFC3339)
+		result.FinishedAt = &finishedAt
+	}
+	if job.PdfObject != "" {
+		pdfURL := fmt.Sprintf("/api/compile/%s/pdf", job.JobID)
+		result.PDFURL = &pdfURL
+	}
+	if job.Error != "" {
+		result.Error = &job.Error
+	}
+	return result
+}
+
 // templateDocToModel converts a TemplateDoc to a GraphQL Template model
 func (r *Resolver) TemplateDocToModel(ctx context.Context, doc *TemplateDoc) *model.Template {
 	// Fetch template files
@@ -282,3 +487,50 @@ type queryResolver struct{ *Resolver }
 type subscriptionResolver struct{ *Resolver }
 type templateResolver struct{ *Resolver }
 type versionResolver struct{ *Resolver }
+
+// pdfDiffToModel converts a worker diff summary, presigning the diff images
+func (r *Resolver) pdfDiffToModel(ctx context.Context, diff *worker.PdfDiff) *model.PDFDiff {
+	result := &model.PDFDiff{
+		ID:           diff.ID,
+		BaseJobID:    diff.BaseJobID,
+		HeadJobID:    diff.HeadJobID,
+		Status:       diff.Status,
+		PageCount:    int32(len(diff.Pages)),
+		ChangedPages: []int32{},
+		Pages:        []*model.PDFPageDiff{},
+	}
+	if diff.Error != "" {
+		result.Error = &diff.Error
+	}
+	for _, page := range diff.ChangedPages {
+		result.ChangedPages = append(result.ChangedPages, int32(page))
+	}
+
+	for _, page := range diff.Pages {
+		pageDiff := &model.PDFPageDiff{
+			Page:          int32(page.Page),
+			Status:        page.Status,
+			ChangedPixels: int32(page.ChangedPixels),
+			ChangedRatio:  page.ChangedRatio,
+		}
+		if page.ImageObject != "" {
+			presignedURL, err := r.Minio.PresignedGetObject(ctx, r.Compile.PdfsBucket, page.ImageObject, time.Hour, nil)
+			if err == nil {
+				urlStr := presignedURL.String()
+				pageDiff.ImageURL = &urlStr
+			}
+		}
+		result.Pages = append(result.Pages, pageDiff)
+	}
+
+	return result
+}
+
+// assetName returns the path of an asset inside the project tree. Assets
+// uploaded before folders were tracked only have their object key.
+func assetName(name, objectKey string) string {
+	if name != "" {
+		return name
+	}
+	return paths.AssetRelPath(objectKey)
+}
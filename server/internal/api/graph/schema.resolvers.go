@@ -7,15 +7,25 @@ package graph
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"gollaboratex/server/internal/api/graph/model"
+	"gollaboratex/server/internal/assetpolicy"
+	"gollaboratex/server/internal/assetstore"
+	"gollaboratex/server/internal/gitsync"
 	"gollaboratex/server/internal/middleware"
-	"path/filepath"
+	"gollaboratex/server/internal/paths"
+	"gollaboratex/server/internal/worker"
+	"net/http"
+	"os"
+	"strings"
 	"time"
 
+	"github.com/99designs/gqlgen/graphql"
 	"github.com/minio/minio-go/v7"
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 // WorkingFile is the resolver for the workingFile field.
@@ -40,6 +50,25 @@ func (r *fileResolver) WorkingFile(ctx context.Context, obj *model.File) (*model
 	}, nil
 }
 
+// History is the resolver for the history field.
+func (r *fileResolver) History(ctx context.Context, obj *model.File, limit *int32) ([]*model.FileRevision, error) {
+	fileOID, err := toObjectID(obj.ID)
+	if err != nil {
+		return nil, err
+	}
+	projectOID, err := toObjectID(obj.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	n := defaultHistoryLimit
+	if limit != nil && *limit > 0 {
+		n = int(*limit)
+	}
+
+	return r.fileHistory(ctx, projectOID, fileOID, n)
+}
+
 // CreateProject is the resolver for the createProject field.
 func (r *mutationResolver) CreateProject(ctx context.Context, input model.NewProjectInput) (*model.Project, error) {
 	user, err := middleware.GetUserFromContext(ctx)
@@ -167,6 +196,7 @@ func (r *mutationResolver) DeleteProject(ctx context.Context, projectID string)
 
 	// Delete all files
 	r.DB.Collection("files").DeleteMany(ctx, bson.M{"projectId": projectOID})
+	r.DB.Collection("folders").DeleteMany(ctx, bson.M{"projectId": projectOID})
 
 	// Delete project (versions remain)
 	_, err = r.DB.Collection("projects").DeleteOne(ctx, bson.M{"_id": projectOID})
@@ -273,13 +303,27 @@ func (r *mutationResolver) CreateFile(ctx context.Context, input model.NewFileIn
 		return nil, errors.New("access denied")
 	}
 
+	// Names are paths inside the project tree, e.g. chapters/intro.tex
+	name, err := paths.Clean(input.Name)
+	if err != nil {
+		return nil, err
+	}
+	inUse, err := r.pathInUse(ctx, projectOID, name)
+	if err != nil {
+		return nil, err
+	}
+	if inUse {
+		return nil, fmt.Errorf("%s already exists", name)
+	}
+
 	now := time.Now()
 	file := FileDoc{
 		ProjectID: projectOID,
-		Name:      input.Name,
+		Name:      name,
 		Type:      fileTypeToString(input.Type),
 		CreatedAt: now,
 		UpdatedAt: now,
+		UpdatedBy: user.ID,
 	}
 
 	fileResult, err := r.DB.Collection("files").InsertOne(ctx, file)
@@ -306,14 +350,7 @@ func (r *mutationResolver) CreateFile(ctx context.Context, input model.NewFileIn
 		bson.M{"$set": bson.M{"lastEditedAt": now}},
 	)
 
-	return &model.File{
-		ID:        file.ID.Hex(),
-		ProjectID: file.ProjectID.Hex(),
-		Name:      file.Name,
-		Type:      stringToFileType(file.Type),
-		CreatedAt: file.CreatedAt.Format(time.RFC3339),
-		UpdatedAt: file.UpdatedAt.Format(time.RFC3339),
-	}, nil
+	return fileDocToModel(file), nil
 }
 
 // RenameFile is the resolver for the renameFile field.
@@ -339,10 +376,24 @@ func (r *mutationResolver) RenameFile(ctx context.Context, fileID string, name s
 		return nil, errors.New("access denied")
 	}
 
+	name, err = paths.Clean(name)
+	if err != nil {
+		return nil, err
+	}
+	if name != file.Name {
+		inUse, err := r.pathInUse(ctx, file.ProjectID, name)
+		if err != nil {
+			return nil, err
+		}
+		if inUse {
+			return nil, fmt.Errorf("%s already exists", name)
+		}
+	}
+
 	now := time.Now()
 	_, err = r.DB.Collection("files").UpdateOne(ctx,
 		bson.M{"_id": fileOID},
-		bson.M{"$set": bson.M{"name": name, "updatedAt": now}},
+		bson.M{"$set": bson.M{"name": name, "updatedAt": now, "updatedBy": user.ID}},
 	)
 	if err != nil {
 		return nil, err
@@ -426,6 +477,12 @@ func (r *mutationResolver) UpdateWorkingFile(ctx context.Context, input model.Up
 		return nil, err
 	}
 
+	// Track who last edited the file
+	r.DB.Collection("files").UpdateOne(ctx,
+		bson.M{"_id": fileOID},
+		bson.M{"$set": bson.M{"updatedAt": now, "updatedBy": user.ID}},
+	)
+
 	// Update project lastEditedAt
 	r.DB.Collection("projects").UpdateOne(ctx,
 		bson.M{"_id": file.ProjectID},
@@ -437,14 +494,14 @@ func (r *mutationResolver) UpdateWorkingFile(ctx context.Context, input model.Up
 	return qr.WorkingFile(ctx, input.FileID)
 }
 
-// CreateVersion is the resolver for the createVersion field.
-func (r *mutationResolver) CreateVersion(ctx context.Context, input model.CreateVersionInput) (*model.Version, error) {
+// CreateFolder is the resolver for the createFolder field.
+func (r *mutationResolver) CreateFolder(ctx context.Context, projectID string, path string) (*model.Folder, error) {
 	user, err := middleware.GetUserFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	projectOID, err := toObjectID(input.ProjectID)
+	projectOID, err := toObjectID(projectID)
 	if err != nil {
 		return nil, err
 	}
@@ -454,281 +511,1894 @@ func (r *mutationResolver) CreateVersion(ctx context.Context, input model.Create
 		return nil, errors.New("access denied")
 	}
 
-	// Fetch all files for this project
-	cursor, err := r.DB.Collection("files").Find(ctx, bson.M{"projectId": projectOID})
+	folder, err := folderPath(path)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
-
-	var files []FileDoc
-	if err = cursor.All(ctx, &files); err != nil {
-		return nil, err
+	if folder == "" {
+		return nil, errors.New("folder path is required")
 	}
 
-	// Create version
-	now := time.Now()
-	version := VersionDoc{
-		ProjectID: projectOID,
-		Message:   input.Message,
-		CreatedAt: now,
+	// Creating an existing folder is a no-op
+	var existing FolderDoc
+	err = r.DB.Collection("folders").FindOne(ctx, bson.M{"projectId": projectOID, "path": folder}).Decode(&existing)
+	if err == nil {
+		return folderDocToModel(existing), nil
 	}
 
-	versionResult, err := r.DB.Collection("versions").InsertOne(ctx, version)
+	count, err := r.DB.Collection("files").CountDocuments(ctx, bson.M{"projectId": projectOID, "name": folder})
 	if err != nil {
 		return nil, err
 	}
-	version.ID = versionResult.InsertedID.(bson.ObjectID)
-
-	// Create version files
-	for _, file := range files {
-		var workingFile WorkingFileDoc
-		err = r.DB.Collection("working_files").FindOne(ctx, bson.M{"fileId": file.ID}).Decode(&workingFile)
-		if err != nil {
-			continue
-		}
-
-		versionFile := VersionFileDoc{
-			VersionID: version.ID,
-			FileID:    file.ID,
-			Name:      file.Name,
-			Type:      file.Type,
-			Content:   workingFile.Content,
-		}
-
-		r.DB.Collection("version_files").InsertOne(ctx, versionFile)
+	assetCount, err := r.DB.Collection("assets").CountDocuments(ctx, bson.M{"projectId": projectOID, "name": folder})
+	if err != nil {
+		return nil, err
+	}
+	if count+assetCount > 0 {
+		return nil, fmt.Errorf("a file named %s already exists", folder)
 	}
 
-	return &model.Version{
-		ID:        version.ID.Hex(),
-		ProjectID: version.ProjectID.Hex(),
-		CreatedAt: version.CreatedAt.Format(time.RFC3339),
-		Message:   version.Message,
-	}, nil
-}
-
-// RestoreVersion is the resolver for the restoreVersion field.
-func (r *mutationResolver) RestoreVersion(ctx context.Context, versionID string) (*model.Project, error) {
-	user, err := middleware.GetUserFromContext(ctx)
+	doc := FolderDoc{
+		ProjectID: projectOID,
+		Path:      folder,
+		CreatedAt: time.Now(),
+	}
+	result, err := r.DB.Collection("folders").InsertOne(ctx, doc)
 	if err != nil {
 		return nil, err
 	}
+	doc.ID = result.InsertedID.(bson.ObjectID)
 
-	versionOID, err := toObjectID(versionID)
+	return folderDocToModel(doc), nil
+}
+
+// RenameFolder is the resolver for the renameFolder field.
+func (r *mutationResolver) RenameFolder(ctx context.Context, projectID string, path string, newPath string) (*model.Folder, error) {
+	user, err := middleware.GetUserFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get version
-	var version VersionDoc
-	err = r.DB.Collection("versions").FindOne(ctx, bson.M{"_id": versionOID}).Decode(&version)
+	projectOID, err := toObjectID(projectID)
 	if err != nil {
 		return nil, err
 	}
 
-	hasAccess, err := r.hasProjectAccess(ctx, version.ProjectID, user.ID)
+	hasAccess, err := r.hasProjectAccess(ctx, projectOID, user.ID)
 	if err != nil || !hasAccess {
 		return nil, errors.New("access denied")
 	}
 
-	// Get version files
-	cursor, err := r.DB.Collection("version_files").Find(ctx, bson.M{"versionId": versionOID})
+	from, err := folderPath(path)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
+	to, err := folderPath(newPath)
+	if err != nil {
+		return nil, err
+	}
+	if from == "" || to == "" {
+		return nil, errors.New("folder path is required")
+	}
+	if paths.Within(to, from) {
+		return nil, errors.New("cannot move a folder into itself")
+	}
 
-	var versionFiles []VersionFileDoc
-	if err = cursor.All(ctx, &versionFiles); err != nil {
+	files, assets, folders, err := r.loadProjectEntries(ctx, projectOID)
+	if err != nil {
 		return nil, err
 	}
 
-	now := time.Now()
+	found := false
+	for _, f := range folders {
+		found = found || f.Path == from || paths.Within(f.Path, from)
+	}
+	for _, f := range files {
+		found = found || paths.Within(f.Name, from)
+	}
+	for _, a := range assets {
+		found = found || paths.Within(assetName(a.Name, a.Path), from)
+	}
+	if !found {
+		return nil, errFolderNotFound
+	}
+
+	if from != to {
+		inUse, err := r.pathInUse(ctx, projectOID, to)
+		if err != nil {
+			return nil, err
+		}
+		if inUse {
+			return nil, fmt.Errorf("%s already exists", to)
+		}
+	}
 
-	// Restore each file
-	for _, vf := range versionFiles {
-		update := bson.M{
-			"$set": bson.M{
-				"content":   vf.Content,
-				"updatedAt": now,
-			},
+	now := time.Now()
+	for _, f := range files {
+		if !paths.Within(f.Name, from) {
+			continue
+		}
+		_, err := r.DB.Collection("files").UpdateOne(ctx,
+			bson.M{"_id": f.ID},
+			bson.M{"$set": bson.M{"name": paths.Rebase(f.Name, from, to), "updatedAt": now}},
+		)
+		if err != nil {
+			return nil, err
 		}
+	}
+	for _, a := range assets {
+		name := assetName(a.Name, a.Path)
+		if !paths.Within(name, from) {
+			continue
+		}
+		if _, err := r.moveAssetTo(ctx, a, paths.Rebase(name, from, to)); err != nil {
+			return nil, err
+		}
+	}
 
-		r.DB.Collection("working_files").UpdateOne(ctx,
-			bson.M{"fileId": vf.FileID},
-			update,
+	var renamed *FolderDoc
+	for _, f := range folders {
+		switch {
+		case f.Path == from:
+			f.Path = to
+			renamed = &f
+		case paths.Within(f.Path, from):
+			f.Path = paths.Rebase(f.Path, from, to)
+		default:
+			continue
+		}
+		_, err := r.DB.Collection("folders").UpdateOne(ctx,
+			bson.M{"_id": f.ID},
+			bson.M{"$set": bson.M{"path": f.Path}},
 		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Folders only implied by their files get a document, so the result has an id
+	if renamed == nil {
+		doc := FolderDoc{ProjectID: projectOID, Path: to, CreatedAt: now}
+		result, err := r.DB.Collection("folders").InsertOne(ctx, doc)
+		if err != nil {
+			return nil, err
+		}
+		doc.ID = result.InsertedID.(bson.ObjectID)
+		renamed = &doc
 	}
 
-	// Update project lastEditedAt
 	r.DB.Collection("projects").UpdateOne(ctx,
-		bson.M{"_id": version.ProjectID},
+		bson.M{"_id": projectOID},
 		bson.M{"$set": bson.M{"lastEditedAt": now}},
 	)
 
-	// Use the query resolver through the parent Resolver
-	qr := &queryResolver{r.Resolver}
-	return qr.Project(ctx, version.ProjectID.Hex())
+	return folderDocToModel(*renamed), nil
 }
 
-// CreateAsset is the resolver for the createAsset field.
-func (r *mutationResolver) CreateAsset(ctx context.Context, input model.CreateAssetInput) (*model.Asset, error) {
+// DeleteFolder is the resolver for the deleteFolder field.
+func (r *mutationResolver) DeleteFolder(ctx context.Context, projectID string, path string) (bool, error) {
 	user, err := middleware.GetUserFromContext(ctx)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	projectOID, err := toObjectID(input.ProjectID)
+	projectOID, err := toObjectID(projectID)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
 	hasAccess, err := r.hasProjectAccess(ctx, projectOID, user.ID)
 	if err != nil || !hasAccess {
-		return nil, errors.New("access denied")
+		return false, errors.New("access denied")
 	}
 
-	now := time.Now()
-	asset := AssetDoc{
-		ProjectID: projectOID,
-		Path:      input.Path,
-		MimeType:  input.MimeType,
-		Size:      int(input.Size),
-		CreatedAt: now,
+	folder, err := folderPath(path)
+	if err != nil {
+		return false, err
+	}
+	if folder == "" {
+		return false, errors.New("folder path is required")
 	}
 
-	result, err := r.DB.Collection("assets").InsertOne(ctx, asset)
+	files, assets, _, err := r.loadProjectEntries(ctx, projectOID)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	asset.ID = result.InsertedID.(bson.ObjectID)
 
-	return &model.Asset{
-		ID:        asset.ID.Hex(),
-		ProjectID: asset.ProjectID.Hex(),
-		Path:      asset.Path,
-		MimeType:  asset.MimeType,
-		Size:      int32(asset.Size),
-		CreatedAt: asset.CreatedAt.Format(time.RFC3339),
-	}, nil
-}
+	// The root file cannot go without another document taking its place
+	var project ProjectDoc
+	if err := r.DB.Collection("projects").FindOne(ctx, bson.M{"_id": projectOID}).Decode(&project); err != nil {
+		return false, err
+	}
+	var newRoot bson.ObjectID
+	for _, f := range files {
+		if f.ID == project.RootFileID && paths.Within(f.Name, folder) {
+			working, err := r.workingSnapshot(ctx, projectOID)
+			if err != nil {
+				return false, err
+			}
+			newRoot = rootOutside(working, folder)
+			if newRoot.IsZero() {
+				return false, fmt.Errorf("%s holds the root file %s and no other document can replace it", folder, f.Name)
+			}
+		}
+	}
 
-// Files is the resolver for the files field.
-func (r *projectResolver) Files(ctx context.Context, obj *model.Project) ([]*model.File, error) {
-	projectOID, err := toObjectID(obj.ID)
-	if err != nil {
-		return nil, err
+	// Delete files and working files (keep version files)
+	for _, f := range files {
+		if !paths.Within(f.Name, folder) {
+			continue
+		}
+		r.DB.Collection("files").DeleteOne(ctx, bson.M{"_id": f.ID})
+		r.DB.Collection("working_files").DeleteOne(ctx, bson.M{"fileId": f.ID})
 	}
 
-	cursor, err := r.DB.Collection("files").Find(ctx, bson.M{"projectId": projectOID})
-	if err != nil {
-		return nil, err
+	for _, a := range assets {
+		if !paths.Within(assetName(a.Name, a.Path), folder) {
+			continue
+		}
+		r.removeAssetObject(ctx, a.Path)
+		r.DB.Collection("assets").DeleteOne(ctx, bson.M{"_id": a.ID})
 	}
-	defer cursor.Close(ctx)
 
-	var fileDocs []FileDoc
-	if err = cursor.All(ctx, &fileDocs); err != nil {
-		return nil, err
+	_, err = r.DB.Collection("folders").DeleteMany(ctx, bson.M{
+		"projectId": projectOID,
+		"$or": []bson.M{
+			{"path": folder},
+			{"path": withinFilter(folder)},
+		},
+	})
+	if err != nil {
+		return false, err
 	}
 
-	files := make([]*model.File, len(fileDocs))
-	for i, f := range fileDocs {
-		files[i] = &model.File{
-			ID:        f.ID.Hex(),
-			ProjectID: f.ProjectID.Hex(),
-			Name:      f.Name,
-			Type:      stringToFileType(f.Type),
-			CreatedAt: f.CreatedAt.Format(time.RFC3339),
-			UpdatedAt: f.UpdatedAt.Format(time.RFC3339),
-		}
+	set := bson.M{"lastEditedAt": time.Now()}
+	if !newRoot.IsZero() {
+		set["rootFileId"] = newRoot
 	}
+	r.DB.Collection("projects").UpdateOne(ctx, bson.M{"_id": projectOID}, bson.M{"$set": set})
 
-	return files, nil
+	return true, nil
 }
 
-// Assets is the resolver for the assets field.
-func (r *projectResolver) Assets(ctx context.Context, obj *model.Project) ([]*model.Asset, error) {
-	projectOID, err := toObjectID(obj.ID)
+// MoveFile is the resolver for the moveFile field.
+func (r *mutationResolver) MoveFile(ctx context.Context, fileID string, folder string) (*model.File, error) {
+	user, err := middleware.GetUserFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	cursor, err := r.DB.Collection("assets").Find(ctx, bson.M{"projectId": projectOID})
+	fileOID, err := toObjectID(fileID)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
 
-	var assetDocs []AssetDoc
-	if err = cursor.All(ctx, &assetDocs); err != nil {
+	var file FileDoc
+	err = r.DB.Collection("files").FindOne(ctx, bson.M{"_id": fileOID}).Decode(&file)
+	if err != nil {
 		return nil, err
 	}
 
-	assets := make([]*model.Asset, len(assetDocs))
-	for i, a := range assetDocs {
-		assets[i] = &model.Asset{
-			ID:        a.ID.Hex(),
-			ProjectID: a.ProjectID.Hex(),
-			Path:      a.Path,
-			MimeType:  a.MimeType,
-			Size:      int32(a.Size),
-			CreatedAt: a.CreatedAt.Format(time.RFC3339),
+	hasAccess, err := r.hasProjectAccess(ctx, file.ProjectID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	target, err := folderPath(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	name := paths.Join(target, file.Name[strings.LastIndex(file.Name, "/")+1:])
+	if name != file.Name {
+		inUse, err := r.pathInUse(ctx, file.ProjectID, name)
+		if err != nil {
+			return nil, err
+		}
+		if inUse {
+			return nil, fmt.Errorf("%s already exists", name)
+		}
+
+		_, err = r.DB.Collection("files").UpdateOne(ctx,
+			bson.M{"_id": fileOID},
+			bson.M{"$set": bson.M{"name": name, "updatedAt": time.Now(), "updatedBy": user.ID}},
+		)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return assets, nil
+	qr := &queryResolver{r.Resolver}
+	return qr.File(ctx, fileID)
 }
 
-// Versions is the resolver for the versions field.
-func (r *projectResolver) Versions(ctx context.Context, obj *model.Project) ([]*model.Version, error) {
-	projectOID, err := toObjectID(obj.ID)
+// MoveAsset is the resolver for the moveAsset field.
+func (r *mutationResolver) MoveAsset(ctx context.Context, assetID string, folder string) (*model.Asset, error) {
+	user, err := middleware.GetUserFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	cursor, err := r.DB.Collection("versions").Find(ctx, bson.M{"projectId": projectOID})
+	assetOID, err := toObjectID(assetID)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
 
-	var versionDocs []VersionDoc
-	if err = cursor.All(ctx, &versionDocs); err != nil {
+	var asset AssetDoc
+	err = r.DB.Collection("assets").FindOne(ctx, bson.M{"_id": assetOID}).Decode(&asset)
+	if err != nil {
 		return nil, err
 	}
 
-	versions := make([]*model.Version, len(versionDocs))
-	for i, v := range versionDocs {
-		versions[i] = &model.Version{
-			ID:        v.ID.Hex(),
-			ProjectID: v.ProjectID.Hex(),
-			CreatedAt: v.CreatedAt.Format(time.RFC3339),
-			Message:   v.Message,
+	hasAccess, err := r.hasProjectAccess(ctx, asset.ProjectID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	target, err := folderPath(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	current := assetName(asset.Name, asset.Path)
+	name := paths.Join(target, current[strings.LastIndex(current, "/")+1:])
+	if name != current {
+		inUse, err := r.pathInUse(ctx, asset.ProjectID, name)
+		if err != nil {
+			return nil, err
+		}
+		if inUse {
+			return nil, fmt.Errorf("%s already exists", name)
+		}
+
+		asset, err = r.moveAssetTo(ctx, asset, name)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return versions, nil
+	return assetDocToModel(asset), nil
 }
 
-// Projects is the resolver for the projects field.
-func (r *queryResolver) Projects(ctx context.Context) ([]*model.Project, error) {
+// CreateVersion is the resolver for the createVersion field.
+func (r *mutationResolver) CreateVersion(ctx context.Context, input model.CreateVersionInput) (*model.Version, error) {
 	user, err := middleware.GetUserFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	filter := bson.M{
-		"$or": []bson.M{
-			{"ownerId": user.ID},
-			{"collaboratorIds": user.ID},
-		},
-	}
-
-	cursor, err := r.DB.Collection("projects").Find(ctx, filter)
+	projectOID, err := toObjectID(input.ProjectID)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
+
+	hasAccess, err := r.hasProjectAccess(ctx, projectOID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	version, err := r.createSnapshot(ctx, VersionDoc{
+		ProjectID: projectOID,
+		Kind:      VersionManual,
+		Message:   input.Message,
+		AuthorID:  user.ID,
+		Labels:    input.Labels,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return versionDocToModel(*version), nil
+}
+
+// RestoreVersion is the resolver for the restoreVersion field.
+func (r *mutationResolver) RestoreVersion(ctx context.Context, versionID string) (*model.Project, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	versionOID, err := toObjectID(versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get version
+	var version VersionDoc
+	err = r.DB.Collection("versions").FindOne(ctx, bson.M{"_id": versionOID}).Decode(&version)
+	if err != nil {
+		return nil, err
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, version.ProjectID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	var project ProjectDoc
+	err = r.DB.Collection("projects").FindOne(ctx, bson.M{"_id": version.ProjectID}).Decode(&project)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get version files
+	versionFiles, err := r.loadVersionFiles(ctx, versionOID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshot the current state first so the restore can be undone
+	message := fmt.Sprintf("Before restoring version of %s", version.CreatedAt.Format(time.RFC3339))
+	if version.Message != nil && *version.Message != "" {
+		message = fmt.Sprintf("Before restoring %q", *version.Message)
+	}
+	_, err = r.createSnapshot(ctx, VersionDoc{
+		ProjectID: version.ProjectID,
+		Kind:      VersionManual,
+		Message:   &message,
+		AuthorID:  user.ID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot current state: %w", err)
+	}
+
+	if err := r.restoreFiles(ctx, version.ProjectID, versionFiles, user.ID); err != nil {
+		return nil, err
+	}
+	if err := r.restoreRootFile(ctx, project, version, versionFiles); err != nil {
+		return nil, err
+	}
+
+	// Versions created before asset snapshots leave assets alone
+	if version.Assets {
+		if err := r.restoreAssets(ctx, version.ProjectID, version.ID, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+
+	// Update project lastEditedAt
+	r.DB.Collection("projects").UpdateOne(ctx,
+		bson.M{"_id": version.ProjectID},
+		bson.M{"$set": bson.M{"lastEditedAt": now}},
+	)
+
+	// Use the query resolver through the parent Resolver
+	qr := &queryResolver{r.Resolver}
+	return qr.Project(ctx, version.ProjectID.Hex())
+}
+
+// UpdateVersion is the resolver for the updateVersion field.
+func (r *mutationResolver) UpdateVersion(ctx context.Context, id string, input model.UpdateVersionInput) (*model.Version, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	versionOID, err := toObjectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var version VersionDoc
+	err = r.DB.Collection("versions").FindOne(ctx, bson.M{"_id": versionOID}).Decode(&version)
+	if err != nil {
+		return nil, errors.New("version not found")
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, version.ProjectID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	set := bson.M{}
+	if input.Locked != nil && *input.Locked != version.Locked {
+		isOwner, err := r.isProjectOwner(ctx, version.ProjectID, user.ID)
+		if err != nil || !isOwner {
+			return nil, errors.New("only owner can lock or unlock versions")
+		}
+		set["locked"] = *input.Locked
+		version.Locked = *input.Locked
+	}
+
+	changes := input.Message != nil || input.Labels != nil || input.Pinned != nil
+	if changes && version.Locked {
+		return nil, errors.New("version is locked")
+	}
+	if input.Message != nil {
+		set["message"] = *input.Message
+		version.Message = input.Message
+	}
+	if input.Labels != nil {
+		version.Labels = normalizeLabels(input.Labels)
+		set["labels"] = version.Labels
+	}
+	if input.Pinned != nil {
+		set["pinned"] = *input.Pinned
+		version.Pinned = *input.Pinned
+	}
+
+	if len(set) > 0 {
+		_, err = r.DB.Collection("versions").UpdateOne(ctx, bson.M{"_id": versionOID}, bson.M{"$set": set})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return versionDocToModel(version), nil
+}
+
+// DeleteVersion is the resolver for the deleteVersion field.
+func (r *mutationResolver) DeleteVersion(ctx context.Context, id string) (bool, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	versionOID, err := toObjectID(id)
+	if err != nil {
+		return false, err
+	}
+
+	var version VersionDoc
+	err = r.DB.Collection("versions").FindOne(ctx, bson.M{"_id": versionOID}).Decode(&version)
+	if err != nil {
+		return false, errors.New("version not found")
+	}
+
+	isOwner, err := r.isProjectOwner(ctx, version.ProjectID, user.ID)
+	if err != nil || !isOwner {
+		return false, errors.New("only owner can delete versions")
+	}
+	if version.Locked {
+		return false, errors.New("version is locked")
+	}
+	bases, err := r.forkBases(ctx, []bson.ObjectID{versionOID})
+	if err != nil {
+		return false, err
+	}
+	if bases[versionOID] {
+		return false, errors.New("version is the common ancestor of a fork and cannot be deleted")
+	}
+
+	r.discardVersion(ctx, versionOID)
+
+	return true, nil
+}
+
+// ForkProject is the resolver for the forkProject field.
+func (r *mutationResolver) ForkProject(ctx context.Context, projectID string, versionID *string, name string) (*model.Project, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	projectOID, err := toObjectID(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, projectOID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("project name is required")
+	}
+
+	var source ProjectDoc
+	err = r.DB.Collection("projects").FindOne(ctx, bson.M{"_id": projectOID}).Decode(&source)
+	if err != nil {
+		return nil, err
+	}
+
+	// Forks always start from a version, so merges have a common ancestor
+	var version *VersionDoc
+	if versionID != nil {
+		versionOID, err := toObjectID(*versionID)
+		if err != nil {
+			return nil, err
+		}
+		version = &VersionDoc{}
+		err = r.DB.Collection("versions").FindOne(ctx, bson.M{"_id": versionOID, "projectId": projectOID}).Decode(version)
+		if err != nil {
+			return nil, errors.New("version not found")
+		}
+	} else {
+		message := fmt.Sprintf("Forked as %q", name)
+		version, err = r.createSnapshot(ctx, VersionDoc{
+			ProjectID: projectOID,
+			Kind:      VersionManual,
+			Message:   &message,
+			AuthorID:  user.ID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot project: %w", err)
+		}
+	}
+
+	fork, err := r.forkVersion(ctx, source, *version, name, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.Project{
+		ID:              fork.ID.Hex(),
+		ProjectName:     fork.ProjectName,
+		CreatedAt:       fork.CreatedAt.Format(time.RFC3339),
+		LastEditedAt:    fork.LastEditedAt.Format(time.RFC3339),
+		OwnerID:         fork.OwnerID.Hex(),
+		CollaboratorIds: []string{},
+		RootFileID:      fork.RootFileID.Hex(),
+	}, nil
+}
+
+// MergeProject is the resolver for the mergeProject field.
+func (r *mutationResolver) MergeProject(ctx context.Context, sourceProjectID string, targetProjectID string, resolutions []*model.MergeResolution) (*model.MergeResult, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceOID, err := toObjectID(sourceProjectID)
+	if err != nil {
+		return nil, err
+	}
+	targetOID, err := toObjectID(targetProjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, projectOID := range []bson.ObjectID{sourceOID, targetOID} {
+		hasAccess, err := r.hasProjectAccess(ctx, projectOID, user.ID)
+		if err != nil || !hasAccess {
+			return nil, errors.New("access denied")
+		}
+	}
+
+	var source, target ProjectDoc
+	if err := r.DB.Collection("projects").FindOne(ctx, bson.M{"_id": sourceOID}).Decode(&source); err != nil {
+		return nil, err
+	}
+	if err := r.DB.Collection("projects").FindOne(ctx, bson.M{"_id": targetOID}).Decode(&target); err != nil {
+		return nil, err
+	}
+
+	// Only forks have a common ancestor with their parent
+	if source.ForkOf != target.ID {
+		return nil, errors.New("source project is not a fork of the target project")
+	}
+
+	return r.mergeFork(ctx, source, target, resolutions, user.ID)
+}
+
+// CompileVersion is the resolver for the compileVersion field.
+func (r *mutationResolver) CompileVersion(ctx context.Context, versionID string) (*model.CompileJob, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Compile == nil {
+		return nil, errors.New("compilation is not available")
+	}
+
+	versionOID, err := toObjectID(versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var version VersionDoc
+	err = r.DB.Collection("versions").FindOne(ctx, bson.M{"_id": versionOID}).Decode(&version)
+	if err != nil {
+		return nil, err
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, version.ProjectID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	var project ProjectDoc
+	err = r.DB.Collection("projects").FindOne(ctx, bson.M{"_id": version.ProjectID}).Decode(&project)
+	if err != nil {
+		return nil, err
+	}
+
+	versionFiles, err := r.loadVersionFiles(ctx, versionOID)
+	if err != nil {
+		return nil, err
+	}
+	if len(versionFiles) == 0 {
+		return nil, errors.New("version has no files")
+	}
+
+	// Build the workspace from the snapshot, with the assets it recorded
+	files := make([]worker.SourceFile, len(versionFiles))
+	for i, vf := range versionFiles {
+		files[i] = worker.SourceFile{Name: vf.Name, Content: vf.Content}
+	}
+	assets, recorded, err := r.versionAssetRefs(ctx, version)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := r.Compile.EnqueueFiles(ctx, worker.FilesRequest{
+		UserID:         user.ID.Hex(),
+		DocID:          version.ProjectID.Hex(),
+		ProjectID:      version.ProjectID.Hex(),
+		MainFile:       versionMainFile(version.rootFile(project.RootFileID), versionFiles),
+		VersionID:      versionID,
+		Files:          files,
+		Prefix:         "version/" + versionID,
+		Assets:         assets,
+		AssetsRecorded: recorded,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return compileJobToModel(job), nil
+}
+
+// CompileDiff is the resolver for the compileDiff field.
+func (r *mutationResolver) CompileDiff(ctx context.Context, baseVersionID string, headVersionID string) (*model.CompileJob, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Compile == nil {
+		return nil, errors.New("compilation is not available")
+	}
+
+	baseOID, err := toObjectID(baseVersionID)
+	if err != nil {
+		return nil, err
+	}
+	headOID, err := toObjectID(headVersionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var base, head VersionDoc
+	if err := r.DB.Collection("versions").FindOne(ctx, bson.M{"_id": baseOID}).Decode(&base); err != nil {
+		return nil, err
+	}
+	if err := r.DB.Collection("versions").FindOne(ctx, bson.M{"_id": headOID}).Decode(&head); err != nil {
+		return nil, err
+	}
+	if base.ProjectID != head.ProjectID {
+		return nil, errors.New("versions belong to different projects")
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, head.ProjectID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	var project ProjectDoc
+	err = r.DB.Collection("projects").FindOne(ctx, bson.M{"_id": head.ProjectID}).Decode(&project)
+	if err != nil {
+		return nil, err
+	}
+
+	baseFiles, err := r.loadVersionFiles(ctx, baseOID)
+	if err != nil {
+		return nil, err
+	}
+	headFiles, err := r.loadVersionFiles(ctx, headOID)
+	if err != nil {
+		return nil, err
+	}
+	if len(baseFiles) == 0 || len(headFiles) == 0 {
+		return nil, errors.New("version has no files")
+	}
+
+	files := make([]worker.SourceFile, len(headFiles))
+	for i, vf := range headFiles {
+		files[i] = worker.SourceFile{Name: vf.Name, Content: vf.Content}
+	}
+	previous := make([]worker.SourceFile, len(baseFiles))
+	for i, vf := range baseFiles {
+		previous[i] = worker.SourceFile{Name: vf.Name, Content: vf.Content}
+	}
+	// The marked-up document is the head, so are its figures
+	assets, recorded, err := r.versionAssetRefs(ctx, head)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := r.Compile.EnqueueFiles(ctx, worker.FilesRequest{
+		UserID:         user.ID.Hex(),
+		DocID:          head.ProjectID.Hex(),
+		ProjectID:      head.ProjectID.Hex(),
+		MainFile:       versionMainFile(head.rootFile(project.RootFileID), headFiles),
+		Files:          files,
+		BaseFiles:      previous,
+		Prefix:         "diff/" + baseVersionID + "_" + headVersionID,
+		Assets:         assets,
+		AssetsRecorded: recorded,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return compileJobToModel(job), nil
+}
+
+// ConfigureGitSync is the resolver for the configureGitSync field.
+func (r *mutationResolver) ConfigureGitSync(ctx context.Context, projectID string, input model.GitSyncInput) (*model.GitSync, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	projectOID, err := toObjectID(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remotes may carry credentials
+	isOwner, err := r.isProjectOwner(ctx, projectOID, user.ID)
+	if err != nil || !isOwner {
+		return nil, errors.New("access denied")
+	}
+
+	if r.Git.Dir == "" {
+		return nil, errGitDisabled
+	}
+
+	var project ProjectDoc
+	err = r.DB.Collection("projects").FindOne(ctx, bson.M{"_id": projectOID}).Decode(&project)
+	if err != nil {
+		return nil, err
+	}
+
+	sync := project.Git
+	if sync == nil {
+		sync = &GitSyncDoc{Branch: defaultGitBranch}
+	}
+
+	if input.Branch != nil {
+		branch := strings.TrimSpace(*input.Branch)
+		if branch == "" {
+			branch = defaultGitBranch
+		}
+		if !validGitBranch(branch) {
+			return nil, fmt.Errorf("invalid branch %q", branch)
+		}
+		if branch != sync.Branch && sync.Head != "" {
+			return nil, errors.New("the branch cannot change once the project was exported")
+		}
+		sync.Branch = branch
+	}
+
+	if input.Remote != nil {
+		remote := strings.TrimSpace(*input.Remote)
+		if remote != "" {
+			if err := r.checkRemote(remote); err != nil {
+				return nil, err
+			}
+		}
+		sync.Remote = remote
+	}
+
+	_, err = r.DB.Collection("projects").UpdateOne(ctx,
+		bson.M{"_id": projectOID},
+		bson.M{"$set": bson.M{"git": sync}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return gitSyncToModel(sync), nil
+}
+
+// ExportToGit is the resolver for the exportToGit field.
+func (r *mutationResolver) ExportToGit(ctx context.Context, projectID string, push *bool) (*model.GitSync, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	projectOID, err := toObjectID(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, projectOID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	var project ProjectDoc
+	err = r.DB.Collection("projects").FindOne(ctx, bson.M{"_id": projectOID}).Decode(&project)
+	if err != nil {
+		return nil, err
+	}
+	if project.Git == nil {
+		project.Git = &GitSyncDoc{Branch: defaultGitBranch}
+	}
+
+	repo, err := r.projectRepo(ctx, projectOID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Edits not in any version yet go out as a version of their own
+	latest, err := r.latestVersion(ctx, projectOID)
+	if err != nil {
+		return nil, err
+	}
+	changed := latest == nil
+	if latest != nil {
+		changed, err = r.changedSince(ctx, projectOID, *latest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if changed {
+		message := "Exported to Git"
+		_, err := r.createSnapshot(ctx, VersionDoc{
+			ProjectID: projectOID,
+			Kind:      VersionManual,
+			Message:   &message,
+			AuthorID:  user.ID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot project: %w", err)
+		}
+	}
+
+	sync, err := r.exportVersions(ctx, project, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export to Git: %w", err)
+	}
+	sync.SyncedAt = time.Now()
+	_, err = r.DB.Collection("projects").UpdateOne(ctx,
+		bson.M{"_id": projectOID},
+		bson.M{"$set": bson.M{"git": sync}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if push != nil && *push {
+		if sync.Remote == "" {
+			return nil, errors.New("no Git remote is configured")
+		}
+		if err := repo.Push(ctx, sync.Remote, sync.Branch); err != nil {
+			return nil, gitRemoteError("failed to push to", sync.Remote, err)
+		}
+	}
+
+	return gitSyncToModel(sync), nil
+}
+
+// PullFromGit is the resolver for the pullFromGit field.
+func (r *mutationResolver) PullFromGit(ctx context.Context, projectID string) (*model.Project, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	projectOID, err := toObjectID(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, projectOID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	var project ProjectDoc
+	err = r.DB.Collection("projects").FindOne(ctx, bson.M{"_id": projectOID}).Decode(&project)
+	if err != nil {
+		return nil, err
+	}
+	if project.Git == nil || project.Git.Remote == "" {
+		return nil, errors.New("no Git remote is configured")
+	}
+	sync := *project.Git
+	if sync.Head == "" {
+		return nil, errors.New("export the project to Git before pulling")
+	}
+
+	repo, err := r.projectRepo(ctx, projectOID)
+	if err != nil {
+		return nil, err
+	}
+	tip, err := repo.Fetch(ctx, sync.Remote, sync.Branch)
+	if err != nil {
+		return nil, gitRemoteError("failed to fetch from", sync.Remote, err)
+	}
+
+	if tip != sync.Head {
+		ahead, err := repo.IsAncestor(ctx, sync.Head, tip)
+		if err != nil {
+			return nil, err
+		}
+		if !ahead {
+			return nil, errors.New("the remote branch has diverged from the project")
+		}
+
+		// Only fast-forward: edits since the last export would be lost
+		var head VersionDoc
+		err = r.DB.Collection("versions").FindOne(ctx, bson.M{"projectId": projectOID, "gitCommit": sync.Head}).Decode(&head)
+		if err != nil {
+			return nil, errors.New("the version of the last export no longer exists")
+		}
+		changed, err := r.changedSince(ctx, projectOID, head)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			return nil, errors.New("the project has changes not exported to Git")
+		}
+
+		info, err := repo.Commit(ctx, tip)
+		if err != nil {
+			return nil, err
+		}
+		// head holds the project as it is, assets included, so a failed pull
+		// can be undone
+		if err := r.applyTree(ctx, project, repo, tip, user.ID); err != nil {
+			if resetErr := r.resetToVersion(ctx, project, head, user.ID); resetErr != nil {
+				return nil, fmt.Errorf("%w; undoing the pull failed: %v", err, resetErr)
+			}
+			return nil, err
+		}
+		_, err = r.createSnapshot(ctx, VersionDoc{
+			ProjectID: projectOID,
+			Kind:      VersionManual,
+			Message:   &info.Message,
+			AuthorID:  user.ID,
+			GitCommit: tip,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot project: %w", err)
+		}
+		if err := repo.SetBranch(ctx, sync.Branch, tip, sync.Head); err != nil {
+			return nil, err
+		}
+		sync.Head = tip
+
+		r.DB.Collection("projects").UpdateOne(ctx,
+			bson.M{"_id": projectOID},
+			bson.M{"$set": bson.M{"lastEditedAt": time.Now()}},
+		)
+	}
+
+	sync.SyncedAt = time.Now()
+	r.DB.Collection("projects").UpdateOne(ctx,
+		bson.M{"_id": projectOID},
+		bson.M{"$set": bson.M{"git": sync}},
+	)
+
+	qr := &queryResolver{r.Resolver}
+	return qr.Project(ctx, projectID)
+}
+
+// ImportGitProject is the resolver for the importGitProject field.
+func (r *mutationResolver) ImportGitProject(ctx context.Context, url string, branch *string, name string) (*model.Project, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("project name is required")
+	}
+	url = strings.TrimSpace(url)
+	if err := r.checkRemote(url); err != nil {
+		return nil, err
+	}
+	if r.Git.Dir == "" {
+		return nil, errGitDisabled
+	}
+
+	// The clone becomes the project's repository, so exports continue its history
+	projectOID := bson.NewObjectID()
+	dir := r.repoDir(projectOID)
+	repo, err := gitsync.Clone(ctx, url, dir)
+	if err != nil {
+		return nil, gitRemoteError("failed to clone", url, err)
+	}
+	fail := func(err error) (*model.Project, error) {
+		r.discardProject(ctx, projectOID)
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	branchName := ""
+	if branch != nil {
+		branchName = strings.TrimSpace(*branch)
+	}
+	if branchName == "" {
+		branchName, err = repo.DefaultBranch(ctx)
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if !validGitBranch(branchName) {
+		return fail(fmt.Errorf("invalid branch %q", branchName))
+	}
+	tip, err := repo.Resolve(ctx, "refs/heads/"+branchName)
+	if errors.Is(err, gitsync.ErrNotFound) {
+		return fail(fmt.Errorf("branch %q not found", branchName))
+	}
+	if err != nil {
+		return fail(err)
+	}
+	info, err := repo.Commit(ctx, tip)
+	if err != nil {
+		return fail(err)
+	}
+
+	now := time.Now()
+	project := ProjectDoc{
+		ID:              projectOID,
+		ProjectName:     name,
+		OwnerID:         user.ID,
+		CollaboratorIDs: []bson.ObjectID{},
+		LastEditedAt:    now,
+		CreatedAt:       now,
+		Git: &GitSyncDoc{
+			Remote:   url,
+			Branch:   branchName,
+			Head:     tip,
+			SyncedAt: now,
+		},
+	}
+	if _, err := r.DB.Collection("projects").InsertOne(ctx, project); err != nil {
+		return fail(err)
+	}
+
+	if err := r.applyTree(ctx, project, repo, tip, user.ID); err != nil {
+		return fail(err)
+	}
+	_, err = r.createSnapshot(ctx, VersionDoc{
+		ProjectID: projectOID,
+		Kind:      VersionManual,
+		Message:   &info.Message,
+		AuthorID:  user.ID,
+		GitCommit: tip,
+	})
+	if err != nil {
+		return fail(fmt.Errorf("failed to snapshot project: %w", err))
+	}
+
+	qr := &queryResolver{r.Resolver}
+	return qr.Project(ctx, projectOID.Hex())
+}
+
+// CreateAsset is the resolver for the createAsset field.
+func (r *mutationResolver) CreateAsset(ctx context.Context, input model.CreateAssetInput) (*model.Asset, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	projectOID, err := toObjectID(input.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, projectOID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	// Size and type come from the stored object, not from the client
+	if !strings.HasPrefix(input.Path, paths.AssetObject("project", projectOID.Hex(), "")) {
+		return nil, errors.New("path must be inside the project's assets")
+	}
+	stat, err := r.Minio.StatObject(ctx, r.Bucket, input.Path, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, errors.New("asset object not found")
+	}
+	if stat.Size != int64(input.Size) {
+		return nil, fmt.Errorf("size mismatch: expected %d bytes, got %d", input.Size, stat.Size)
+	}
+
+	name := paths.AssetRelPath(input.Path)
+	detected, err := r.sniffObject(ctx, input.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read asset: %w", err)
+	}
+	mimeType, err := r.AssetPolicy.Check(name, detected, stat.Size)
+	if err != nil {
+		return nil, err
+	}
+	used, err := r.projectAssetBytes(ctx, projectOID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.AssetPolicy.CheckQuota(name, used, stat.Size); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	asset := AssetDoc{
+		ProjectID: projectOID,
+		Name:      name,
+		Path:      input.Path,
+		MimeType:  mimeType,
+		Size:      int(stat.Size),
+		CreatedAt: now,
+		UpdatedAt: now,
+		UpdatedBy: user.ID,
+	}
+
+	result, err := r.DB.Collection("assets").InsertOne(ctx, asset)
+	if err != nil {
+		return nil, err
+	}
+	asset.ID = result.InsertedID.(bson.ObjectID)
+
+	return assetDocToModel(asset), nil
+}
+
+// DeleteAsset is the resolver for the deleteAsset field.
+func (r *mutationResolver) DeleteAsset(ctx context.Context, assetID string) (bool, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	assetOID, err := toObjectID(assetID)
+	if err != nil {
+		return false, err
+	}
+
+	var asset AssetDoc
+	err = r.DB.Collection("assets").FindOne(ctx, bson.M{"_id": assetOID}).Decode(&asset)
+	if err != nil {
+		return false, err
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, asset.ProjectID, user.ID)
+	if err != nil || !hasAccess {
+		return false, errors.New("access denied")
+	}
+
+	r.removeAssetObject(ctx, asset.Path)
+	_, err = r.DB.Collection("assets").DeleteOne(ctx, bson.M{"_id": assetOID})
+	if err != nil {
+		return false, err
+	}
+
+	r.DB.Collection("projects").UpdateOne(ctx,
+		bson.M{"_id": asset.ProjectID},
+		bson.M{"$set": bson.M{"lastEditedAt": time.Now()}},
+	)
+
+	return true, nil
+}
+
+// RenameAsset is the resolver for the renameAsset field.
+func (r *mutationResolver) RenameAsset(ctx context.Context, assetID string, name string) (*model.Asset, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	assetOID, err := toObjectID(assetID)
+	if err != nil {
+		return nil, err
+	}
+
+	var asset AssetDoc
+	err = r.DB.Collection("assets").FindOne(ctx, bson.M{"_id": assetOID}).Decode(&asset)
+	if err != nil {
+		return nil, err
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, asset.ProjectID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	name, err = paths.Clean(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if name != assetName(asset.Name, asset.Path) {
+		inUse, err := r.pathInUse(ctx, asset.ProjectID, name)
+		if err != nil {
+			return nil, err
+		}
+		if inUse {
+			return nil, fmt.Errorf("%s already exists", name)
+		}
+
+		asset, err = r.moveAssetTo(ctx, asset, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return assetDocToModel(asset), nil
+}
+
+// ReplaceAsset is the resolver for the replaceAsset field.
+func (r *mutationResolver) ReplaceAsset(ctx context.Context, assetID string, file graphql.Upload) (*model.Asset, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	assetOID, err := toObjectID(assetID)
+	if err != nil {
+		return nil, err
+	}
+
+	var asset AssetDoc
+	err = r.DB.Collection("assets").FindOne(ctx, bson.M{"_id": assetOID}).Decode(&asset)
+	if err != nil {
+		return nil, err
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, asset.ProjectID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	name := assetName(asset.Name, asset.Path)
+	detected, content, err := assetpolicy.Sniff(file.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	mimeType, err := r.AssetPolicy.Check(name, detected, file.Size)
+	if err != nil {
+		return nil, err
+	}
+	used, err := r.projectAssetBytes(ctx, asset.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.AssetPolicy.CheckQuota(name, used-int64(asset.Size), file.Size); err != nil {
+		return nil, err
+	}
+
+	// Templates created from this project keep the old content
+	if err := assetstore.DetachTemplates(ctx, r.DB, r.Minio, r.Bucket, asset.Path); err != nil {
+		return nil, err
+	}
+
+	_, err = r.Minio.PutObject(ctx, r.Bucket, asset.Path, content, file.Size, minio.PutObjectOptions{ContentType: mimeType})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload asset: %w", err)
+	}
+
+	now := time.Now()
+	_, err = r.DB.Collection("assets").UpdateOne(ctx,
+		bson.M{"_id": assetOID},
+		bson.M{"$set": bson.M{
+			"size":      file.Size,
+			"mimeType":  mimeType,
+			"updatedAt": now,
+			"updatedBy": user.ID,
+		}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	asset.Size = int(file.Size)
+	asset.MimeType = mimeType
+	asset.UpdatedAt = now
+	asset.UpdatedBy = user.ID
+
+	r.DB.Collection("projects").UpdateOne(ctx,
+		bson.M{"_id": asset.ProjectID},
+		bson.M{"$set": bson.M{"lastEditedAt": now}},
+	)
+
+	return assetDocToModel(asset), nil
+}
+
+// RequestAssetUpload is the resolver for the requestAssetUpload field.
+func (r *mutationResolver) RequestAssetUpload(ctx context.Context, input model.RequestAssetUploadInput) (*model.AssetUpload, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	projectOID, err := toObjectID(input.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, projectOID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	name, err := paths.Clean(input.Path)
+	if err != nil {
+		return nil, err
+	}
+	if input.Size <= 0 {
+		return nil, errors.New("size must be positive")
+	}
+
+	// Fail early on limits; the content type is checked once the file is uploaded
+	if err := r.AssetPolicy.CheckSize(name, int64(input.Size)); err != nil {
+		return nil, err
+	}
+	used, err := r.projectAssetBytes(ctx, projectOID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.AssetPolicy.CheckQuota(name, used, int64(input.Size)); err != nil {
+		return nil, err
+	}
+
+	inUse, err := r.pathInUse(ctx, projectOID, name)
+	if err != nil {
+		return nil, err
+	}
+	if inUse {
+		return nil, fmt.Errorf("%s already exists", name)
+	}
+
+	now := time.Now()
+	upload := AssetUploadDoc{
+		ID:        bson.NewObjectID(),
+		ProjectID: projectOID,
+		UserID:    user.ID,
+		Name:      name,
+		Size:      int64(input.Size),
+		MimeType:  input.MimeType,
+		CreatedAt: now,
+		ExpiresAt: now.Add(uploadURLExpiry),
+	}
+	if input.Checksum != nil {
+		upload.Checksum = strings.ToLower(*input.Checksum)
+	}
+	upload.ObjectKey = uploadStagingKey(projectOID, upload.ID)
+
+	presignedURL, err := r.Minio.PresignedPutObject(ctx, r.Bucket, upload.ObjectKey, uploadURLExpiry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign upload: %w", err)
+	}
+
+	if _, err := r.DB.Collection("asset_uploads").InsertOne(ctx, upload); err != nil {
+		return nil, err
+	}
+
+	return &model.AssetUpload{
+		UploadID:  upload.ID.Hex(),
+		URL:       presignedURL.String(),
+		Method:    http.MethodPut,
+		ExpiresAt: upload.ExpiresAt.Format(time.RFC3339),
+	}, nil
+}
+
+// CompleteAssetUpload is the resolver for the completeAssetUpload field.
+func (r *mutationResolver) CompleteAssetUpload(ctx context.Context, uploadID string) (*model.Asset, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadOID, err := toObjectID(uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	var upload AssetUploadDoc
+	err = r.DB.Collection("asset_uploads").FindOne(ctx, bson.M{"_id": uploadOID}).Decode(&upload)
+	if err != nil {
+		return nil, errors.New("upload not found or expired")
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, upload.ProjectID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	stat, err := r.Minio.StatObject(ctx, r.Bucket, upload.ObjectKey, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, errors.New("uploaded file not found")
+	}
+	if stat.Size != upload.Size {
+		return nil, fmt.Errorf("size mismatch: expected %d bytes, got %d", upload.Size, stat.Size)
+	}
+	if upload.Checksum != "" {
+		checksum, err := r.objectChecksum(ctx, upload.ObjectKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify checksum: %w", err)
+		}
+		if checksum != upload.Checksum {
+			return nil, errors.New("checksum mismatch")
+		}
+	}
+
+	detected, err := r.sniffObject(ctx, upload.ObjectKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	mimeType, err := r.AssetPolicy.Check(upload.Name, detected, stat.Size)
+	if err != nil {
+		return nil, err
+	}
+	used, err := r.projectAssetBytes(ctx, upload.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.AssetPolicy.CheckQuota(upload.Name, used, stat.Size); err != nil {
+		return nil, err
+	}
+
+	// The path may have been taken while the file was uploading
+	inUse, err := r.pathInUse(ctx, upload.ProjectID, upload.Name)
+	if err != nil {
+		return nil, err
+	}
+	if inUse {
+		return nil, fmt.Errorf("%s already exists", upload.Name)
+	}
+
+	objectKey := paths.AssetObject("project", upload.ProjectID.Hex(), upload.Name)
+	if err := r.copyMinioObject(ctx, upload.ObjectKey, objectKey); err != nil {
+		return nil, fmt.Errorf("failed to store upload: %w", err)
+	}
+
+	now := time.Now()
+	asset := AssetDoc{
+		ProjectID: upload.ProjectID,
+		Name:      upload.Name,
+		Path:      objectKey,
+		MimeType:  mimeType,
+		Size:      int(upload.Size),
+		CreatedAt: now,
+		UpdatedAt: now,
+		UpdatedBy: user.ID,
+	}
+	result, err := r.DB.Collection("assets").InsertOne(ctx, asset)
+	if err != nil {
+		return nil, err
+	}
+	asset.ID = result.InsertedID.(bson.ObjectID)
+
+	// Best effort: the lifecycle rule removes leftovers
+	_ = r.Minio.RemoveObject(ctx, r.Bucket, upload.ObjectKey, minio.RemoveObjectOptions{})
+	r.DB.Collection("asset_uploads").DeleteOne(ctx, bson.M{"_id": uploadOID})
+
+	r.DB.Collection("projects").UpdateOne(ctx,
+		bson.M{"_id": upload.ProjectID},
+		bson.M{"$set": bson.M{"lastEditedAt": now}},
+	)
+
+	return assetDocToModel(asset), nil
+}
+
+// CompileProject is the resolver for the compileProject field.
+func (r *mutationResolver) CompileProject(ctx context.Context, projectID string) (*model.CompileJob, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Compile == nil {
+		return nil, errors.New("compilation is not available")
+	}
+
+	projectOID, err := toObjectID(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, projectOID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	var project ProjectDoc
+	err = r.DB.Collection("projects").FindOne(ctx, bson.M{"_id": projectOID}).Decode(&project)
+	if err != nil {
+		return nil, err
+	}
+
+	working, err := r.workingSnapshot(ctx, projectOID)
+	if err != nil {
+		return nil, err
+	}
+	if len(working) == 0 {
+		return nil, errors.New("project has no files")
+	}
+	workingAssets, err := r.workingAssetSnapshot(ctx, projectOID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Assets are fetched from the project by the worker
+	files := make([]worker.SourceFile, len(working))
+	mainFile := ""
+	for i, f := range working {
+		files[i] = worker.SourceFile{Name: f.Name, Content: f.Content}
+		if f.FileID == project.RootFileID {
+			mainFile = f.Name
+		}
+	}
+
+	job, err := r.Compile.EnqueueFiles(ctx, worker.FilesRequest{
+		UserID:    user.ID.Hex(),
+		DocID:     projectID,
+		ProjectID: projectID,
+		Source:    worker.SourceProject,
+		// Lets the build be saved as a version of exactly this tree
+		SourceHash: treeHash(working, workingAssets),
+		MainFile:   mainFile,
+		Files:      files,
+		Prefix:     "project/" + projectID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return compileJobToModel(job), nil
+}
+
+// PinCompileJob is the resolver for the pinCompileJob field.
+func (r *mutationResolver) PinCompileJob(ctx context.Context, jobID string, pinned bool) (*model.CompileJob, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Compile == nil {
+		return nil, errors.New("compilation is not available")
+	}
+
+	job, err := r.Compile.GetJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only builds enqueued for a project the server checked can be pinned; the
+	// docId of /api/compile jobs is whatever the client sent
+	if job.ProjectID == "" {
+		return nil, errors.New("only project builds can be pinned")
+	}
+	projectOID, err := toObjectID(job.ProjectID)
+	if err != nil {
+		return nil, errors.New("access denied")
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, projectOID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	job, err = r.Compile.SetPinned(ctx, jobID, pinned)
+	if err != nil {
+		return nil, err
+	}
+
+	return compileJobToModel(job), nil
+}
+
+// Files is the resolver for the files field.
+func (r *projectResolver) Files(ctx context.Context, obj *model.Project) ([]*model.File, error) {
+	projectOID, err := toObjectID(obj.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := r.DB.Collection("files").Find(ctx, bson.M{"projectId": projectOID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var fileDocs []FileDoc
+	if err = cursor.All(ctx, &fileDocs); err != nil {
+		return nil, err
+	}
+
+	files := make([]*model.File, len(fileDocs))
+	for i, f := range fileDocs {
+		files[i] = fileDocToModel(f)
+	}
+	if err := r.setFileSizes(ctx, files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// Assets is the resolver for the assets field.
+func (r *projectResolver) Assets(ctx context.Context, obj *model.Project) ([]*model.Asset, error) {
+	projectOID, err := toObjectID(obj.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := r.DB.Collection("assets").Find(ctx, bson.M{"projectId": projectOID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var assetDocs []AssetDoc
+	if err = cursor.All(ctx, &assetDocs); err != nil {
+		return nil, err
+	}
+
+	assets := make([]*model.Asset, len(assetDocs))
+	for i, a := range assetDocs {
+		assets[i] = assetDocToModel(a)
+	}
+
+	return assets, nil
+}
+
+// Versions is the resolver for the versions field.
+func (r *projectResolver) Versions(ctx context.Context, obj *model.Project, first *int32, after *string) ([]*model.Version, error) {
+	projectOID, err := toObjectID(obj.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Newest first; the id breaks ties between versions of the same second
+	filter := bson.M{"projectId": projectOID}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}})
+	if first != nil {
+		opts.SetLimit(int64(min(max(int(*first), 1), maxPageSize)))
+	}
+	if after != nil && *after != "" {
+		// The cursor carries the sort key, as the version may have been thinned
+		createdAt, afterOID, err := parseVersionCursor(*after)
+		if err != nil {
+			return nil, err
+		}
+		filter["$or"] = bson.A{
+			bson.M{"createdAt": bson.M{"$lt": createdAt}},
+			bson.M{"createdAt": createdAt, "_id": bson.M{"$lt": afterOID}},
+		}
+	}
+
+	cursor, err := r.DB.Collection("versions").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var versionDocs []VersionDoc
+	if err = cursor.All(ctx, &versionDocs); err != nil {
+		return nil, err
+	}
+
+	versions := make([]*model.Version, len(versionDocs))
+	for i, v := range versionDocs {
+		versions[i] = versionDocToModel(v)
+	}
+
+	return versions, nil
+}
+
+// LatestPDF is the resolver for the latestPdf field.
+func (r *projectResolver) LatestPDF(ctx context.Context, obj *model.Project) (*model.CompileJob, error) {
+	if r.Compile == nil {
+		return nil, nil
+	}
+
+	rootFileOID, err := toObjectID(obj.RootFileID)
+	if err != nil {
+		return nil, nil
+	}
+
+	var rootFile FileDoc
+	err = r.DB.Collection("files").FindOne(ctx, bson.M{"_id": rootFileOID}).Decode(&rootFile)
+	if err != nil {
+		return nil, nil
+	}
+
+	job, err := r.Compile.LatestPDF(ctx, obj.ID, rootFile.Name)
+	if errors.Is(err, worker.ErrNoBuild) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return compileJobToModel(job), nil
+}
+
+// Tree is the resolver for the tree field.
+func (r *projectResolver) Tree(ctx context.Context, obj *model.Project) ([]*model.TreeNode, error) {
+	projectOID, err := toObjectID(obj.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	files, assets, folders, err := r.loadProjectEntries(ctx, projectOID)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := buildTree(files, assets, folders)
+	if err := r.setFileSizes(ctx, treeFiles(tree)); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// ForkedFrom is the resolver for the forkedFrom field.
+func (r *projectResolver) ForkedFrom(ctx context.Context, obj *model.Project) (*model.ForkOrigin, error) {
+	projectOID, err := toObjectID(obj.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var project ProjectDoc
+	err = r.DB.Collection("projects").FindOne(ctx, bson.M{"_id": projectOID}).Decode(&project)
+	if err != nil {
+		return nil, err
+	}
+	if project.ForkOf.IsZero() {
+		return nil, nil
+	}
+
+	origin := &model.ForkOrigin{
+		ProjectID: project.ForkOf.Hex(),
+		VersionID: project.ForkVersionID.Hex(),
+		ForkedAt:  project.ForkedAt.Format(time.RFC3339),
+	}
+	var parent ProjectDoc
+	err = r.DB.Collection("projects").FindOne(ctx, bson.M{"_id": project.ForkOf}).Decode(&parent)
+	if err == nil {
+		origin.ProjectName = &parent.ProjectName
+	}
+
+	return origin, nil
+}
+
+// Git is the resolver for the git field.
+func (r *projectResolver) Git(ctx context.Context, obj *model.Project) (*model.GitSync, error) {
+	projectOID, err := toObjectID(obj.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var project ProjectDoc
+	err = r.DB.Collection("projects").FindOne(ctx, bson.M{"_id": projectOID}).Decode(&project)
+	if err != nil {
+		return nil, err
+	}
+
+	return gitSyncToModel(project.Git), nil
+}
+
+// Projects is the resolver for the projects field.
+func (r *queryResolver) Projects(ctx context.Context) ([]*model.Project, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"$or": []bson.M{
+			{"ownerId": user.ID},
+			{"collaboratorIds": user.ID},
+		},
+	}
+
+	cursor, err := r.DB.Collection("projects").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
 
 	var projectDocs []ProjectDoc
 	if err = cursor.All(ctx, &projectDocs); err != nil {
@@ -818,14 +2488,11 @@ func (r *queryResolver) File(ctx context.Context, id string) (*model.File, error
 		return nil, errors.New("access denied")
 	}
 
-	return &model.File{
-		ID:        file.ID.Hex(),
-		ProjectID: file.ProjectID.Hex(),
-		Name:      file.Name,
-		Type:      stringToFileType(file.Type),
-		CreatedAt: file.CreatedAt.Format(time.RFC3339),
-		UpdatedAt: file.UpdatedAt.Format(time.RFC3339),
-	}, nil
+	result := fileDocToModel(file)
+	if err := r.setFileSizes(ctx, []*model.File{result}); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 // WorkingFile is the resolver for the workingFile field.
@@ -867,6 +2534,74 @@ func (r *queryResolver) WorkingFile(ctx context.Context, fileID string) (*model.
 	}, nil
 }
 
+// ProjectEntries is the resolver for the projectEntries field.
+func (r *queryResolver) ProjectEntries(ctx context.Context, projectID string, first *int32, after *string) (*model.ProjectEntryConnection, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	projectOID, err := toObjectID(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, projectOID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	limit := defaultPageSize
+	if first != nil {
+		limit = min(max(int(*first), 1), maxPageSize)
+	}
+
+	// Cursors are the path of the last entry of the previous page
+	var afterPath string
+	if after != nil && *after != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(*after)
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		afterPath = string(decoded)
+	}
+
+	entries, hasNext, err := r.projectEntriesAfter(ctx, projectOID, afterPath, limit)
+	if err != nil {
+		return nil, err
+	}
+	var files []*model.File
+	for _, e := range entries {
+		if f, ok := e.(*model.File); ok {
+			files = append(files, f)
+		}
+	}
+	if err := r.setFileSizes(ctx, files); err != nil {
+		return nil, err
+	}
+
+	fileCount, err := r.DB.Collection("files").CountDocuments(ctx, bson.M{"projectId": projectOID})
+	if err != nil {
+		return nil, err
+	}
+	assetCount, err := r.DB.Collection("assets").CountDocuments(ctx, bson.M{"projectId": projectOID})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &model.ProjectEntryConnection{
+		Entries:     entries,
+		TotalCount:  int32(fileCount + assetCount),
+		HasNextPage: hasNext,
+	}
+	if len(entries) > 0 {
+		cursor := base64.RawURLEncoding.EncodeToString([]byte(entries[len(entries)-1].GetTreePath()))
+		result.EndCursor = &cursor
+	}
+
+	return result, nil
+}
+
 // Version is the resolver for the version field.
 func (r *queryResolver) Version(ctx context.Context, id string) (*model.Version, error) {
 	user, err := middleware.GetUserFromContext(ctx)
@@ -890,14 +2625,157 @@ func (r *queryResolver) Version(ctx context.Context, id string) (*model.Version,
 		return nil, errors.New("access denied")
 	}
 
-	return &model.Version{
-		ID:        version.ID.Hex(),
-		ProjectID: version.ProjectID.Hex(),
-		CreatedAt: version.CreatedAt.Format(time.RFC3339),
-		Message:   version.Message,
+	return versionDocToModel(version), nil
+}
+
+// VersionDiff is the resolver for the versionDiff field.
+func (r *queryResolver) VersionDiff(ctx context.Context, baseVersionID string, headVersionID *string) (*model.VersionDiff, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	baseOID, err := toObjectID(baseVersionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var base VersionDoc
+	err = r.DB.Collection("versions").FindOne(ctx, bson.M{"_id": baseOID}).Decode(&base)
+	if err != nil {
+		return nil, errors.New("version not found")
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, base.ProjectID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	baseFiles, err := r.versionSnapshot(ctx, base.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a head version, compare against the working tree
+	var head *VersionDoc
+	var headFiles []snapshotFile
+	if headVersionID != nil {
+		headOID, err := toObjectID(*headVersionID)
+		if err != nil {
+			return nil, err
+		}
+		head = &VersionDoc{}
+		err = r.DB.Collection("versions").FindOne(ctx, bson.M{"_id": headOID}).Decode(head)
+		if err != nil {
+			return nil, errors.New("version not found")
+		}
+		if head.ProjectID != base.ProjectID {
+			return nil, errors.New("versions belong to different projects")
+		}
+		headFiles, err = r.versionSnapshot(ctx, head.ID)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		headFiles, err = r.workingSnapshot(ctx, base.ProjectID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	files := diffSnapshots(baseFiles, headFiles)
+	if files == nil {
+		files = []*model.FileDiff{}
+	}
+
+	assets, err := r.assetDiff(ctx, base, head)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.VersionDiff{
+		BaseVersionID: baseVersionID,
+		HeadVersionID: headVersionID,
+		Files:         files,
+		Assets:        assets,
 	}, nil
 }
 
+// FileBlame is the resolver for the fileBlame field.
+func (r *queryResolver) FileBlame(ctx context.Context, fileID string, versionID string) (*model.FileBlame, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fileOID, err := toObjectID(fileID)
+	if err != nil {
+		return nil, err
+	}
+	versionOID, err := toObjectID(versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var version VersionDoc
+	err = r.DB.Collection("versions").FindOne(ctx, bson.M{"_id": versionOID}).Decode(&version)
+	if err != nil {
+		return nil, errors.New("version not found")
+	}
+
+	hasAccess, err := r.hasProjectAccess(ctx, version.ProjectID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	return r.fileBlame(ctx, fileOID, version)
+}
+
+// PDFDiff is the resolver for the pdfDiff field.
+func (r *queryResolver) PDFDiff(ctx context.Context, baseJobID string, headJobID string) (*model.PDFDiff, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Compile == nil {
+		return nil, errors.New("compilation is not available")
+	}
+
+	// Both builds must be project builds of the same project, which the user
+	// can access; the docId of /api/compile jobs is whatever the client sent
+	var projectID string
+	for _, jobID := range []string{baseJobID, headJobID} {
+		job, err := r.Compile.GetJob(ctx, jobID)
+		if err != nil {
+			return nil, err
+		}
+		if job.Status != "success" || job.PdfObject == "" || !job.PrunedAt.IsZero() {
+			return nil, fmt.Errorf("compile job %s has no pdf", jobID)
+		}
+		if job.ProjectID == "" || (projectID != "" && job.ProjectID != projectID) {
+			return nil, errors.New("both builds must belong to the same project")
+		}
+		projectID = job.ProjectID
+	}
+
+	projectOID, err := toObjectID(projectID)
+	if err != nil {
+		return nil, errors.New("access denied")
+	}
+	hasAccess, err := r.hasProjectAccess(ctx, projectOID, user.ID)
+	if err != nil || !hasAccess {
+		return nil, errors.New("access denied")
+	}
+
+	diff, err := r.Compile.RequestPdfDiff(ctx, user.ID.Hex(), baseJobID, headJobID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.pdfDiffToModel(ctx, diff), nil
+}
+
 // WorkingFileUpdated is the resolver for the workingFileUpdated field.
 func (r *subscriptionResolver) WorkingFileUpdated(ctx context.Context, projectID string) (<-chan *model.WorkingFile, error) {
 	// TODO: Implement using channels and MongoDB change streams or pub/sub
@@ -937,16 +2815,10 @@ func (r *versionResolver) Files(ctx context.Context, obj *model.Version) ([]*mod
 		return nil, err
 	}
 
-	cursor, err := r.DB.Collection("version_files").Find(ctx, bson.M{"versionId": versionOID})
+	versionFileDocs, err := r.loadVersionFiles(ctx, versionOID)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
-
-	var versionFileDocs []VersionFileDoc
-	if err = cursor.All(ctx, &versionFileDocs); err != nil {
-		return nil, err
-	}
 
 	files := make([]*model.VersionFile, len(versionFileDocs))
 	for i, vf := range versionFileDocs {
@@ -963,6 +2835,63 @@ func (r *versionResolver) Files(ctx context.Context, obj *model.Version) ([]*mod
 	return files, nil
 }
 
+// Assets is the resolver for the assets field.
+func (r *versionResolver) Assets(ctx context.Context, obj *model.Version) ([]*model.VersionAsset, error) {
+	versionOID, err := toObjectID(obj.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	versionAssetDocs, err := r.loadVersionAssets(ctx, versionOID)
+	if err != nil {
+		return nil, err
+	}
+
+	assets := make([]*model.VersionAsset, len(versionAssetDocs))
+	for i, va := range versionAssetDocs {
+		assets[i] = &model.VersionAsset{
+			ID:        va.ID.Hex(),
+			VersionID: va.VersionID.Hex(),
+			AssetID:   va.AssetID.Hex(),
+			Name:      va.Name,
+			Hash:      va.Hash,
+			ObjectKey: va.Object,
+			MimeType:  va.MimeType,
+			Size:      int32(va.Size),
+		}
+	}
+
+	return assets, nil
+}
+
+// PDF is the resolver for the pdf field.
+func (r *versionResolver) PDF(ctx context.Context, obj *model.Version) (*model.CompileJob, error) {
+	if r.Compile == nil {
+		return nil, nil
+	}
+
+	versionOID, err := toObjectID(obj.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var version VersionDoc
+	err = r.DB.Collection("versions").FindOne(ctx, bson.M{"_id": versionOID}).Decode(&version)
+	if err != nil {
+		return nil, err
+	}
+	if version.PdfJobID == "" {
+		return nil, nil
+	}
+
+	job, err := r.Compile.GetJob(ctx, version.PdfJobID)
+	if err != nil {
+		return nil, nil
+	}
+
+	return compileJobToModel(job), nil
+}
+
 // Templates
 // ============================================================================
 // QUERY RESOLVERS
@@ -1172,6 +3101,7 @@ func (r *mutationResolver) CreateTemplate(ctx context.Context, projectID string,
 			for _, asset := range assets {
 				templateAsset := TemplateAssetDoc{
 					TemplateID: template.ID,
+					Name:       assetName(asset.Name, asset.Path),
 					Path:       asset.Path,
 					MimeType:   asset.MimeType,
 					Size:       asset.Size,
@@ -1298,8 +3228,8 @@ func (r *mutationResolver) UseTemplate(ctx context.Context, templateID string, p
 		var templateAssets []TemplateAssetDoc
 		if err := assetCursor.All(ctx, &templateAssets); err == nil {
 			for _, ta := range templateAssets {
-				filename := filepath.Base(ta.Path)
-				newPath := fmt.Sprintf("project/%s/assets/%s", project.ID.Hex(), filename)
+				name := assetName(ta.Name, ta.Path)
+				newPath := paths.AssetObject("project", project.ID.Hex(), name)
 
 				// Copy MinIO object
 				err := r.copyMinioObject(ctx, ta.Path, newPath)
@@ -1311,6 +3241,7 @@ func (r *mutationResolver) UseTemplate(ctx context.Context, templateID string, p
 				// Insert asset record
 				newAsset := AssetDoc{
 					ProjectID: project.ID,
+					Name:      name,
 					Path:      newPath,
 					MimeType:  ta.MimeType,
 					Size:      ta.Size,
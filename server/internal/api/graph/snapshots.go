@@ -0,0 +1,266 @@
+package graph
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gollaboratex/server/internal/api/graph/model"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Kinds of versions
+const (
+	VersionManual  = "manual"
+	VersionAuto    = "auto"
+	VersionCompile = "compile"
+	VersionMerge   = "merge"
+)
+
+func versionDocToModel(v VersionDoc) *model.Version {
+	kind := model.VersionKindManual
+	switch v.Kind {
+	case VersionAuto:
+		kind = model.VersionKindAuto
+	case VersionCompile:
+		kind = model.VersionKindCompile
+	case VersionMerge:
+		kind = model.VersionKindMerge
+	}
+
+	version := &model.Version{
+		ID:        v.ID.Hex(),
+		ProjectID: v.ProjectID.Hex(),
+		CreatedAt: v.CreatedAt.Format(time.RFC3339),
+		Message:   v.Message,
+		Kind:      kind,
+		Labels:    v.Labels,
+		Pinned:    v.Pinned,
+		Locked:    v.Locked,
+	}
+	if version.Labels == nil {
+		version.Labels = []string{}
+	}
+	if !v.AuthorID.IsZero() {
+		authorID := v.AuthorID.Hex()
+		version.AuthorID = &authorID
+	}
+	if !v.MergedFrom.IsZero() {
+		mergedFrom := v.MergedFrom.Hex()
+		version.MergedFromVersionID = &mergedFrom
+	}
+	if v.GitCommit != "" {
+		version.GitCommit = &v.GitCommit
+	}
+	version.Cursor = versionCursor(v)
+	return version
+}
+
+// versionCursor encodes the sort key of a version, so pages can continue
+// after it even once it is gone
+func versionCursor(v VersionDoc) string {
+	key := strconv.FormatInt(v.CreatedAt.UnixMilli(), 10) + ":" + v.ID.Hex()
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// parseVersionCursor returns the creation time and id encoded by versionCursor
+func parseVersionCursor(cursor string) (time.Time, bson.ObjectID, error) {
+	invalid := errors.New("invalid cursor")
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, bson.ObjectID{}, invalid
+	}
+	millis, hex, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return time.Time{}, bson.ObjectID{}, invalid
+	}
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return time.Time{}, bson.ObjectID{}, invalid
+	}
+	id, err := bson.ObjectIDFromHex(hex)
+	if err != nil {
+		return time.Time{}, bson.ObjectID{}, invalid
+	}
+	return time.UnixMilli(ms), id, nil
+}
+
+// normalizeLabels trims labels and drops empty and repeated ones
+func normalizeLabels(labels []string) []string {
+	var result []string
+	for _, l := range labels {
+		l = strings.TrimSpace(l)
+		if l != "" && !slices.Contains(result, l) {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+// createSnapshot records the working tree of a project as a new version:
+// text files with their working content, the assets and the root file.
+// version carries the project, kind, message, author and labels.
+func (r *Resolver) createSnapshot(ctx context.Context, version VersionDoc) (*VersionDoc, error) {
+	projectID := version.ProjectID
+
+	var project ProjectDoc
+	err := r.DB.Collection("projects").FindOne(ctx, bson.M{"_id": projectID}).Decode(&project)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch all files for this project
+	cursor, err := r.DB.Collection("files").Find(ctx, bson.M{"projectId": projectID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var files []FileDoc
+	if err = cursor.All(ctx, &files); err != nil {
+		return nil, err
+	}
+
+	// Create version
+	version.CreatedAt = time.Now()
+	version.Assets = true
+	version.RootFileID = project.RootFileID
+	version.Labels = normalizeLabels(version.Labels)
+
+	versionResult, err := r.DB.Collection("versions").InsertOne(ctx, version)
+	if err != nil {
+		return nil, err
+	}
+	version.ID = versionResult.InsertedID.(bson.ObjectID)
+
+	// Create version files
+	for _, file := range files {
+		var workingFile WorkingFileDoc
+		err = r.DB.Collection("working_files").FindOne(ctx, bson.M{"fileId": file.ID}).Decode(&workingFile)
+		if err != nil {
+			continue
+		}
+
+		// Content is stored once per distinct text
+		hash, err := storeBlob(ctx, r.DB, workingFile.Content)
+		if err != nil {
+			r.discardVersion(ctx, version.ID)
+			return nil, err
+		}
+
+		versionFile := VersionFileDoc{
+			VersionID: version.ID,
+			FileID:    file.ID,
+			Name:      file.Name,
+			Type:      file.Type,
+			Hash:      hash,
+		}
+
+		r.DB.Collection("version_files").InsertOne(ctx, versionFile)
+	}
+
+	// A version without its assets could not be restored faithfully
+	if err := r.snapshotAssets(ctx, projectID, version.ID); err != nil {
+		r.discardVersion(ctx, version.ID)
+		return nil, err
+	}
+
+	return &version, nil
+}
+
+// restoreFiles makes the text files of a project exactly those of a version:
+// files added since are removed, deleted ones recreated with their ids, and
+// names, types and contents reset.
+func (r *Resolver) restoreFiles(ctx context.Context, projectID bson.ObjectID, versionFiles []VersionFileDoc, userID bson.ObjectID) error {
+	keep := make(map[bson.ObjectID]bool, len(versionFiles))
+	for _, vf := range versionFiles {
+		keep[vf.FileID] = true
+	}
+
+	cursor, err := r.DB.Collection("files").Find(ctx, bson.M{"projectId": projectID})
+	if err != nil {
+		return err
+	}
+	var current []FileDoc
+	if err := cursor.All(ctx, &current); err != nil {
+		return err
+	}
+
+	// Files added since the version, including any now using a recorded name
+	for _, f := range current {
+		if keep[f.ID] {
+			continue
+		}
+		r.DB.Collection("working_files").DeleteMany(ctx, bson.M{"fileId": f.ID})
+		if _, err := r.DB.Collection("files").DeleteOne(ctx, bson.M{"_id": f.ID}); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	for _, vf := range versionFiles {
+		_, err := r.DB.Collection("files").UpdateOne(ctx,
+			bson.M{"_id": vf.FileID},
+			bson.M{
+				"$set": bson.M{
+					"projectId": projectID,
+					"name":      vf.Name,
+					"type":      vf.Type,
+					"updatedAt": now,
+					"updatedBy": userID,
+				},
+				"$setOnInsert": bson.M{"createdAt": now},
+			},
+			options.UpdateOne().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+
+		result, err := r.DB.Collection("working_files").UpdateMany(ctx,
+			bson.M{"fileId": vf.FileID},
+			bson.M{"$set": bson.M{"content": vf.Content, "updatedAt": now}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			_, err = r.DB.Collection("working_files").InsertOne(ctx, WorkingFileDoc{
+				FileID:    vf.FileID,
+				ProjectID: projectID,
+				Content:   vf.Content,
+				UpdatedAt: now,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// restoreRootFile points the project at the root file of a version. Versions
+// that did not record one keep the current root when it survived the restore.
+func (r *Resolver) restoreRootFile(ctx context.Context, project ProjectDoc, version VersionDoc, versionFiles []VersionFileDoc) error {
+	rootName := versionMainFile(version.rootFile(project.RootFileID), versionFiles)
+	for _, vf := range versionFiles {
+		if vf.Name != rootName {
+			continue
+		}
+		if vf.FileID == project.RootFileID {
+			return nil
+		}
+		_, err := r.DB.Collection("projects").UpdateOne(ctx,
+			bson.M{"_id": project.ID},
+			bson.M{"$set": bson.M{"rootFileId": vf.FileID}},
+		)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package graph
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestVersionCursor(t *testing.T) {
+	version := VersionDoc{ID: bson.NewObjectID(), CreatedAt: time.Date(2024, 3, 1, 12, 0, 0, 123000000, time.UTC)}
+
+	createdAt, id, err := parseVersionCursor(versionCursor(version))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !createdAt.Equal(version.CreatedAt) || id != version.ID {
+		t.Errorf("parseVersionCursor = %s, %s; want %s, %s", createdAt, id.Hex(), version.CreatedAt, version.ID.Hex())
+	}
+
+	for _, cursor := range []string{version.ID.Hex(), "!!", "MTIz", "YWJjOjEyMw"} {
+		if _, _, err := parseVersionCursor(cursor); err == nil {
+			t.Errorf("parseVersionCursor(%q) accepted", cursor)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gollaboratex/server/internal/api/graph/model"
+	"gollaboratex/server/internal/latex"
+	"gollaboratex/server/internal/middleware"
+	"gollaboratex/server/internal/paths"
+
+	"github.com/minio/minio-go/v7"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// FolderDoc is an explicitly created folder. Folders that only exist because
+// files live in them are derived from the file paths and have no document.
+type FolderDoc struct {
+	ID        bson.ObjectID `bson:"_id,omitempty"`
+	ProjectID bson.ObjectID `bson:"projectId"`
+	Path      string        `bson:"path"`
+	CreatedAt time.Time     `bson:"createdAt"`
+}
+
+func folderDocToModel(f FolderDoc) *model.Folder {
+	return &model.Folder{
+		ID:        f.ID.Hex(),
+		ProjectID: f.ProjectID.Hex(),
+		Path:      f.Path,
+		Name:      f.Path[strings.LastIndex(f.Path, "/")+1:],
+		CreatedAt: f.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func fileDocToModel(f FileDoc) *model.File {
+	return &model.File{
+		ID:             f.ID.Hex(),
+		ProjectID:      f.ProjectID.Hex(),
+		Name:           f.Name,
+		TreePath:       f.Name,
+		Type:           stringToFileType(f.Type),
+		MimeType:       fileMimeType(f.Type),
+		Kind:           model.EntryKindText,
+		CreatedAt:      f.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:      f.UpdatedAt.Format(time.RFC3339),
+		LastModifiedBy: optionalID(f.UpdatedBy),
+	}
+}
+
+func assetDocToModel(a AssetDoc) *model.Asset {
+	updatedAt := a.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = a.CreatedAt
+	}
+	name := assetName(a.Name, a.Path)
+	return &model.Asset{
+		ID:             a.ID.Hex(),
+		ProjectID:      a.ProjectID.Hex(),
+		Name:           name,
+		Path:           a.Path,
+		TreePath:       name,
+		MimeType:       a.MimeType,
+		Size:           int32(a.Size),
+		Kind:           model.EntryKindBinary,
+		CreatedAt:      a.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:      updatedAt.Format(time.RFC3339),
+		LastModifiedBy: optionalID(a.UpdatedBy),
+	}
+}
+
+// optionalID renders an unset ObjectID as null
+func optionalID(id bson.ObjectID) *string {
+	if id.IsZero() {
+		return nil
+	}
+	hex := id.Hex()
+	return &hex
+}
+
+// setFileSizes fills in the size of the working copy of each file, with one
+// query for all of them
+func (r *Resolver) setFileSizes(ctx context.Context, files []*model.File) error {
+	if len(files) == 0 {
+		return nil
+	}
+	ids := make([]bson.ObjectID, 0, len(files))
+	for _, f := range files {
+		if id, err := toObjectID(f.ID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	cursor, err := r.DB.Collection("working_files").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"fileId": bson.M{"$in": ids}}}},
+		{{Key: "$project", Value: bson.M{
+			"fileId": 1,
+			"size":   bson.M{"$strLenBytes": bson.M{"$ifNull": bson.A{"$content", ""}}},
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	var sizes []struct {
+		FileID bson.ObjectID `bson:"fileId"`
+		Size   int32         `bson:"size"`
+	}
+	if err := cursor.All(ctx, &sizes); err != nil {
+		return err
+	}
+
+	byID := make(map[string]int32, len(sizes))
+	for _, s := range sizes {
+		byID[s.FileID.Hex()] = s.Size
+	}
+	for _, f := range files {
+		f.Size = byID[f.ID]
+	}
+	return nil
+}
+
+// treeFiles returns the files anywhere in a tree
+func treeFiles(nodes []*model.TreeNode) []*model.File {
+	var files []*model.File
+	for _, node := range nodes {
+		if node.File != nil {
+			files = append(files, node.File)
+		}
+		files = append(files, treeFiles(node.Children)...)
+	}
+	return files
+}
+
+// projectEntriesAfter returns up to limit files and assets of a project whose
+// paths sort after the given one, in path order, and whether more follow.
+// Both collections are read by range on their indexed names.
+func (r *Resolver) projectEntriesAfter(ctx context.Context, projectID bson.ObjectID, after string, limit int) ([]model.ProjectEntry, bool, error) {
+	filter := bson.M{"projectId": projectID, "name": bson.M{"$gt": after}}
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}}).SetLimit(int64(limit + 1))
+
+	var files []FileDoc
+	cursor, err := r.DB.Collection("files").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, false, err
+	}
+
+	var assets []AssetDoc
+	cursor, err = r.DB.Collection("assets").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := cursor.All(ctx, &assets); err != nil {
+		return nil, false, err
+	}
+
+	// Assets uploaded before names were recorded, until MigrateProjectEntries named them
+	cursor, err = r.DB.Collection("assets").Find(ctx, bson.M{
+		"projectId": projectID,
+		"$or":       bson.A{bson.M{"name": bson.M{"$exists": false}}, bson.M{"name": ""}},
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	var unnamed []AssetDoc
+	if err := cursor.All(ctx, &unnamed); err != nil {
+		return nil, false, err
+	}
+	for _, a := range unnamed {
+		if assetName(a.Name, a.Path) > after {
+			assets = append(assets, a)
+		}
+	}
+
+	entries := make([]model.ProjectEntry, 0, len(files)+len(assets))
+	for _, f := range files {
+		entries = append(entries, fileDocToModel(f))
+	}
+	for _, a := range assets {
+		entries = append(entries, assetDocToModel(a))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].GetTreePath() < entries[j].GetTreePath()
+	})
+	if len(entries) <= limit {
+		return entries, false, nil
+	}
+	return entries[:limit], true, nil
+}
+
+// MigrateProjectEntries indexes files and assets by project and path, which
+// projectEntries pages through, and names assets uploaded before names were
+// recorded.
+func MigrateProjectEntries(ctx context.Context, db *mongo.Database) {
+	index := mongo.IndexModel{Keys: bson.D{{Key: "projectId", Value: 1}, {Key: "name", Value: 1}}}
+	for _, coll := range []string{"files", "assets"} {
+		if _, err := db.Collection(coll).Indexes().CreateOne(ctx, index); err != nil {
+			log.Printf("Failed to index %s by path: %v", coll, err)
+		}
+	}
+
+	cursor, err := db.Collection("assets").Find(ctx, bson.M{
+		"$or": bson.A{bson.M{"name": bson.M{"$exists": false}}, bson.M{"name": ""}},
+	})
+	if err != nil {
+		log.Printf("Asset name migration failed: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var asset AssetDoc
+		if err := cursor.Decode(&asset); err != nil {
+			log.Printf("Asset name migration: %v", err)
+			continue
+		}
+		_, err := db.Collection("assets").UpdateOne(ctx,
+			bson.M{"_id": asset.ID},
+			bson.M{"$set": bson.M{"name": assetName("", asset.Path)}},
+		)
+		if err != nil {
+			log.Printf("Asset name migration of %s: %v", asset.ID.Hex(), err)
+			continue
+		}
+		migrated++
+	}
+	if migrated > 0 {
+		log.Printf("Named %d assets after their storage path", migrated)
+	}
+}
+
+// withinFilter matches names inside a folder, at any depth
+func withinFilter(folder string) bson.M {
+	return bson.M{"$regex": "^" + regexp.QuoteMeta(folder+"/")}
+}
+
+// loadProjectEntries returns all files, assets and explicit folders of a project
+func (r *Resolver) loadProjectEntries(ctx context.Context, projectID bson.ObjectID) ([]FileDoc, []AssetDoc, []FolderDoc, error) {
+	var files []FileDoc
+	var assets []AssetDoc
+	var folders []FolderDoc
+
+	cursor, err := r.DB.Collection("files").Find(ctx, bson.M{"projectId": projectID})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, nil, nil, err
+	}
+
+	cursor, err = r.DB.Collection("assets").Find(ctx, bson.M{"projectId": projectID})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := cursor.All(ctx, &assets); err != nil {
+		return nil, nil, nil, err
+	}
+
+	cursor, err = r.DB.Collection("folders").Find(ctx, bson.M{"projectId": projectID})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := cursor.All(ctx, &folders); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return files, assets, folders, nil
+}
+
+// pathInUse reports whether a file, asset or folder already occupies p
+func (r *Resolver) pathInUse(ctx context.Context, projectID bson.ObjectID, p string) (bool, error) {
+	files, assets, folders, err := r.loadProjectEntries(ctx, projectID)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range files {
+		if f.Name == p || paths.Within(f.Name, p) {
+			return true, nil
+		}
+	}
+	for _, a := range assets {
+		name := assetName(a.Name, a.Path)
+		if name == p || paths.Within(name, p) {
+			return true, nil
+		}
+	}
+	for _, f := range folders {
+		if f.Path == p {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// buildTree nests files, assets and folders by path. Folders come first, then
+// entries sorted by name.
+func buildTree(files []FileDoc, assets []AssetDoc, folders []FolderDoc) []*model.TreeNode {
+	root := &model.TreeNode{Children: []*model.TreeNode{}}
+	dirs := map[string]*model.TreeNode{"": root}
+
+	var folderOf func(p string) *model.TreeNode
+	folderOf = func(p string) *model.TreeNode {
+		if node, ok := dirs[p]; ok {
+			return node
+		}
+		parent := folderOf(paths.Dir(p))
+		node := &model.TreeNode{
+			Name:     p[strings.LastIndex(p, "/")+1:],
+			Path:     p,
+			Kind:     model.TreeNodeKindFolder,
+			Children: []*model.TreeNode{},
+		}
+		parent.Children = append(parent.Children, node)
+		dirs[p] = node
+		return node
+	}
+
+	for _, f := range folders {
+		folderOf(f.Path)
+	}
+	for _, f := range files {
+		parent := folderOf(paths.Dir(f.Name))
+		parent.Children = append(parent.Children, &model.TreeNode{
+			Name:     f.Name[strings.LastIndex(f.Name, "/")+1:],
+			Path:     f.Name,
+			Kind:     model.TreeNodeKindFile,
+			File:     fileDocToModel(f),
+			Children: []*model.TreeNode{},
+		})
+	}
+	for _, a := range assets {
+		name := assetName(a.Name, a.Path)
+		parent := folderOf(paths.Dir(name))
+		parent.Children = append(parent.Children, &model.TreeNode{
+			Name:     name[strings.LastIndex(name, "/")+1:],
+			Path:     name,
+			Kind:     model.TreeNodeKindAsset,
+			Asset:    assetDocToModel(a),
+			Children: []*model.TreeNode{},
+		})
+	}
+
+	for _, node := range dirs {
+		sort.SliceStable(node.Children, func(i, j int) bool {
+			a, b := node.Children[i], node.Children[j]
+			if (a.Kind == model.TreeNodeKindFolder) != (b.Kind == model.TreeNodeKindFolder) {
+				return a.Kind == model.TreeNodeKindFolder
+			}
+			return a.Name < b.Name
+		})
+	}
+
+	return root.Children
+}
+
+// moveAssetTo renames an asset to newName, moving its object so the storage
+// layout keeps mirroring the tree.
+func (r *mutationResolver) moveAssetTo(ctx context.Context, asset AssetDoc, newName string) (AssetDoc, error) {
+	newPath := paths.AssetObject("project", asset.ProjectID.Hex(), newName)
+	if newPath != asset.Path {
+		if err := r.copyMinioObject(ctx, asset.Path, newPath); err != nil {
+			return asset, fmt.Errorf("failed to move asset %s: %w", assetName(asset.Name, asset.Path), err)
+		}
+		r.removeAssetObject(ctx, asset.Path)
+	}
+
+	set := bson.M{"name": newName, "path": newPath, "updatedAt": time.Now()}
+	if user, err := middleware.GetUserFromContext(ctx); err == nil {
+		set["updatedBy"] = user.ID
+		asset.UpdatedBy = user.ID
+	}
+	_, err := r.DB.Collection("assets").UpdateOne(ctx, bson.M{"_id": asset.ID}, bson.M{"$set": set})
+	if err != nil {
+		return asset, err
+	}
+
+	asset.Name = newName
+	asset.Path = newPath
+	asset.UpdatedAt = set["updatedAt"].(time.Time)
+	return asset, nil
+}
+
+// removeAssetObject deletes an asset object unless a template still uses it
+func (r *mutationResolver) removeAssetObject(ctx context.Context, objectKey string) {
+	count, err := r.DB.Collection("template_assets").CountDocuments(ctx, bson.M{"path": objectKey})
+	if err != nil || count > 0 {
+		return
+	}
+	_ = r.Minio.RemoveObject(ctx, r.Bucket, objectKey, minio.RemoveObjectOptions{})
+}
+
+// rootOutside picks the root document among the files outside a folder, the
+// way imports choose one; zero when there is none
+func rootOutside(files []snapshotFile, folder string) bson.ObjectID {
+	ids := map[string]bson.ObjectID{}
+	var candidates []string
+	for _, f := range files {
+		if paths.Within(f.Name, folder) || f.Type != "TEX" || !latex.IsRootDocument(f.Content) {
+			continue
+		}
+		ids[f.Name] = f.FileID
+		candidates = append(candidates, f.Name)
+	}
+	return ids[latex.PickRoot(candidates)]
+}
+
+// folderPath validates a folder argument; "" is the project root
+func folderPath(p string) (string, error) {
+	p = strings.Trim(strings.TrimSpace(p), "/")
+	if p == "" {
+		return "", nil
+	}
+	return paths.Clean(p)
+}
+
+var errFolderNotFound = errors.New("folder not found")
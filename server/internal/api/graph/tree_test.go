@@ -0,0 +1,37 @@
+package graph
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestRootOutside(t *testing.T) {
+	const doc = "\\documentclass{article}\n\\begin{document}\nx\n\\end{document}\n"
+	file := func(name, fileType, content string) snapshotFile {
+		return snapshotFile{FileID: bson.NewObjectID(), Name: name, Type: fileType, Content: content}
+	}
+	thesis := file("thesis/main.tex", "TEX", doc)
+	notes := file("notes/draft.tex", "TEX", doc)
+	report := file("report.tex", "TEX", doc)
+	chapter := file("chapter.tex", "TEX", "\\section{Intro}\n")
+	commented := file("old.tex", "TEX", "% "+doc)
+	bib := file("doc.bib", "BIB", doc)
+
+	tests := []struct {
+		name   string
+		files  []snapshotFile
+		folder string
+		want   bson.ObjectID
+	}{
+		{"nearest to the project root", []snapshotFile{thesis, notes, report}, "thesis", report.FileID},
+		{"documents in the folder are skipped", []snapshotFile{thesis, notes}, "thesis", notes.FileID},
+		{"another folder deleted", []snapshotFile{thesis, notes}, "notes", thesis.FileID},
+		{"no root document left", []snapshotFile{thesis, chapter, commented, bib}, "thesis", bson.ObjectID{}},
+	}
+	for _, tt := range tests {
+		if got := rootOutside(tt.files, tt.folder); got != tt.want {
+			t.Errorf("%s: rootOutside = %s, want %s", tt.name, got.Hex(), tt.want.Hex())
+		}
+	}
+}
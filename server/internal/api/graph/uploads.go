@@ -0,0 +1,129 @@
+package graph
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"time"
+
+	"gollaboratex/server/internal/assetpolicy"
+	"gollaboratex/server/internal/worker"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	// Presigned uploads land under this prefix until they are completed
+	uploadStagingPrefix = "uploads/"
+	// How long a presigned upload URL stays valid
+	uploadURLExpiry = time.Hour
+	// Staged objects that were never completed are removed after this many days
+	uploadStagingDays = 1
+)
+
+// AssetUploadDoc is a pending direct-to-MinIO upload
+type AssetUploadDoc struct {
+	ID        bson.ObjectID `bson:"_id,omitempty"`
+	ProjectID bson.ObjectID `bson:"projectId"`
+	UserID    bson.ObjectID `bson:"userId"`
+	Name      string        `bson:"name"`
+	Size      int64         `bson:"size"`
+	MimeType  string        `bson:"mimeType"`
+	Checksum  string        `bson:"checksum,omitempty"` // Hex SHA-256, optional
+	ObjectKey string        `bson:"objectKey"`          // Staging object
+	CreatedAt time.Time     `bson:"createdAt"`
+	ExpiresAt time.Time     `bson:"expiresAt"`
+}
+
+func uploadStagingKey(projectID, uploadID bson.ObjectID) string {
+	return uploadStagingPrefix + projectID.Hex() + "/" + uploadID.Hex()
+}
+
+// SetupUploadExpiry makes abandoned presigned uploads expire: a TTL index drops
+// pending upload records and a lifecycle rule removes their staged objects.
+func SetupUploadExpiry(ctx context.Context, db *mongo.Database, minioClient *minio.Client, bucket string) {
+	_, err := db.Collection("asset_uploads").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(uploadStagingDays * 24 * 3600),
+	})
+	if err != nil {
+		log.Printf("Failed to create asset_uploads TTL index: %v", err)
+	}
+
+	// Merged into the bucket's rules, which operators may have set too
+	rule := lifecycle.Rule{
+		ID:     "expire-staged-uploads",
+		Status: "Enabled",
+		RuleFilter: lifecycle.Filter{
+			Prefix: uploadStagingPrefix,
+		},
+		Expiration: lifecycle.Expiration{
+			Days: lifecycle.ExpirationDays(uploadStagingDays),
+		},
+	}
+	if err := worker.SetLifecycleRule(ctx, minioClient, bucket, rule); err != nil {
+		log.Printf("Failed to set lifecycle rule on %s: %v", bucket, err)
+	}
+}
+
+// objectChecksum returns the hex SHA-256 of an object
+func (r *Resolver) objectChecksum(ctx context.Context, objectKey string) (string, error) {
+	obj, err := r.Minio.GetObject(ctx, r.Bucket, objectKey, minio.GetObjectOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer obj.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, obj); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sniffObject detects the content type of an object from its leading bytes
+func (r *Resolver) sniffObject(ctx context.Context, objectKey string) (string, error) {
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(0, assetpolicy.SniffLen-1); err != nil {
+		return "", err
+	}
+	obj, err := r.Minio.GetObject(ctx, r.Bucket, objectKey, opts)
+	if err != nil {
+		return "", err
+	}
+	defer obj.Close()
+
+	head, err := io.ReadAll(obj)
+	if err != nil {
+		return "", err
+	}
+	return assetpolicy.Detect(head), nil
+}
+
+// projectAssetBytes sums the sizes of a project's assets
+func (r *Resolver) projectAssetBytes(ctx context.Context, projectID bson.ObjectID) (int64, error) {
+	cursor, err := r.DB.Collection("assets").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"projectId": projectID}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$size"}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Total int64 `bson:"total"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, err
+		}
+	}
+	return result.Total, cursor.Err()
+}
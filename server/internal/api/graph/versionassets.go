@@ -0,0 +1,385 @@
+package graph
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"gollaboratex/server/internal/api/graph/model"
+	"gollaboratex/server/internal/assetstore"
+	"gollaboratex/server/internal/paths"
+	"gollaboratex/server/internal/worker"
+
+	"github.com/minio/minio-go/v7"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// assetHash returns the content hash and the ETag of an asset object. The
+// hash is cached on the asset and only recomputed once the object changed.
+func (r *Resolver) assetHash(ctx context.Context, asset AssetDoc) (hash, etag string, err error) {
+	info, err := r.Minio.StatObject(ctx, r.Bucket, asset.Path, minio.StatObjectOptions{})
+	if err != nil {
+		return "", "", err
+	}
+	if asset.Hash != "" && asset.HashETag == info.ETag {
+		return asset.Hash, info.ETag, nil
+	}
+
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetMatchETag(info.ETag); err != nil {
+		return "", "", err
+	}
+	obj, err := r.Minio.GetObject(ctx, r.Bucket, asset.Path, opts)
+	if err != nil {
+		return "", "", err
+	}
+	defer obj.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, obj); err != nil {
+		return "", "", err
+	}
+	hash = hex.EncodeToString(h.Sum(nil))
+
+	r.DB.Collection("assets").UpdateOne(ctx,
+		bson.M{"_id": asset.ID},
+		bson.M{"$set": bson.M{"hash": hash, "hashETag": info.ETag}},
+	)
+	return hash, info.ETag, nil
+}
+
+// snapshotAssets records the current assets of a project in a version. Asset
+// content is copied under the version prefix the first time it is seen;
+// later versions holding the same content share that copy.
+func (r *Resolver) snapshotAssets(ctx context.Context, projectID, versionID bson.ObjectID) error {
+	cursor, err := r.DB.Collection("assets").Find(ctx, bson.M{"projectId": projectID})
+	if err != nil {
+		return err
+	}
+	var assets []AssetDoc
+	if err := cursor.All(ctx, &assets); err != nil {
+		return err
+	}
+
+	for _, asset := range assets {
+		name := assetName(asset.Name, asset.Path)
+		hash, etag, err := r.assetHash(ctx, asset)
+		if err != nil {
+			return fmt.Errorf("failed to read asset %s: %w", name, err)
+		}
+
+		object := paths.VersionObject(projectID.Hex(), hash)
+		if _, err := r.Minio.StatObject(ctx, r.Bucket, object, minio.StatObjectOptions{}); err != nil {
+			// The ETag makes the copy fail if the asset changed since it was hashed
+			src := minio.CopySrcOptions{Bucket: r.Bucket, Object: asset.Path, MatchETag: etag}
+			dst := minio.CopyDestOptions{Bucket: r.Bucket, Object: object}
+			if _, err := r.Minio.CopyObject(ctx, dst, src); err != nil {
+				return fmt.Errorf("failed to copy asset %s: %w", name, err)
+			}
+		}
+
+		_, err = r.DB.Collection("version_assets").InsertOne(ctx, VersionAssetDoc{
+			VersionID: versionID,
+			AssetID:   asset.ID,
+			Name:      name,
+			Hash:      hash,
+			Object:    object,
+			MimeType:  asset.MimeType,
+			Size:      asset.Size,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadVersionAssets returns the assets recorded in a version
+func (r *Resolver) loadVersionAssets(ctx context.Context, versionID bson.ObjectID) ([]VersionAssetDoc, error) {
+	cursor, err := r.DB.Collection("version_assets").Find(ctx, bson.M{"versionId": versionID})
+	if err != nil {
+		return nil, err
+	}
+	var versionAssets []VersionAssetDoc
+	if err := cursor.All(ctx, &versionAssets); err != nil {
+		return nil, err
+	}
+	return versionAssets, nil
+}
+
+// versionAssetRefs returns the assets recorded with a version for a build of
+// it. Versions that did not record their assets report false, their builds
+// fall back to the project's current assets.
+func (r *Resolver) versionAssetRefs(ctx context.Context, version VersionDoc) ([]worker.AssetRef, bool, error) {
+	if !version.Assets {
+		return nil, false, nil
+	}
+	versionAssets, err := r.loadVersionAssets(ctx, version.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	refs := make([]worker.AssetRef, len(versionAssets))
+	for i, va := range versionAssets {
+		refs[i] = worker.AssetRef{Name: va.Name, Object: va.Object}
+	}
+	return refs, true, nil
+}
+
+// discardVersion removes a version with everything recorded for it. Blobs
+// and asset copies are removed once no other version refers to them.
+func (r *Resolver) discardVersion(ctx context.Context, versionID bson.ObjectID) {
+	var hashes []string
+	cursor, err := r.DB.Collection("version_files").Find(ctx, bson.M{"versionId": versionID})
+	if err == nil {
+		var versionFiles []VersionFileDoc
+		if cursor.All(ctx, &versionFiles) == nil {
+			for _, vf := range versionFiles {
+				if vf.Hash != "" {
+					hashes = append(hashes, vf.Hash)
+				}
+			}
+		}
+	}
+	versionAssets, _ := r.loadVersionAssets(ctx, versionID)
+	var version VersionDoc
+	r.DB.Collection("versions").FindOne(ctx, bson.M{"_id": versionID}).Decode(&version)
+
+	r.DB.Collection("version_files").DeleteMany(ctx, bson.M{"versionId": versionID})
+	r.DB.Collection("version_assets").DeleteMany(ctx, bson.M{"versionId": versionID})
+	r.DB.Collection("versions").DeleteOne(ctx, bson.M{"_id": versionID})
+
+	// The version's PDF no longer needs protection from the janitor
+	if version.PdfJobID != "" && r.Compile != nil {
+		count, err := r.DB.Collection("versions").CountDocuments(ctx, bson.M{"pdfJobId": version.PdfJobID})
+		if err == nil && count == 0 {
+			r.Compile.SetPinned(ctx, version.PdfJobID, false)
+		}
+	}
+
+	removeUnusedBlobs(ctx, r.DB, hashes)
+	for _, va := range versionAssets {
+		count, err := r.DB.Collection("version_assets").CountDocuments(ctx, bson.M{"object": va.Object})
+		if err != nil || count > 0 {
+			continue
+		}
+		_ = r.Minio.RemoveObject(ctx, r.Bucket, va.Object, minio.RemoveObjectOptions{})
+	}
+}
+
+// restoreAssets makes the assets of a project exactly those of a version:
+// missing assets are recreated, changed, moved or renamed ones overwritten
+// and assets added since removed.
+func (r *mutationResolver) restoreAssets(ctx context.Context, projectID, versionID, userID bson.ObjectID) error {
+	recorded, err := r.loadVersionAssets(ctx, versionID)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := r.DB.Collection("assets").Find(ctx, bson.M{"projectId": projectID})
+	if err != nil {
+		return err
+	}
+	var current []AssetDoc
+	if err := cursor.All(ctx, &current); err != nil {
+		return err
+	}
+	byName := make(map[string]AssetDoc, len(current))
+	byID := make(map[bson.ObjectID]AssetDoc, len(current))
+	for _, a := range current {
+		byName[assetName(a.Name, a.Path)] = a
+		byID[a.ID] = a
+	}
+
+	kept := make(map[bson.ObjectID]bool, len(recorded))
+	for _, va := range recorded {
+		key := paths.AssetObject("project", projectID.Hex(), va.Name)
+
+		// An asset now at the recorded path takes the content, else the
+		// recorded asset wherever it moved to
+		asset, exists := byName[va.Name]
+		if !exists {
+			asset, exists = byID[va.AssetID]
+		}
+		if exists {
+			kept[asset.ID] = true
+			if asset.Path == key {
+				if hash, _, err := r.assetHash(ctx, asset); err == nil && hash == va.Hash {
+					continue
+				}
+			}
+			if err := assetstore.DetachTemplates(ctx, r.DB, r.Minio, r.Bucket, key); err != nil {
+				return err
+			}
+		}
+
+		src := minio.CopySrcOptions{Bucket: r.Bucket, Object: va.Object}
+		dst := minio.CopyDestOptions{Bucket: r.Bucket, Object: key}
+		info, err := r.Minio.CopyObject(ctx, dst, src)
+		if err != nil {
+			return fmt.Errorf("failed to restore asset %s: %w", va.Name, err)
+		}
+
+		now := time.Now()
+		if !exists {
+			kept[va.AssetID] = true
+			_, err = r.DB.Collection("assets").InsertOne(ctx, AssetDoc{
+				ID:        va.AssetID,
+				ProjectID: projectID,
+				Name:      va.Name,
+				Path:      key,
+				MimeType:  va.MimeType,
+				Size:      va.Size,
+				CreatedAt: now,
+				UpdatedAt: now,
+				UpdatedBy: userID,
+				Hash:      va.Hash,
+				HashETag:  info.ETag,
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if asset.Path != key {
+			r.removeAssetObject(ctx, asset.Path)
+		}
+		_, err = r.DB.Collection("assets").UpdateOne(ctx,
+			bson.M{"_id": asset.ID},
+			bson.M{"$set": bson.M{
+				"name":      va.Name,
+				"path":      key,
+				"mimeType":  va.MimeType,
+				"size":      va.Size,
+				"updatedAt": now,
+				"updatedBy": userID,
+				"hash":      va.Hash,
+				"hashETag":  info.ETag,
+			}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, a := range current {
+		if kept[a.ID] {
+			continue
+		}
+		r.removeAssetObject(ctx, a.Path)
+		if _, err := r.DB.Collection("assets").DeleteOne(ctx, bson.M{"_id": a.ID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotAsset is an asset of a version or of the working tree
+type snapshotAsset struct {
+	AssetID bson.ObjectID
+	Name    string
+	Hash    string
+	Size    int
+}
+
+func (r *Resolver) versionAssetSnapshot(ctx context.Context, versionID bson.ObjectID) ([]snapshotAsset, error) {
+	versionAssets, err := r.loadVersionAssets(ctx, versionID)
+	if err != nil {
+		return nil, err
+	}
+	assets := make([]snapshotAsset, len(versionAssets))
+	for i, va := range versionAssets {
+		assets[i] = snapshotAsset{AssetID: va.AssetID, Name: va.Name, Hash: va.Hash, Size: va.Size}
+	}
+	return assets, nil
+}
+
+func (r *Resolver) workingAssetSnapshot(ctx context.Context, projectID bson.ObjectID) ([]snapshotAsset, error) {
+	cursor, err := r.DB.Collection("assets").Find(ctx, bson.M{"projectId": projectID})
+	if err != nil {
+		return nil, err
+	}
+	var assetDocs []AssetDoc
+	if err := cursor.All(ctx, &assetDocs); err != nil {
+		return nil, err
+	}
+
+	assets := make([]snapshotAsset, len(assetDocs))
+	for i, a := range assetDocs {
+		name := assetName(a.Name, a.Path)
+		hash, _, err := r.assetHash(ctx, a)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read asset %s: %w", name, err)
+		}
+		assets[i] = snapshotAsset{AssetID: a.ID, Name: name, Hash: hash, Size: a.Size}
+	}
+	return assets, nil
+}
+
+// assetDiff compares the assets of a version with a later version, or with
+// the working tree when head is nil
+func (r *Resolver) assetDiff(ctx context.Context, base VersionDoc, head *VersionDoc) ([]*model.AssetDiff, error) {
+	if !base.Assets || (head != nil && !head.Assets) {
+		return []*model.AssetDiff{}, nil
+	}
+
+	baseAssets, err := r.versionAssetSnapshot(ctx, base.ID)
+	if err != nil {
+		return nil, err
+	}
+	var headAssets []snapshotAsset
+	if head != nil {
+		headAssets, err = r.versionAssetSnapshot(ctx, head.ID)
+	} else {
+		headAssets, err = r.workingAssetSnapshot(ctx, base.ProjectID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return diffAssetSnapshots(baseAssets, headAssets), nil
+}
+
+// diffAssetSnapshots pairs assets like diffSnapshots pairs files and reports
+// those whose path or content differ
+func diffAssetSnapshots(base, head []snapshotAsset) []*model.AssetDiff {
+	pairs := pairSnapshots(base, head,
+		func(b, h *snapshotAsset) bool { return b.AssetID == h.AssetID },
+		func(b, h *snapshotAsset) bool { return b.Name == h.Name },
+		func(b, h *snapshotAsset) bool { return b.Hash == h.Hash },
+	)
+
+	result := []*model.AssetDiff{}
+	for _, p := range pairs {
+		var ad *model.AssetDiff
+		switch {
+		case p.base == nil:
+			ad = &model.AssetDiff{Path: p.head.Name, Status: model.FileChangeStatusAdded}
+		case p.head == nil:
+			ad = &model.AssetDiff{Path: p.base.Name, Status: model.FileChangeStatusRemoved}
+		case p.base.Name != p.head.Name:
+			oldPath := p.base.Name
+			ad = &model.AssetDiff{Path: p.head.Name, OldPath: &oldPath, Status: model.FileChangeStatusRenamed}
+		case p.base.Hash != p.head.Hash:
+			ad = &model.AssetDiff{Path: p.head.Name, Status: model.FileChangeStatusModified}
+		default:
+			continue
+		}
+		if p.base != nil {
+			size := int32(p.base.Size)
+			ad.OldSize = &size
+		}
+		if p.head != nil {
+			size := int32(p.head.Size)
+			ad.NewSize = &size
+		}
+		result = append(result, ad)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
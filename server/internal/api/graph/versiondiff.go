@@ -0,0 +1,193 @@
+package graph
+
+import (
+	"context"
+	"sort"
+
+	"gollaboratex/server/internal/api/graph/model"
+	"gollaboratex/server/internal/diff"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Unchanged lines shown around each change
+const diffContext = 3
+
+// snapshotFile is a text file of a version or of the working tree
+type snapshotFile struct {
+	FileID  bson.ObjectID
+	Name    string
+	Type    string
+	Content string
+}
+
+// versionSnapshot returns the files stored with a version
+func (r *Resolver) versionSnapshot(ctx context.Context, versionID bson.ObjectID) ([]snapshotFile, error) {
+	versionFiles, err := r.loadVersionFiles(ctx, versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]snapshotFile, len(versionFiles))
+	for i, vf := range versionFiles {
+		files[i] = snapshotFile{FileID: vf.FileID, Name: vf.Name, Type: vf.Type, Content: vf.Content}
+	}
+	return files, nil
+}
+
+// workingSnapshot returns the current files of a project with their working content
+func (r *Resolver) workingSnapshot(ctx context.Context, projectID bson.ObjectID) ([]snapshotFile, error) {
+	cursor, err := r.DB.Collection("files").Find(ctx, bson.M{"projectId": projectID})
+	if err != nil {
+		return nil, err
+	}
+	var fileDocs []FileDoc
+	if err := cursor.All(ctx, &fileDocs); err != nil {
+		return nil, err
+	}
+
+	cursor, err = r.DB.Collection("working_files").Find(ctx, bson.M{"projectId": projectID})
+	if err != nil {
+		return nil, err
+	}
+	var workingFiles []WorkingFileDoc
+	if err := cursor.All(ctx, &workingFiles); err != nil {
+		return nil, err
+	}
+	content := make(map[bson.ObjectID]string, len(workingFiles))
+	for _, wf := range workingFiles {
+		if _, ok := content[wf.FileID]; !ok {
+			content[wf.FileID] = wf.Content
+		}
+	}
+
+	files := make([]snapshotFile, len(fileDocs))
+	for i, f := range fileDocs {
+		files[i] = snapshotFile{FileID: f.ID, Name: f.Name, Type: f.Type, Content: content[f.ID]}
+	}
+	return files, nil
+}
+
+// diffSnapshots compares two snapshots. Files are paired by id, then by name;
+// a removed and an added file with identical content count as a rename.
+// Unchanged files are left out.
+func diffSnapshots(base, head []snapshotFile) []*model.FileDiff {
+	pairs := pairSnapshots(base, head,
+		func(b, h *snapshotFile) bool { return !b.FileID.IsZero() && b.FileID == h.FileID },
+		func(b, h *snapshotFile) bool { return b.Name == h.Name },
+		func(b, h *snapshotFile) bool { return b.Content == h.Content },
+	)
+
+	var result []*model.FileDiff
+	for _, p := range pairs {
+		var fd *model.FileDiff
+		switch {
+		case p.base == nil:
+			fd = fileDiff(p.head.Name, model.FileChangeStatusAdded, "", p.head.Content)
+		case p.head == nil:
+			fd = fileDiff(p.base.Name, model.FileChangeStatusRemoved, p.base.Content, "")
+		case p.base.Name != p.head.Name:
+			fd = fileDiff(p.head.Name, model.FileChangeStatusRenamed, p.base.Content, p.head.Content)
+			oldPath := p.base.Name
+			fd.OldPath = &oldPath
+		case p.base.Content != p.head.Content:
+			fd = fileDiff(p.head.Name, model.FileChangeStatusModified, p.base.Content, p.head.Content)
+		default:
+			continue
+		}
+		result = append(result, fd)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// snapshotPair holds an entry of the base and of the head snapshot; base is
+// nil for added entries and head for removed ones
+type snapshotPair[T any] struct{ base, head *T }
+
+// pairSnapshots matches the entries of two snapshots, applying each criterion
+// in turn to the entries still unmatched
+func pairSnapshots[T any](base, head []T, criteria ...func(b, h *T) bool) []snapshotPair[T] {
+	var pairs []snapshotPair[T]
+
+	baseLeft := map[int]bool{}
+	for i := range base {
+		baseLeft[i] = true
+	}
+	headLeft := map[int]bool{}
+	for i := range head {
+		headLeft[i] = true
+	}
+
+	for _, same := range criteria {
+		for hi := range head {
+			if !headLeft[hi] {
+				continue
+			}
+			for bi := range base {
+				if baseLeft[bi] && same(&base[bi], &head[hi]) {
+					pairs = append(pairs, snapshotPair[T]{&base[bi], &head[hi]})
+					delete(baseLeft, bi)
+					delete(headLeft, hi)
+					break
+				}
+			}
+		}
+	}
+
+	for bi := range base {
+		if baseLeft[bi] {
+			pairs = append(pairs, snapshotPair[T]{base: &base[bi]})
+		}
+	}
+	for hi := range head {
+		if headLeft[hi] {
+			pairs = append(pairs, snapshotPair[T]{head: &head[hi]})
+		}
+	}
+	return pairs
+}
+
+func fileDiff(path string, status model.FileChangeStatus, oldContent, newContent string) *model.FileDiff {
+	lines := diff.Lines(oldContent, newContent)
+	additions, deletions := diff.Stats(lines)
+
+	fd := &model.FileDiff{
+		Path:      path,
+		Status:    status,
+		Additions: int32(additions),
+		Deletions: int32(deletions),
+		Hunks:     []*model.DiffHunk{},
+	}
+	for _, h := range diff.Hunks(lines, diffContext) {
+		hunk := &model.DiffHunk{
+			Header:   h.Header(),
+			OldStart: int32(h.OldStart),
+			OldLines: int32(h.OldLines),
+			NewStart: int32(h.NewStart),
+			NewLines: int32(h.NewLines),
+			Lines:    make([]*model.DiffLine, len(h.Lines)),
+		}
+		for i, l := range h.Lines {
+			line := &model.DiffLine{Kind: model.DiffLineKindContext, Content: l.Text}
+			switch l.Kind {
+			case diff.Insert:
+				line.Kind = model.DiffLineKindAdded
+			case diff.Delete:
+				line.Kind = model.DiffLineKindRemoved
+			}
+			if l.Old > 0 {
+				old := int32(l.Old)
+				line.OldLine = &old
+			}
+			if l.New > 0 {
+				n := int32(l.New)
+				line.NewLine = &n
+			}
+			hunk.Lines[i] = line
+		}
+		fd.Hunks = append(fd.Hunks, hunk)
+	}
+	return fd
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"time"
+
+	"gollaboratex/server/internal/assetpolicy"
+	"gollaboratex/server/internal/middleware"
+	"gollaboratex/server/internal/paths"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type AssetHandler struct {
+	DB     *mongo.Database
+	Minio  *minio.Client
+	Bucket string
+}
+
+type assetRecord struct {
+	ID        bson.ObjectID `bson:"_id"`
+	ProjectID bson.ObjectID `bson:"projectId"`
+	Name      string        `bson:"name"`
+	Path      string        `bson:"path"`
+	MimeType  string        `bson:"mimeType"`
+}
+
+// GetAsset streams an asset with its content type. Range and conditional
+// requests are supported, so large PDFs and images can be fetched in parts.
+// Only raster images and PDFs are shown inline; anything else, SVG included,
+// is a download, so uploads cannot run scripts on the API origin.
+// GET /api/assets/:id
+func (h *AssetHandler) GetAsset(c *gin.Context) {
+	asset, ok := h.loadAsset(c)
+	if !ok {
+		return
+	}
+
+	obj, err := h.Minio.GetObject(c.Request.Context(), h.Bucket, asset.Path, minio.GetObjectOptions{})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+	defer obj.Close()
+
+	stat, err := obj.Stat()
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+
+	name := asset.Name
+	if name == "" {
+		name = paths.AssetRelPath(asset.Path)
+	}
+
+	contentType := asset.MimeType
+	if contentType == "" {
+		contentType = stat.ContentType
+	}
+	if contentType == "" || contentType == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(path.Ext(name)); byExt != "" {
+			contentType = byExt
+		}
+	}
+
+	disposition := "attachment"
+	if assetpolicy.Inline(contentType) {
+		disposition = "inline"
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": path.Base(name)}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "sandbox")
+	c.Header("Cache-Control", "private, no-cache")
+	if stat.ETag != "" {
+		c.Header("ETag", fmt.Sprintf(`"%s"`, stat.ETag))
+	}
+
+	// ServeContent handles Range, If-None-Match and If-Modified-Since
+	http.ServeContent(c.Writer, c.Request, "", stat.LastModified, obj)
+}
+
+// DeleteAsset removes an asset record and its object
+// DELETE /api/assets/:id
+func (h *AssetHandler) DeleteAsset(c *gin.Context) {
+	asset, ok := h.loadAsset(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	if err := h.removeObject(ctx, asset.Path); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete asset object"})
+		return
+	}
+
+	if _, err := h.DB.Collection("assets").DeleteOne(ctx, bson.M{"_id": asset.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete asset"})
+		return
+	}
+
+	h.DB.Collection("projects").UpdateOne(ctx,
+		bson.M{"_id": asset.ProjectID},
+		bson.M{"$set": bson.M{"lastEditedAt": time.Now()}},
+	)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Asset deleted"})
+}
+
+// loadAsset looks up the asset of the request and checks project access.
+// It writes the error response itself and reports whether to continue.
+func (h *AssetHandler) loadAsset(c *gin.Context) (*assetRecord, bool) {
+	_, err := middleware.GetUserFromContext(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, false
+	}
+
+	assetID, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset ID"})
+		return nil, false
+	}
+
+	var asset assetRecord
+	err = h.DB.Collection("assets").FindOne(c.Request.Context(), bson.M{"_id": assetID}).Decode(&asset)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return nil, false
+	}
+
+	hasAccess, err := h.checkProjectAccess(c.Request.Context(), asset.ProjectID)
+	if err != nil || !hasAccess {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return nil, false
+	}
+
+	return &asset, true
+}
+
+// removeObject deletes an asset object unless a template still uses it
+func (h *AssetHandler) removeObject(ctx context.Context, objectKey string) error {
+	count, err := h.DB.Collection("template_assets").CountDocuments(ctx, bson.M{"path": objectKey})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return h.Minio.RemoveObject(ctx, h.Bucket, objectKey, minio.RemoveObjectOptions{})
+}
+
+func (h *AssetHandler) checkProjectAccess(ctx context.Context, projectID bson.ObjectID) (bool, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var project struct {
+		OwnerID         bson.ObjectID   `bson:"ownerId"`
+		CollaboratorIDs []bson.ObjectID `bson:"collaboratorIds"`
+	}
+
+	err = h.DB.Collection("projects").FindOne(ctx, bson.M{"_id": projectID}).Decode(&project)
+	if err != nil {
+		return false, err
+	}
+
+	if project.OwnerID == user.ID {
+		return true, nil
+	}
+
+	return slices.Contains(project.CollaboratorIDs, user.ID), nil
+}
@@ -0,0 +1,383 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gollaboratex/server/internal/api/graph/model"
+	"gollaboratex/server/internal/assetstore"
+	"gollaboratex/server/internal/latex"
+	"gollaboratex/server/internal/middleware"
+	"gollaboratex/server/internal/paths"
+
+	"github.com/minio/minio-go/v7"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ConflictPolicy decides what happens when an uploaded path already exists
+type ConflictPolicy string
+
+const (
+	ConflictSkip      ConflictPolicy = "skip"      // Keep the existing entry
+	ConflictOverwrite ConflictPolicy = "overwrite" // Replace its content
+	ConflictRename    ConflictPolicy = "rename"    // Store the upload as name-1.ext
+	ConflictFail      ConflictPolicy = "fail"      // Reject the upload (default)
+)
+
+// What happened to an uploaded entry
+const (
+	ActionCreated     = "created"
+	ActionOverwritten = "overwritten"
+	ActionRenamed     = "renamed"
+	ActionSkipped     = "skipped"
+	ActionFailed      = "failed"
+)
+
+// ErrConflict is returned for paths that already exist under ConflictFail
+var ErrConflict = errors.New("already exists")
+
+// ConflictError lists the paths an upload would collide with
+type ConflictError struct {
+	Paths []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%d path(s) already exist: %s", len(e.Paths), strings.Join(e.Paths, ", "))
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// parseConflictPolicy reads the onConflict form value
+func parseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return ConflictFail, nil
+	case ConflictSkip, ConflictOverwrite, ConflictRename, ConflictFail:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid onConflict %q: use skip, overwrite, rename or fail", s)
+}
+
+// EntryResult reports what happened to one uploaded entry
+type EntryResult struct {
+	Path     string `json:"path"`
+	Action   string `json:"action"`
+	StoredAs string `json:"storedAs,omitempty"` // New path of renamed entries
+	Error    string `json:"error,omitempty"`
+}
+
+// existingEntry is a file or asset already in the project
+type existingEntry struct {
+	fileID      bson.ObjectID
+	assetID     bson.ObjectID
+	objectKey   string
+	size        int64
+	placeholder bool // The empty main.tex a new project starts with
+}
+
+// projectIndex holds the occupied paths of a project during an upload
+type projectIndex struct {
+	entries  map[string]existingEntry
+	folders  map[string]bool
+	uploaded map[string]bool // Paths written by this upload
+}
+
+func (h *UploadHandler) loadProjectIndex(ctx context.Context, projectID bson.ObjectID) (*projectIndex, error) {
+	idx := &projectIndex{
+		entries:  map[string]existingEntry{},
+		folders:  map[string]bool{},
+		uploaded: map[string]bool{},
+	}
+
+	var project struct {
+		RootFileID bson.ObjectID `bson:"rootFileId"`
+	}
+	if err := h.DB.Collection("projects").FindOne(ctx, bson.M{"_id": projectID}).Decode(&project); err != nil {
+		return nil, err
+	}
+
+	cursor, err := h.DB.Collection("files").Find(ctx, bson.M{"projectId": projectID})
+	if err != nil {
+		return nil, err
+	}
+	var files []struct {
+		ID   bson.ObjectID `bson:"_id"`
+		Name string        `bson:"name"`
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		entry := existingEntry{fileID: f.ID}
+		if f.ID == project.RootFileID {
+			entry.placeholder, err = h.isEmptyPlaceholder(ctx, projectID, f.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		idx.entries[f.Name] = entry
+	}
+
+	cursor, err = h.DB.Collection("assets").Find(ctx, bson.M{"projectId": projectID})
+	if err != nil {
+		return nil, err
+	}
+	var assets []struct {
+		ID   bson.ObjectID `bson:"_id"`
+		Name string        `bson:"name"`
+		Path string        `bson:"path"`
+		Size int64         `bson:"size"`
+	}
+	if err := cursor.All(ctx, &assets); err != nil {
+		return nil, err
+	}
+	for _, a := range assets {
+		name := a.Name
+		if name == "" {
+			name = paths.AssetRelPath(a.Path)
+		}
+		idx.entries[name] = existingEntry{assetID: a.ID, objectKey: a.Path, size: a.Size}
+	}
+
+	cursor, err = h.DB.Collection("folders").Find(ctx, bson.M{"projectId": projectID})
+	if err != nil {
+		return nil, err
+	}
+	var folders []struct {
+		Path string `bson:"path"`
+	}
+	if err := cursor.All(ctx, &folders); err != nil {
+		return nil, err
+	}
+	for _, f := range folders {
+		idx.folders[f.Path] = true
+	}
+
+	return idx, nil
+}
+
+// taken reports whether name is occupied. The placeholder main.tex only
+// blocks assets: an uploaded text file simply fills it.
+func (idx *projectIndex) taken(name string, isText bool) bool {
+	if idx.folders[name] || idx.uploaded[name] {
+		return true
+	}
+	existing, ok := idx.entries[name]
+	return ok && !(existing.placeholder && isText)
+}
+
+// conflicts returns the names that already exist in the project
+func (idx *projectIndex) conflicts(names []string) []string {
+	var result []string
+	for _, name := range names {
+		_, isText := detectFileType(filepath.Ext(name))
+		if idx.taken(name, isText) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// resolve applies the policy to an uploaded path. It returns the action, the
+// path to store the entry at and, for overwrites, the entry to replace.
+func (idx *projectIndex) resolve(name string, isText bool, policy ConflictPolicy) (string, string, *existingEntry, error) {
+	if idx.uploaded[name] {
+		return "", "", nil, fmt.Errorf("%s appears more than once in the upload", name)
+	}
+
+	existing, exists := idx.entries[name]
+	if !exists && !idx.folders[name] {
+		return ActionCreated, name, nil, nil
+	}
+	if exists && existing.placeholder && isText {
+		return ActionOverwritten, name, &existing, nil
+	}
+
+	switch policy {
+	case ConflictSkip:
+		return ActionSkipped, name, nil, nil
+	case ConflictRename:
+		return ActionRenamed, idx.freeName(name), nil, nil
+	case ConflictOverwrite:
+		if !exists {
+			return "", "", nil, fmt.Errorf("%s is a folder", name)
+		}
+		if existing.fileID.IsZero() == isText {
+			if isText {
+				return "", "", nil, fmt.Errorf("%s exists as an asset", name)
+			}
+			return "", "", nil, fmt.Errorf("%s exists as a text file", name)
+		}
+		return ActionOverwritten, name, &existing, nil
+	}
+	return "", "", nil, fmt.Errorf("%s %w", name, ErrConflict)
+}
+
+// freeName returns name with the lowest numeric suffix that is not taken,
+// e.g. figures/plot-1.png
+func (idx *projectIndex) freeName(name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s-%d%s", base, i, ext)
+		if _, exists := idx.entries[candidate]; !exists && !idx.folders[candidate] && !idx.uploaded[candidate] {
+			return candidate
+		}
+	}
+}
+
+// importedEntry is the outcome of importEntry
+type importedEntry struct {
+	Result       EntryResult
+	FileID       bson.ObjectID // Text files only
+	RootDocument bool          // A .tex file that compiles on its own
+}
+
+// importEntry stores one uploaded file. For projects the conflict policy is
+// applied against idx; templates pass a nil idx. used tracks the project's
+// asset bytes for the storage limit.
+func (h *UploadHandler) importEntry(ctx context.Context, targetID bson.ObjectID, uploadType UploadType, idx *projectIndex, policy ConflictPolicy, name string, r io.Reader, size int64, used *int64) (importedEntry, error) {
+	result := importedEntry{Result: EntryResult{Path: name}}
+	fileType, isText := detectFileType(filepath.Ext(name))
+
+	action, target := ActionCreated, name
+	var existing *existingEntry
+	if idx != nil {
+		var err error
+		action, target, existing, err = idx.resolve(name, isText, policy)
+		if err != nil {
+			return result, err
+		}
+		if action == ActionSkipped {
+			result.Result.Action = action
+			return result, nil
+		}
+	}
+
+	if isText {
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return result, err
+		}
+		if existing != nil {
+			err = h.overwriteTextFile(ctx, targetID, existing.fileID, string(content))
+			result.FileID = existing.fileID
+		} else {
+			result.FileID, err = h.createFileDocument(ctx, targetID, target, fileType, string(content), uploadType)
+		}
+		if err != nil {
+			return result, err
+		}
+		result.RootDocument = fileType == model.FileTypeTex && latex.IsRootDocument(string(content))
+	} else {
+		var replaced int64
+		if existing != nil {
+			replaced = existing.size
+		}
+		if uploadType == UploadTypeProject {
+			if err := h.Policy.CheckQuota(target, *used-replaced, size); err != nil {
+				return result, err
+			}
+		}
+
+		var err error
+		if existing != nil {
+			err = h.overwriteAsset(ctx, targetID, *existing, target, r, size)
+		} else {
+			objectPath := paths.AssetObject(string(uploadType), targetID.Hex(), target)
+			err = h.createAsset(ctx, targetID, objectPath, r, size, uploadType)
+		}
+		if err != nil {
+			return result, err
+		}
+		*used += size - replaced
+	}
+
+	if idx != nil {
+		idx.uploaded[target] = true
+	}
+	result.Result.Action = action
+	if action == ActionRenamed {
+		result.Result.StoredAs = target
+	}
+	return result, nil
+}
+
+// overwriteTextFile replaces the content of a file through its working file,
+// so the change shows up in the next version like an edit would
+func (h *UploadHandler) overwriteTextFile(ctx context.Context, projectID, fileID bson.ObjectID, content string) error {
+	now := time.Now()
+
+	res, err := h.DB.Collection("working_files").UpdateMany(ctx,
+		bson.M{"fileId": fileID},
+		bson.M{"$set": bson.M{"content": content, "updatedAt": now}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update working file: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		_, err = h.DB.Collection("working_files").InsertOne(ctx, bson.M{
+			"fileId":    fileID,
+			"projectId": projectID,
+			"content":   content,
+			"updatedAt": now,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create working file document: %w", err)
+		}
+	}
+
+	set := bson.M{"updatedAt": now}
+	if user, err := middleware.GetUserFromContext(ctx); err == nil {
+		set["updatedBy"] = user.ID
+	}
+	h.DB.Collection("files").UpdateOne(ctx, bson.M{"_id": fileID}, bson.M{"$set": set})
+
+	h.DB.Collection("projects").UpdateOne(ctx,
+		bson.M{"_id": projectID},
+		bson.M{"$set": bson.M{"lastEditedAt": now}},
+	)
+	return nil
+}
+
+// overwriteAsset replaces the content of an asset, keeping its id and object
+func (h *UploadHandler) overwriteAsset(ctx context.Context, projectID bson.ObjectID, existing existingEntry, name string, r io.Reader, size int64) error {
+	mimeType, r, err := h.checkAsset(name, r, size)
+	if err != nil {
+		return err
+	}
+
+	// Templates created from this project keep the old content
+	if err := assetstore.DetachTemplates(ctx, h.DB, h.Minio, h.Bucket, existing.objectKey); err != nil {
+		return err
+	}
+
+	_, err = h.Minio.PutObject(ctx, h.Bucket, existing.objectKey, r, size, minio.PutObjectOptions{ContentType: mimeType})
+	if err != nil {
+		return fmt.Errorf("failed to upload to MinIO: %w", err)
+	}
+
+	now := time.Now()
+	set := bson.M{"size": size, "mimeType": mimeType, "updatedAt": now}
+	if user, err := middleware.GetUserFromContext(ctx); err == nil {
+		set["updatedBy"] = user.ID
+	}
+	_, err = h.DB.Collection("assets").UpdateOne(ctx, bson.M{"_id": existing.assetID}, bson.M{"$set": set})
+	if err != nil {
+		return fmt.Errorf("failed to write asset metadata to DB: %w", err)
+	}
+
+	h.DB.Collection("projects").UpdateOne(ctx,
+		bson.M{"_id": projectID},
+		bson.M{"$set": bson.M{"lastEditedAt": now}},
+	)
+	return nil
+}
@@ -5,10 +5,10 @@ import (
 	// "context"
 	"io"
 	"net/http"
-	"path/filepath"
 	"slices"
 
 	"gollaboratex/server/internal/middleware"
+	"gollaboratex/server/internal/paths"
 
 	"github.com/gin-gonic/gin"
 	"github.com/minio/minio-go/v7"
@@ -76,6 +76,19 @@ func (h *ProjectHandler) DownloadProject(c *gin.Context) {
 	zipWriter := zip.NewWriter(c.Writer)
 	defer zipWriter.Close()
 
+	// Explicit folders, so empty ones survive the round trip
+	folderCursor, err := h.DB.Collection("folders").Find(c, bson.M{"projectId": projectID})
+	if err == nil {
+		for folderCursor.Next(c) {
+			var folder bson.M
+			folderCursor.Decode(&folder)
+			if p, ok := folder["path"].(string); ok && p != "" {
+				zipWriter.Create(p + "/")
+			}
+		}
+		folderCursor.Close(c)
+	}
+
 	// 1️⃣ Add text files
 	cursor, err := h.DB.Collection("files").Find(c, bson.M{"projectId": projectID})
 	if err != nil {
@@ -110,6 +123,12 @@ func (h *ProjectHandler) DownloadProject(c *gin.Context) {
 
 		objectKey := asset["path"].(string)
 
+		// Assets go back to their place in the tree; older records only have the object key
+		name, _ := asset["name"].(string)
+		if name == "" {
+			name = paths.AssetRelPath(objectKey)
+		}
+
 		obj, err := h.Minio.GetObject(
 			c,
 			h.Bucket,
@@ -121,7 +140,7 @@ func (h *ProjectHandler) DownloadProject(c *gin.Context) {
 		}
 		defer obj.Close()
 
-		f, _ := zipWriter.Create("assets/" + filepath.Base(objectKey))
+		f, _ := zipWriter.Create(name)
 		io.Copy(f, obj)
 	}
 }
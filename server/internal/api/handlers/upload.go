@@ -3,6 +3,7 @@ package handlers
 import (
 	"archive/zip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -13,7 +14,11 @@ import (
 	"time"
 
 	"gollaboratex/server/internal/api/graph/model"
+	"gollaboratex/server/internal/assetpolicy"
+	"gollaboratex/server/internal/latex"
 	"gollaboratex/server/internal/middleware"
+	"gollaboratex/server/internal/paths"
+	"gollaboratex/server/internal/safezip"
 
 	"github.com/gin-gonic/gin"
 	"github.com/minio/minio-go/v7"
@@ -25,6 +30,9 @@ type UploadHandler struct {
 	DB     *mongo.Database
 	Minio  *minio.Client
 	Bucket string
+	Policy assetpolicy.Policy
+	// Limits for uploaded ZIP archives
+	ZIPLimits safezip.Limits
 }
 
 // UploadType determines what we're uploading to
@@ -61,6 +69,11 @@ func detectFileType(ext string) (model.FileType, bool) {
 
 // UploadSingleFile handles single file upload to project
 // POST /api/uploads/file
+// Body:
+//   - file: The file
+//   - projectId: Target project
+//   - path: Path inside the project (optional, defaults to the file name)
+//   - onConflict: skip, overwrite, rename or fail (default) when path exists
 func (h *UploadHandler) UploadSingleFile(c *gin.Context) {
 	// Get authenticated user from context
 	_, err := middleware.GetUserFromContext(c.Request.Context())
@@ -96,58 +109,74 @@ func (h *UploadHandler) UploadSingleFile(c *gin.Context) {
 		return
 	}
 
-	// Detect file type
-	ext := filepath.Ext(file.Filename)
-	fileType, isTextFile := detectFileType(ext)
+	// Optional path inside the project (e.g. figures/plot.png), defaults to the file name
+	name := c.PostForm("path")
+	if name == "" {
+		name = file.Filename
+	}
+	name, err = paths.Clean(name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	if isTextFile {
-		// Store in MongoDB
-		err = h.storeTextFile(c, projectOID, file, fileType, UploadTypeProject)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Text file uploaded successfully",
-			"type":    fileType,
-		})
-	} else if ext == ".zip" {
+	if strings.EqualFold(filepath.Ext(name), ".zip") {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Use /api/uploads/zip endpoint for ZIP files"})
 		return
+	}
 
-	} else {
-		// Asset upload
-		fileReader, err := file.Open()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		defer fileReader.Close()
-
-		objectPath := fmt.Sprintf(
-			"project/%s/assets/%s",
-			projectOID.Hex(),
-			file.Filename,
-		)
-
-		err = h.createAsset(
-			c.Request.Context(),
-			projectOID,
-			objectPath,
-			fileReader,
-			file.Size,
-			file.Header.Get("Content-Type"),
-			UploadTypeProject,
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+	policy, err := parseConflictPolicy(c.PostForm("onConflict"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Asset uploaded successfully",
-		})
+	ctx := c.Request.Context()
+	idx, err := h.loadProjectIndex(ctx, projectOID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	used, err := h.projectAssetBytes(ctx, projectOID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	fileReader, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer fileReader.Close()
+
+	// Text files go to MongoDB, everything else to MinIO
+	imported, err := h.importEntry(ctx, projectOID, UploadTypeProject, idx, policy, name, fileReader, file.Size, &used)
+	switch {
+	case errors.Is(err, ErrConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, assetpolicy.ErrProjectFull) || errors.Is(err, assetpolicy.ErrFileTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	case assetpolicy.Rejected(err):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	fileType, isTextFile := detectFileType(filepath.Ext(name))
+	response := gin.H{
+		"message": "Asset uploaded successfully",
+		"entry":   imported.Result,
 	}
+	if isTextFile {
+		response["message"] = "Text file uploaded successfully"
+		response["type"] = fileType
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // ============================================================================
@@ -164,6 +193,12 @@ func (h *UploadHandler) UploadSingleFile(c *gin.Context) {
 //   - isPublic: Template visibility (for templates, optional)
 //   - tags: Template tags (for templates, comma-separated, optional)
 //   - previewImage: Template preview image file (for templates, optional)
+//   - onConflict: skip, overwrite, rename or fail (default) for paths that
+//     already exist in the project; the response lists each entry's outcome
+//
+// A single top-level folder wrapping the archive is stripped (rootFolder). The
+// document with \documentclass and \begin{document} is reported as mainFile
+// and becomes the root file of projects that only have the initial empty main.tex.
 func (h *UploadHandler) UploadZIP(c *gin.Context) {
 	// Get authenticated user
 	user, err := middleware.GetUserFromContext(c.Request.Context())
@@ -172,6 +207,12 @@ func (h *UploadHandler) UploadZIP(c *gin.Context) {
 		return
 	}
 
+	policy, err := parseConflictPolicy(c.PostForm("onConflict"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Get ZIP file
 	zipFile, err := c.FormFile("file")
 	if err != nil {
@@ -322,7 +363,7 @@ func (h *UploadHandler) UploadZIP(c *gin.Context) {
 	}
 
 	// Process ZIP with the target type
-	stats, err := h.processZIPInMemory(c, targetID, zipFile, uploadType)
+	stats, err := h.processZIPInMemory(c, targetID, zipFile, uploadType, policy)
 	if err != nil {
 		// Cleanup on failure
 		if uploadType == UploadTypeTemplate {
@@ -338,6 +379,15 @@ func (h *UploadHandler) UploadZIP(c *gin.Context) {
 				h.Minio.RemoveObject(c.Request.Context(), h.Bucket, template.PreviewImage, minio.RemoveObjectOptions{})
 			}
 		}
+		var conflict *ConflictError
+		if errors.As(err, &conflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "conflicts": conflict.Paths})
+			return
+		}
+		if safezip.Rejected(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -350,6 +400,10 @@ func (h *UploadHandler) UploadZIP(c *gin.Context) {
 			"filesCreated":  stats.FilesCreated,
 			"assetsCreated": stats.AssetsCreated,
 			"errors":        stats.Errors,
+			"entryErrors":   stats.EntryErrors,
+			"entries":       stats.Entries,
+			"mainFile":      stats.MainFile,
+			"rootFolder":    stats.StrippedFolder,
 		})
 	} else {
 		c.JSON(http.StatusOK, gin.H{
@@ -357,6 +411,10 @@ func (h *UploadHandler) UploadZIP(c *gin.Context) {
 			"filesCreated":  stats.FilesCreated,
 			"assetsCreated": stats.AssetsCreated,
 			"errors":        stats.Errors,
+			"entryErrors":   stats.EntryErrors,
+			"entries":       stats.Entries,
+			"mainFile":      stats.MainFile,
+			"rootFolder":    stats.StrippedFolder,
 		})
 	}
 }
@@ -369,30 +427,32 @@ type ProcessingStats struct {
 	FilesCreated  int
 	AssetsCreated int
 	Errors        []string
+	EntryErrors   []EntryError
+	Entries       []EntryResult
+	// Detected root document, "" when the archive has none
+	MainFile string
+	// Top-level folder removed from all paths, "" when there was none
+	StrippedFolder string
+}
+
+// EntryError is an archive entry that was skipped, and why
+type EntryError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+func (s *ProcessingStats) fail(name string, err error) {
+	s.Errors = append(s.Errors, fmt.Sprintf("Error in %s: %v", name, err))
+	s.EntryErrors = append(s.EntryErrors, EntryError{Path: name, Error: err.Error()})
+	s.Entries = append(s.Entries, EntryResult{Path: name, Action: ActionFailed, Error: err.Error()})
 }
 
 // ============================================================================
 // DATABASE OPERATIONS
 // ============================================================================
 
-func (h *UploadHandler) storeTextFile(c *gin.Context, targetID bson.ObjectID, fileHeader *multipart.FileHeader, fileType model.FileType, uploadType UploadType) error {
-	// Open uploaded file
-	file, err := fileHeader.Open()
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
-
-	// Read content
-	content, err := io.ReadAll(file)
-	if err != nil {
-		return fmt.Errorf("failed to read file content: %w", err)
-	}
-
-	return h.createFileDocument(c.Request.Context(), targetID, fileHeader.Filename, fileType, string(content), uploadType)
-}
-
-func (h *UploadHandler) createFileDocument(ctx context.Context, targetID bson.ObjectID, filename string, fileType model.FileType, content string, uploadType UploadType) error {
+// createFileDocument stores a text file and returns the id of its file document
+func (h *UploadHandler) createFileDocument(ctx context.Context, targetID bson.ObjectID, filename string, fileType model.FileType, content string, uploadType UploadType) (bson.ObjectID, error) {
 	now := time.Now()
 
 	if uploadType == UploadTypeTemplate {
@@ -404,11 +464,11 @@ func (h *UploadHandler) createFileDocument(ctx context.Context, targetID bson.Ob
 			"content":    content,
 		}
 
-		_, err := h.DB.Collection("template_files").InsertOne(ctx, templateFileDoc)
+		result, err := h.DB.Collection("template_files").InsertOne(ctx, templateFileDoc)
 		if err != nil {
-			return fmt.Errorf("failed to create template file document: %w", err)
+			return bson.ObjectID{}, fmt.Errorf("failed to create template file document: %w", err)
 		}
-		return nil
+		return result.InsertedID.(bson.ObjectID), nil
 	}
 
 	// PROJECT UPLOAD
@@ -420,10 +480,13 @@ func (h *UploadHandler) createFileDocument(ctx context.Context, targetID bson.Ob
 		"createdAt": now,
 		"updatedAt": now,
 	}
+	if user, err := middleware.GetUserFromContext(ctx); err == nil {
+		fileDoc["updatedBy"] = user.ID
+	}
 
 	result, err := h.DB.Collection("files").InsertOne(ctx, fileDoc)
 	if err != nil {
-		return fmt.Errorf("failed to create file document: %w", err)
+		return bson.ObjectID{}, fmt.Errorf("failed to create file document: %w", err)
 	}
 
 	fileID := result.InsertedID.(bson.ObjectID)
@@ -438,7 +501,7 @@ func (h *UploadHandler) createFileDocument(ctx context.Context, targetID bson.Ob
 
 	_, err = h.DB.Collection("working_files").InsertOne(ctx, workingFileDoc)
 	if err != nil {
-		return fmt.Errorf("failed to create working file document: %w", err)
+		return bson.ObjectID{}, fmt.Errorf("failed to create working file document: %w", err)
 	}
 
 	// Update project lastEditedAt
@@ -447,19 +510,28 @@ func (h *UploadHandler) createFileDocument(ctx context.Context, targetID bson.Ob
 		bson.M{"$set": bson.M{"lastEditedAt": now}},
 	)
 
-	return nil
+	return fileID, nil
 }
 
+// createAsset stores an asset after checking its content against the policy.
+// The content type is detected from the data, not taken from the client.
 func (h *UploadHandler) createAsset(ctx context.Context,
 	targetID bson.ObjectID,
 	objectPath string,
 	reader io.Reader,
 	size int64,
-	mimeType string,
 	uploadType UploadType) error {
 
+	// Path of the asset inside the project tree
+	name := paths.AssetRelPath(objectPath)
+
+	mimeType, reader, err := h.checkAsset(name, reader, size)
+	if err != nil {
+		return err
+	}
+
 	// Upload to MinIO
-	_, err := h.Minio.PutObject(
+	_, err = h.Minio.PutObject(
 		ctx,
 		h.Bucket,
 		objectPath,
@@ -477,6 +549,7 @@ func (h *UploadHandler) createAsset(ctx context.Context,
 		// Create template asset
 		assetDoc := bson.M{
 			"templateId": targetID,
+			"name":       name,
 			"path":       objectPath,
 			"mimeType":   mimeType,
 			"size":       size,
@@ -493,10 +566,15 @@ func (h *UploadHandler) createAsset(ctx context.Context,
 	// PROJECT UPLOAD
 	assetDoc := bson.M{
 		"projectId": targetID,
+		"name":      name,
 		"path":      objectPath,
 		"mimeType":  mimeType,
 		"size":      size,
 		"createdAt": now,
+		"updatedAt": now,
+	}
+	if user, err := middleware.GetUserFromContext(ctx); err == nil {
+		assetDoc["updatedBy"] = user.ID
 	}
 
 	_, err = h.DB.Collection("assets").InsertOne(ctx, assetDoc)
@@ -507,6 +585,42 @@ func (h *UploadHandler) createAsset(ctx context.Context,
 	return nil
 }
 
+// checkAsset detects the content type of an asset and checks it against the
+// policy. The returned reader yields the full content.
+func (h *UploadHandler) checkAsset(name string, reader io.Reader, size int64) (string, io.Reader, error) {
+	detected, reader, err := assetpolicy.Sniff(reader)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	mimeType, err := h.Policy.Check(name, detected, size)
+	if err != nil {
+		return "", nil, err
+	}
+	return mimeType, reader, nil
+}
+
+// projectAssetBytes sums the sizes of a project's assets
+func (h *UploadHandler) projectAssetBytes(ctx context.Context, projectID bson.ObjectID) (int64, error) {
+	cursor, err := h.DB.Collection("assets").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"projectId": projectID}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$size"}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Total int64 `bson:"total"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, err
+		}
+	}
+	return result.Total, cursor.Err()
+}
+
 func (h *UploadHandler) checkProjectAccess(ctx context.Context, projectID bson.ObjectID) (bool, error) {
 	user, err := middleware.GetUserFromContext(ctx)
 	if err != nil {
@@ -534,8 +648,8 @@ func (h *UploadHandler) checkProjectAccess(ctx context.Context, projectID bson.O
 	return false, nil
 }
 
-func (h *UploadHandler) processZIPInMemory(c *gin.Context, targetID bson.ObjectID, zipHeader *multipart.FileHeader, uploadType UploadType) (*ProcessingStats, error) {
-	stats := &ProcessingStats{Errors: make([]string, 0)}
+func (h *UploadHandler) processZIPInMemory(c *gin.Context, targetID bson.ObjectID, zipHeader *multipart.FileHeader, uploadType UploadType, policy ConflictPolicy) (*ProcessingStats, error) {
+	stats := &ProcessingStats{Errors: make([]string, 0), EntryErrors: make([]EntryError, 0), Entries: make([]EntryResult, 0)}
 
 	// Open the uploaded multipart file
 	file, err := zipHeader.Open()
@@ -544,16 +658,28 @@ func (h *UploadHandler) processZIPInMemory(c *gin.Context, targetID bson.ObjectI
 	}
 	defer file.Close()
 
-	// Create a ZIP reader from the stream
-	zipReader, err := zip.NewReader(file, zipHeader.Size)
+	// Create a ZIP reader from the stream; archives declaring too much are rejected here
+	zipReader, err := safezip.NewReader(file, zipHeader.Size, h.ZIPLimits)
+	if safezip.Rejected(err) {
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("invalid zip archive: %w", err)
 	}
 
+	// First pass: sanitize the entry paths
+	type zipEntry struct {
+		file *zip.File
+		name string
+	}
+	var entries []zipEntry
+	var names []string
 	for _, zipFile := range zipReader.File {
-		// Security: Prevent path traversal
-		if strings.Contains(zipFile.Name, "..") || strings.HasPrefix(zipFile.Name, "/") {
-			stats.Errors = append(stats.Errors, fmt.Sprintf("Skipped unsafe path: %s", zipFile.Name))
+		// Security: Reject symlinks, path traversal and deep nesting; the
+		// sanitized path keeps the archive's folders
+		name, err := zipReader.Path(zipFile)
+		if err != nil {
+			stats.fail(zipFile.Name, err)
 			continue
 		}
 
@@ -563,85 +689,187 @@ func (h *UploadHandler) processZIPInMemory(c *gin.Context, targetID bson.ObjectI
 		}
 
 		// Skip macOS metadata files
-		if strings.HasPrefix(filepath.Base(zipFile.Name), "._") || strings.Contains(zipFile.Name, "__MACOSX") {
+		if strings.HasPrefix(filepath.Base(name), "._") || strings.HasPrefix(name, "__MACOSX/") {
 			continue
 		}
 
+		entries = append(entries, zipEntry{file: zipFile, name: name})
+		names = append(names, name)
+	}
+
+	// Archives of a whole folder (e.g. Overleaf or GitHub downloads) wrap
+	// everything in one top-level folder; the project starts inside it
+	if root := paths.CommonRoot(names); root != "" {
+		stats.StrippedFolder = root
+		for i := range entries {
+			entries[i].name = strings.TrimPrefix(entries[i].name, root+"/")
+		}
+	}
+
+	// Existing paths of the project, matched against the archive. Assets
+	// already in the project count towards its storage limit.
+	var idx *projectIndex
+	var used int64
+	if uploadType == UploadTypeProject {
+		idx, err = h.loadProjectIndex(c.Request.Context(), targetID)
+		if err != nil {
+			return nil, err
+		}
+		used, err = h.projectAssetBytes(c.Request.Context(), targetID)
+		if err != nil {
+			return nil, err
+		}
+
+		// Nothing is written when any path collides
+		if policy == ConflictFail {
+			names := make([]string, len(entries))
+			for i, entry := range entries {
+				names[i] = entry.name
+			}
+			if conflicts := idx.conflicts(names); len(conflicts) > 0 {
+				return nil, &ConflictError{Paths: conflicts}
+			}
+		}
+	}
+
+	// Documents that can be compiled on their own, by stored path
+	rootDocs := map[string]bson.ObjectID{}
+
+	for _, entry := range entries {
+		zipFile, name := entry.file, entry.name
+
 		// Use a closure to ensure files are closed immediately after each iteration
-		err := func() error {
-			rc, err := zipFile.Open()
+		err = func() error {
+			rc, err := zipReader.Open(zipFile)
 			if err != nil {
 				return err
 			}
 			defer rc.Close()
 
-			ext := filepath.Ext(zipFile.Name)
-			fileType, isTextFile := detectFileType(ext)
+			// Text files route to MongoDB, assets to MinIO
+			imported, err := h.importEntry(c.Request.Context(), targetID, uploadType, idx, policy, name, rc, int64(zipFile.UncompressedSize64), &used)
+			if err != nil {
+				return err
+			}
+			stats.Entries = append(stats.Entries, imported.Result)
+			if imported.Result.Action == ActionSkipped {
+				return nil
+			}
 
-			if isTextFile {
-				// ROUTE TO MONGODB (template_files or project files)
-				content, err := io.ReadAll(rc)
-				if err != nil {
-					return err
-				}
-				err = h.createFileDocument(c.Request.Context(), targetID, zipFile.Name, fileType, string(content), uploadType)
-				if err != nil {
-					return err
+			stored := name
+			if imported.Result.StoredAs != "" {
+				stored = imported.Result.StoredAs
+			}
+			if _, isTextFile := detectFileType(filepath.Ext(name)); isTextFile {
+				if imported.RootDocument {
+					rootDocs[stored] = imported.FileID
 				}
 				stats.FilesCreated++
 			} else {
-				// ROUTE TO MINIO (template_assets or project assets)
-				var objectPath string
-				if uploadType == UploadTypeTemplate {
-					objectPath = fmt.Sprintf(
-						"template/%s/assets/%s",
-						targetID.Hex(),
-						filepath.Base(zipFile.Name),
-					)
-				} else {
-					objectPath = fmt.Sprintf(
-						"project/%s/assets/%s",
-						targetID.Hex(),
-						filepath.Base(zipFile.Name),
-					)
-				}
-
-				// Detect MIME type from extension if not provided
-				mimeType := ""
-				switch strings.ToLower(ext) {
-				case ".png":
-					mimeType = "image/png"
-				case ".jpg", ".jpeg":
-					mimeType = "image/jpeg"
-				case ".gif":
-					mimeType = "image/gif"
-				case ".pdf":
-					mimeType = "application/pdf"
-				case ".svg":
-					mimeType = "image/svg+xml"
-				}
-
-				err = h.createAsset(
-					c.Request.Context(),
-					targetID,
-					objectPath,
-					rc,
-					int64(zipFile.UncompressedSize64),
-					mimeType,
-					uploadType,
-				)
-				if err != nil {
-					return err
-				}
 				stats.AssetsCreated++
 			}
 			return nil
 		}()
 
 		if err != nil {
-			stats.Errors = append(stats.Errors, fmt.Sprintf("Error in %s: %v", zipFile.Name, err))
+			stats.fail(name, err)
+		}
+		// The archive expanded beyond its limit; nothing after this entry is imported
+		if errors.Is(err, safezip.ErrTooLarge) {
+			break
+		}
+	}
+
+	candidates := make([]string, 0, len(rootDocs))
+	for name := range rootDocs {
+		candidates = append(candidates, name)
+	}
+	stats.MainFile = latex.PickRoot(candidates)
+
+	if stats.MainFile != "" && uploadType == UploadTypeProject {
+		if err := h.adoptRootFile(c.Request.Context(), targetID, rootDocs[stats.MainFile]); err != nil {
+			stats.Errors = append(stats.Errors, fmt.Sprintf("Failed to set main file: %v", err))
 		}
 	}
 
 	return stats, nil
 }
+
+// adoptRootFile makes an imported document the project's root file when the
+// project has no real root yet: no root at all, or the empty main.tex that
+// CreateProject starts with, which is deleted.
+func (h *UploadHandler) adoptRootFile(ctx context.Context, projectID, fileID bson.ObjectID) error {
+	var project struct {
+		RootFileID bson.ObjectID `bson:"rootFileId"`
+	}
+	err := h.DB.Collection("projects").FindOne(ctx, bson.M{"_id": projectID}).Decode(&project)
+	if err != nil {
+		return err
+	}
+	if project.RootFileID == fileID {
+		return nil
+	}
+
+	if !project.RootFileID.IsZero() {
+		placeholder, err := h.isEmptyPlaceholder(ctx, projectID, project.RootFileID)
+		if err != nil {
+			return err
+		}
+		if !placeholder {
+			return nil
+		}
+		if _, err := h.DB.Collection("working_files").DeleteMany(ctx, bson.M{"fileId": project.RootFileID}); err != nil {
+			return err
+		}
+		if _, err := h.DB.Collection("files").DeleteOne(ctx, bson.M{"_id": project.RootFileID}); err != nil {
+			return err
+		}
+	}
+
+	_, err = h.DB.Collection("projects").UpdateOne(ctx,
+		bson.M{"_id": projectID},
+		bson.M{"$set": bson.M{"rootFileId": fileID}},
+	)
+	return err
+}
+
+// isEmptyPlaceholder reports whether a root file is missing or an empty main.tex
+func (h *UploadHandler) isEmptyPlaceholder(ctx context.Context, projectID, fileID bson.ObjectID) (bool, error) {
+	var file struct {
+		Name string `bson:"name"`
+	}
+	err := h.DB.Collection("files").FindOne(ctx, bson.M{"_id": fileID, "projectId": projectID}).Decode(&file)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !latex.IsPlaceholder(file.Name, "") {
+		return false, nil
+	}
+
+	cursor, err := h.DB.Collection("working_files").Find(ctx, bson.M{"fileId": fileID})
+	if err != nil {
+		return false, err
+	}
+	var working []struct {
+		Content string `bson:"content"`
+	}
+	if err := cursor.All(ctx, &working); err != nil {
+		return false, err
+	}
+	for _, w := range working {
+		if !latex.IsPlaceholder(file.Name, w.Content) {
+			return false, nil
+		}
+	}
+
+	// Versions may still reference it; automatic ones are never taken of the
+	// untouched placeholder
+	count, err := h.DB.Collection("versions").CountDocuments(ctx, bson.M{"projectId": projectID})
+	if err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
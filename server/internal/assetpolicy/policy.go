@@ -0,0 +1,236 @@
+// Package assetpolicy decides which binary assets may be stored in a project.
+// Content types are detected from the bytes of a file, not from what the
+// client claims, and checked against an allow-list and size limits.
+package assetpolicy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// SniffLen is the number of leading bytes used to detect a content type
+const SniffLen = 3072
+
+var (
+	ErrTypeNotAllowed = errors.New("file type not allowed")
+	ErrTypeMismatch   = errors.New("content does not match file extension")
+	ErrFileTooLarge   = errors.New("file too large")
+	ErrProjectFull    = errors.New("project storage limit reached")
+)
+
+// DefaultAllowedTypes covers what LaTeX projects include: images, PDFs and
+// PostScript, fonts and data files read by packages such as pgfplots. Text
+// types are listed one by one so markup like text/html is never stored.
+var DefaultAllowedTypes = []string{
+	"image/*",
+	"application/pdf",
+	"application/postscript",
+	"font/*",
+	"application/vnd.ms-fontobject",
+	"text/plain",
+	"text/csv",
+	"text/tab-separated-values",
+	"application/json",
+}
+
+// Types browsers render without running scripts; every other type is only
+// ever served as a download
+var inlineTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/bmp":       true,
+	"image/tiff":      true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+// Inline reports whether content of the given type may be displayed inline
+func Inline(contentType string) bool {
+	return inlineTypes[essence(contentType)]
+}
+
+// Policy holds the asset limits. A zero MaxFileSize or MaxProjectSize means
+// no limit; empty AllowedTypes means DefaultAllowedTypes.
+type Policy struct {
+	AllowedTypes   []string // Exact types or "type/*"
+	MaxFileSize    int64    // Bytes per asset
+	MaxProjectSize int64    // Bytes of assets per project
+}
+
+// DefaultPolicy allows the default types, 50MB per file and 500MB per project
+func DefaultPolicy() Policy {
+	return Policy{
+		AllowedTypes:   DefaultAllowedTypes,
+		MaxFileSize:    50 << 20,
+		MaxProjectSize: 500 << 20,
+	}
+}
+
+// ParseTypes splits a comma-separated list of types, e.g. "image/*,application/pdf"
+func ParseTypes(s string) []string {
+	var types []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// Types expected for well-known extensions. Text formats are matched loosely:
+// any text content is accepted for them.
+var extTypes = map[string]string{
+	".png":   "image/png",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".gif":   "image/gif",
+	".bmp":   "image/bmp",
+	".tif":   "image/tiff",
+	".tiff":  "image/tiff",
+	".webp":  "image/webp",
+	".svg":   "image/svg+xml",
+	".pdf":   "application/pdf",
+	".eps":   "application/postscript",
+	".ps":    "application/postscript",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".eot":   "application/vnd.ms-fontobject",
+	".csv":   "text/csv",
+	".tsv":   "text/tab-separated-values",
+	".txt":   "text/plain",
+	".dat":   "text/plain",
+	".json":  "application/json",
+	".xml":   "text/xml",
+}
+
+var textTypes = map[string]bool{
+	"image/svg+xml":             true,
+	"text/csv":                  true,
+	"text/tab-separated-values": true,
+	"text/plain":                true,
+	"application/json":          true,
+	"text/xml":                  true,
+}
+
+// Detect returns the content type of a file from its leading bytes
+func Detect(head []byte) string {
+	return mimetype.Detect(head).String()
+}
+
+// Sniff detects the content type of r. The returned reader still yields the
+// whole content, including the bytes consumed for detection.
+func Sniff(r io.Reader) (string, io.Reader, error) {
+	head := make([]byte, SniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+	head = head[:n]
+	return Detect(head), io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+// Check validates an asset named name whose content was detected as detected.
+// It returns the content type to store: the extension's type when the content
+// matches it, the detected type otherwise.
+func (p Policy) Check(name, detected string, size int64) (string, error) {
+	if err := p.CheckSize(name, size); err != nil {
+		return "", err
+	}
+
+	contentType := detected
+	if expected, ok := extTypes[strings.ToLower(path.Ext(name))]; ok {
+		if !matches(detected, expected) {
+			return "", fmt.Errorf("%w: %s contains %s", ErrTypeMismatch, name, essence(detected))
+		}
+		contentType = expected
+	}
+
+	if !p.allows(contentType) {
+		return "", fmt.Errorf("%w: %s (%s)", ErrTypeNotAllowed, name, essence(contentType))
+	}
+	return contentType, nil
+}
+
+// CheckSize validates the size of a single asset
+func (p Policy) CheckSize(name string, size int64) error {
+	if p.MaxFileSize > 0 && size > p.MaxFileSize {
+		return fmt.Errorf("%w: %s is %s, the limit is %s", ErrFileTooLarge, name, formatSize(size), formatSize(p.MaxFileSize))
+	}
+	return nil
+}
+
+// CheckQuota validates adding size bytes to a project already using used bytes
+func (p Policy) CheckQuota(name string, used, size int64) error {
+	if p.MaxProjectSize > 0 && used+size > p.MaxProjectSize {
+		return fmt.Errorf("%w: adding %s (%s) would exceed %s", ErrProjectFull, name, formatSize(size), formatSize(p.MaxProjectSize))
+	}
+	return nil
+}
+
+func (p Policy) allows(contentType string) bool {
+	allowed := p.AllowedTypes
+	if len(allowed) == 0 {
+		allowed = DefaultAllowedTypes
+	}
+	t := essence(contentType)
+	for _, pattern := range allowed {
+		if pattern == t {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(t, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether detected content is of the expected type or a subtype of it
+func matches(detected, expected string) bool {
+	m := mimetype.Lookup(essence(detected))
+	if m == nil {
+		return false
+	}
+	if textTypes[expected] {
+		expected = "text/plain"
+	}
+	for ; m != nil; m = m.Parent() {
+		if m.Is(expected) {
+			return true
+		}
+	}
+	return false
+}
+
+func essence(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return t
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// Rejected reports whether err is a policy violation rather than a storage failure
+func Rejected(err error) bool {
+	return errors.Is(err, ErrTypeNotAllowed) || errors.Is(err, ErrTypeMismatch) ||
+		errors.Is(err, ErrFileTooLarge) || errors.Is(err, ErrProjectFull)
+}
@@ -0,0 +1,54 @@
+// Package assetstore holds storage operations on asset objects shared by the
+// GraphQL resolvers and the upload handlers.
+package assetstore
+
+import (
+	"context"
+	"fmt"
+
+	"gollaboratex/server/internal/paths"
+
+	"github.com/minio/minio-go/v7"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// DetachTemplates gives templates that share an asset object their own copy,
+// so a project can overwrite or delete it
+func DetachTemplates(ctx context.Context, db *mongo.Database, minioClient *minio.Client, bucket, objectKey string) error {
+	cursor, err := db.Collection("template_assets").Find(ctx, bson.M{"path": objectKey})
+	if err != nil {
+		return err
+	}
+	var shared []struct {
+		ID         bson.ObjectID `bson:"_id"`
+		TemplateID bson.ObjectID `bson:"templateId"`
+		Name       string        `bson:"name"`
+	}
+	if err := cursor.All(ctx, &shared); err != nil {
+		return err
+	}
+
+	for _, ta := range shared {
+		name := ta.Name
+		if name == "" {
+			name = paths.AssetRelPath(objectKey)
+		}
+		copyKey := paths.AssetObject("template", ta.TemplateID.Hex(), name)
+		_, err := minioClient.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: bucket, Object: copyKey},
+			minio.CopySrcOptions{Bucket: bucket, Object: objectKey},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to copy template asset: %w", err)
+		}
+		_, err = db.Collection("template_assets").UpdateOne(ctx,
+			bson.M{"_id": ta.ID},
+			bson.M{"$set": bson.M{"path": copyKey}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+// Package diff computes line-based diffs of text files and groups them into
+// unified hunks.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind of a diff line
+type Kind int
+
+const (
+	Equal Kind = iota
+	Insert
+	Delete
+)
+
+// Line is one line of a diff. Old and New are 1-based line numbers in the
+// respective text, 0 when the line does not exist there.
+type Line struct {
+	Kind Kind
+	Text string
+	Old  int
+	New  int
+}
+
+// Hunk is a group of changes with surrounding context
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Lines              []Line
+}
+
+// Header returns the unified diff header, e.g. "@@ -1,3 +1,4 @@"
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+// Beyond this many edits the middle of the texts is reported as replaced
+// wholesale instead of searching further for a minimal diff.
+const maxEdits = 4000
+
+// SplitLines splits text into lines without their line breaks
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Lines diffs two texts line by line
+func Lines(a, b string) []Line {
+	return diffLines(SplitLines(a), SplitLines(b))
+}
+
+func diffLines(a, b []string) []Line {
+	// Common prefix and suffix need no search
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var kinds []Kind
+	for i := 0; i < prefix; i++ {
+		kinds = append(kinds, Equal)
+	}
+	kinds = append(kinds, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for i := 0; i < suffix; i++ {
+		kinds = append(kinds, Equal)
+	}
+
+	lines := make([]Line, 0, len(kinds))
+	i, j := 0, 0
+	for _, k := range kinds {
+		switch k {
+		case Equal:
+			lines = append(lines, Line{Kind: Equal, Text: a[i], Old: i + 1, New: j + 1})
+			i++
+			j++
+		case Delete:
+			lines = append(lines, Line{Kind: Delete, Text: a[i], Old: i + 1})
+			i++
+		case Insert:
+			lines = append(lines, Line{Kind: Insert, Text: b[j], New: j + 1})
+			j++
+		}
+	}
+	return lines
+}
+
+// myers returns the edit script turning a into b (Myers' O(ND) algorithm)
+func myers(a, b []string) []Kind {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return replaceAll(n, m)
+	}
+
+	total := n + m
+	offset := total + 1
+	v := make([]int, 2*total+3)
+	var trace [][]int // trace[d][k+d] is the furthest x on diagonal k after d edits
+
+	for d := 0; d <= total; d++ {
+		if d > maxEdits {
+			return replaceAll(n, m)
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+				return backtrack(trace, n, m)
+			}
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+	}
+	return replaceAll(n, m)
+}
+
+func backtrack(trace [][]int, n, m int) []Kind {
+	var kinds []Kind
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		get := func(k int) int { return prev[k+d-1] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && get(k-1) < get(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := get(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			kinds = append(kinds, Equal)
+			x--
+			y--
+		}
+		if prevK == k+1 {
+			kinds = append(kinds, Insert)
+		} else {
+			kinds = append(kinds, Delete)
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		kinds = append(kinds, Equal)
+		x--
+		y--
+	}
+
+	for i, j := 0, len(kinds)-1; i < j; i, j = i+1, j-1 {
+		kinds[i], kinds[j] = kinds[j], kinds[i]
+	}
+	return kinds
+}
+
+func replaceAll(n, m int) []Kind {
+	kinds := make([]Kind, 0, n+m)
+	for i := 0; i < n; i++ {
+		kinds = append(kinds, Delete)
+	}
+	for i := 0; i < m; i++ {
+		kinds = append(kinds, Insert)
+	}
+	return kinds
+}
+
+// Stats counts inserted and deleted lines
+func Stats(lines []Line) (additions, deletions int) {
+	for _, l := range lines {
+		switch l.Kind {
+		case Insert:
+			additions++
+		case Delete:
+			deletions++
+		}
+	}
+	return additions, deletions
+}
+
+// Hunks groups changed lines with up to context unchanged lines around them.
+// Changes closer than 2*context lines share a hunk.
+func Hunks(lines []Line, context int) []Hunk {
+	var hunks []Hunk
+	i := 0
+	for i < len(lines) {
+		// Find the next change
+		for i < len(lines) && lines[i].Kind == Equal {
+			i++
+		}
+		if i == len(lines) {
+			break
+		}
+
+		start := max(i-context, 0)
+		end := i
+		for end < len(lines) {
+			if lines[end].Kind != Equal {
+				end++
+				continue
+			}
+			// Count the run of unchanged lines
+			run := end
+			for run < len(lines) && lines[run].Kind == Equal {
+				run++
+			}
+			if run == len(lines) || run-end > 2*context {
+				end = min(end+context, len(lines))
+				break
+			}
+			end = run
+		}
+
+		hunks = append(hunks, newHunk(lines, start, end))
+		i = end
+	}
+	return hunks
+}
+
+func newHunk(lines []Line, start, end int) Hunk {
+	h := Hunk{Lines: lines[start:end]}
+	for _, l := range h.Lines {
+		if l.Old > 0 {
+			if h.OldLines == 0 {
+				h.OldStart = l.Old
+			}
+			h.OldLines++
+		}
+		if l.New > 0 {
+			if h.NewLines == 0 {
+				h.NewStart = l.New
+			}
+			h.NewLines++
+		}
+	}
+
+	// An empty side starts after the line preceding the hunk
+	if h.OldLines == 0 {
+		for k := start - 1; k >= 0; k-- {
+			if lines[k].Old > 0 {
+				h.OldStart = lines[k].Old
+				break
+			}
+		}
+	}
+	if h.NewLines == 0 {
+		for k := start - 1; k >= 0; k-- {
+			if lines[k].New > 0 {
+				h.NewStart = lines[k].New
+				break
+			}
+		}
+	}
+	return h
+}
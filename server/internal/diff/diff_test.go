@@ -0,0 +1,197 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// render writes a diff as " a", "-b", "+c" lines
+func render(lines []Line) string {
+	var sb strings.Builder
+	for _, l := range lines {
+		switch l.Kind {
+		case Equal:
+			sb.WriteString(" ")
+		case Delete:
+			sb.WriteString("-")
+		case Insert:
+			sb.WriteString("+")
+		}
+		sb.WriteString(l.Text)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// sides rebuilds both texts from a diff and checks its line numbers
+func sides(t *testing.T, lines []Line) (a, b []string) {
+	t.Helper()
+	for _, l := range lines {
+		if l.Kind != Insert {
+			a = append(a, l.Text)
+			if l.Old != len(a) {
+				t.Errorf("%q: old line %d, want %d", l.Text, l.Old, len(a))
+			}
+		} else if l.Old != 0 {
+			t.Errorf("inserted %q has old line %d", l.Text, l.Old)
+		}
+		if l.Kind != Delete {
+			b = append(b, l.Text)
+			if l.New != len(b) {
+				t.Errorf("%q: new line %d, want %d", l.Text, l.New, len(b))
+			}
+		} else if l.New != 0 {
+			t.Errorf("deleted %q has new line %d", l.Text, l.New)
+		}
+	}
+	return a, b
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"a", []string{"a"}},
+		{"a\n", []string{"a"}},
+		{"a\nb", []string{"a", "b"}},
+		{"a\n\n", []string{"a", ""}},
+		{"\n", []string{""}},
+	}
+	for _, tt := range tests {
+		if got := SplitLines(tt.text); !slicesEqual(got, tt.want) {
+			t.Errorf("SplitLines(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", " a\n b\n"},
+		{"both empty", "", "", ""},
+		{"from empty", "", "a\nb\n", "+a\n+b\n"},
+		{"to empty", "a\nb\n", "", "-a\n-b\n"},
+		{"insert at start", "b\nc\n", "a\nb\nc\n", "+a\n b\n c\n"},
+		{"insert at end", "a\nb\n", "a\nb\nc\n", " a\n b\n+c\n"},
+		{"delete at start", "a\nb\nc\n", "b\nc\n", "-a\n b\n c\n"},
+		{"delete at end", "a\nb\nc\n", "a\nb\n", " a\n b\n-c\n"},
+		{"replace in middle", "a\nb\nc\n", "a\nx\nc\n", " a\n-b\n+x\n c\n"},
+		{"no trailing newline", "a\nb", "a\nb\n", " a\n b\n"},
+		{"last line without newline changed", "a\nb", "a\nc", " a\n-b\n+c\n"},
+		{"minimal", "a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n", "-a\n-b\n c\n+b\n a\n b\n-b\n a\n+c\n"},
+	}
+	for _, tt := range tests {
+		lines := Lines(tt.a, tt.b)
+		if got := render(lines); got != tt.want {
+			t.Errorf("%s: Lines =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+		a, b := sides(t, lines)
+		if !slicesEqual(a, SplitLines(tt.a)) || !slicesEqual(b, SplitLines(tt.b)) {
+			t.Errorf("%s: diff does not rebuild its texts", tt.name)
+		}
+	}
+}
+
+func TestLinesGivesUpBeyondMaxEdits(t *testing.T) {
+	// Every tenth line is common, but finding them takes more than maxEdits
+	var a, b []string
+	for i := 0; i < 2500; i++ {
+		if i%10 == 0 {
+			a = append(a, fmt.Sprintf("same %d", i))
+			b = append(b, fmt.Sprintf("same %d", i))
+			continue
+		}
+		a = append(a, fmt.Sprintf("a %d", i))
+		b = append(b, fmt.Sprintf("b %d", i))
+	}
+	a = append(a, "end")
+	b = append(b, "end")
+
+	lines := Lines(strings.Join(a, "\n"), strings.Join(b, "\n"))
+	gotA, gotB := sides(t, lines)
+	if !slicesEqual(gotA, a) || !slicesEqual(gotB, b) {
+		t.Fatal("diff does not rebuild its texts")
+	}
+
+	// The common first and last lines are still found, everything between
+	// is replaced wholesale
+	if lines[0].Kind != Equal || lines[len(lines)-1].Kind != Equal {
+		t.Errorf("common prefix or suffix not kept: %v, %v", lines[0], lines[len(lines)-1])
+	}
+	additions, deletions := Stats(lines)
+	if additions != len(b)-2 || deletions != len(a)-2 {
+		t.Errorf("Stats = +%d -%d, want +%d -%d", additions, deletions, len(b)-2, len(a)-2)
+	}
+}
+
+func TestStats(t *testing.T) {
+	additions, deletions := Stats(Lines("a\nb\nc\n", "a\nx\ny\n"))
+	if additions != 2 || deletions != 2 {
+		t.Errorf("Stats = +%d -%d, want +2 -2", additions, deletions)
+	}
+}
+
+func TestHunks(t *testing.T) {
+	numbered := func(n int, change map[int]string) string {
+		var sb strings.Builder
+		for i := 1; i <= n; i++ {
+			if text, ok := change[i]; ok {
+				if text != "" {
+					sb.WriteString(text + "\n")
+				}
+				continue
+			}
+			fmt.Fprintf(&sb, "%d\n", i)
+		}
+		return sb.String()
+	}
+	base := numbered(20, nil)
+
+	tests := []struct {
+		name    string
+		a, b    string
+		context int
+		want    []string // headers
+	}{
+		{"no changes", base, base, 3, nil},
+		{"change in middle", base, numbered(20, map[int]string{10: "x"}), 3, []string{"@@ -7,7 +7,7 @@"}},
+		{"insert at start", base, "0\n" + base, 3, []string{"@@ -1,3 +1,4 @@"}},
+		{"append at end", base, base + "21\n", 3, []string{"@@ -18,3 +18,4 @@"}},
+		{"delete at start", base, numbered(20, map[int]string{1: ""}), 3, []string{"@@ -1,4 +1,3 @@"}},
+		{"delete at end", base, numbered(20, map[int]string{20: ""}), 3, []string{"@@ -17,4 +17,3 @@"}},
+		{"close changes share a hunk", base, numbered(20, map[int]string{5: "x", 11: "y"}), 3, []string{"@@ -2,13 +2,13 @@"}},
+		{"distant changes split", base, numbered(20, map[int]string{5: "x", 13: "y"}), 3, []string{"@@ -2,7 +2,7 @@", "@@ -10,7 +10,7 @@"}},
+		{"without context", base, numbered(20, map[int]string{5: "x", 7: "y"}), 0, []string{"@@ -5,1 +5,1 @@", "@@ -7,1 +7,1 @@"}},
+		{"pure insert without context", base, numbered(20, map[int]string{5: "5\nx"}), 0, []string{"@@ -5,0 +6,1 @@"}},
+		{"from empty", "", "a\nb\n", 3, []string{"@@ -0,0 +1,2 @@"}},
+		{"to empty", "a\nb\n", "", 3, []string{"@@ -1,2 +0,0 @@"}},
+	}
+	for _, tt := range tests {
+		hunks := Hunks(Lines(tt.a, tt.b), tt.context)
+		var got []string
+		for _, h := range hunks {
+			got = append(got, h.Header())
+			oldLines, newLines := 0, 0
+			for _, l := range h.Lines {
+				if l.Kind != Insert {
+					oldLines++
+				}
+				if l.Kind != Delete {
+					newLines++
+				}
+			}
+			if oldLines != h.OldLines || newLines != h.NewLines {
+				t.Errorf("%s: %s spans %d old and %d new lines", tt.name, h.Header(), oldLines, newLines)
+			}
+		}
+		if !slicesEqual(got, tt.want) {
+			t.Errorf("%s: Hunks = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package diff
+
+import (
+	"sort"
+	"strings"
+)
+
+// Conflict is a region both sides changed differently in a three-way merge
+type Conflict struct {
+	Line   int // 1-based line of the "<<<<<<<" marker in the merged text
+	Base   []string
+	Ours   []string
+	Theirs []string
+}
+
+// change replaces base[BaseStart:BaseEnd] with side[Start:End]
+type change struct {
+	baseStart, baseEnd int
+	start, end         int
+	theirs             bool
+}
+
+// changes returns the regions of base that side replaced
+func changes(base, side []string, theirs bool) []change {
+	var result []change
+	var cur *change
+	i, j := 0, 0
+	for _, l := range diffLines(base, side) {
+		switch l.Kind {
+		case Equal:
+			if cur != nil {
+				result = append(result, *cur)
+				cur = nil
+			}
+			i++
+			j++
+		case Delete:
+			if cur == nil {
+				cur = &change{baseStart: i, baseEnd: i, start: j, end: j, theirs: theirs}
+			}
+			i++
+			cur.baseEnd = i
+		case Insert:
+			if cur == nil {
+				cur = &change{baseStart: i, baseEnd: i, start: j, end: j, theirs: theirs}
+			}
+			j++
+			cur.end = j
+		}
+	}
+	if cur != nil {
+		result = append(result, *cur)
+	}
+	return result
+}
+
+// Merge3 applies the changes from base to ours and from base to theirs to
+// base. Changes touching the same lines conflict unless they are identical;
+// conflicts are written between markers labeled oursLabel and theirsLabel.
+func Merge3(base, ours, theirs, oursLabel, theirsLabel string) (string, []Conflict) {
+	switch {
+	case ours == theirs || base == theirs:
+		return ours, nil
+	case base == ours:
+		return theirs, nil
+	}
+
+	b, o, t := SplitLines(base), SplitLines(ours), SplitLines(theirs)
+	all := append(changes(b, o, false), changes(b, t, true)...)
+	sort.SliceStable(all, func(i, j int) bool { return all[i].baseStart < all[j].baseStart })
+
+	var out []string
+	var conflicts []Conflict
+	pos := 0
+	for i := 0; i < len(all); {
+		// Group changes overlapping or touching each other
+		lo, hi := all[i].baseStart, all[i].baseEnd
+		j := i + 1
+		for j < len(all) && all[j].baseStart <= hi {
+			hi = max(hi, all[j].baseEnd)
+			j++
+		}
+		group := all[i:j]
+		i = j
+
+		out = append(out, b[pos:lo]...)
+		pos = hi
+
+		oursText, oursChanged := sideText(group, false, b, o, lo, hi)
+		theirsText, theirsChanged := sideText(group, true, b, t, lo, hi)
+		switch {
+		case !theirsChanged:
+			out = append(out, oursText...)
+		case !oursChanged || slicesEqual(oursText, theirsText):
+			out = append(out, theirsText...)
+		default:
+			conflicts = append(conflicts, Conflict{
+				Line:   len(out) + 1,
+				Base:   b[lo:hi],
+				Ours:   oursText,
+				Theirs: theirsText,
+			})
+			out = append(out, "<<<<<<< "+oursLabel)
+			out = append(out, oursText...)
+			out = append(out, "=======")
+			out = append(out, theirsText...)
+			out = append(out, ">>>>>>> "+theirsLabel)
+		}
+	}
+	out = append(out, b[pos:]...)
+
+	merged := strings.Join(out, "\n")
+	if len(out) > 0 && trailingNewline(base, ours, theirs) {
+		merged += "\n"
+	}
+	return merged, conflicts
+}
+
+// trailingNewline decides whether the merged text ends with a line break: as
+// in base, unless a side added or removed the final one
+func trailingNewline(base, ours, theirs string) bool {
+	b := strings.HasSuffix(base, "\n")
+	if o := strings.HasSuffix(ours, "\n"); o != b {
+		return o
+	}
+	return strings.HasSuffix(theirs, "\n")
+}
+
+// sideText returns what one side made of base[lo:hi], and whether it changed
+// anything there
+func sideText(group []change, theirs bool, base, side []string, lo, hi int) ([]string, bool) {
+	var text []string
+	changed := false
+	p := lo
+	for _, c := range group {
+		if c.theirs != theirs {
+			continue
+		}
+		changed = true
+		text = append(text, base[p:c.baseStart]...)
+		text = append(text, side[c.start:c.end]...)
+		p = c.baseEnd
+	}
+	if !changed {
+		return base[lo:hi], false
+	}
+	return append(text, base[p:hi]...), true
+}
+
+func slicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package diff
+
+import "testing"
+
+func TestMerge3(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+		want               string
+		conflicts          int
+	}{
+		{"unchanged", "a\nb\n", "a\nb\n", "a\nb\n", "a\nb\n", 0},
+		{"only ours", "a\nb\n", "a\nx\n", "a\nb\n", "a\nx\n", 0},
+		{"only theirs", "a\nb\n", "a\nb\n", "a\nx\n", "a\nx\n", 0},
+		{"both sides, apart", "a\nb\nc\nd\n", "x\nb\nc\nd\n", "a\nb\nc\ny\n", "x\nb\nc\ny\n", 0},
+		{"identical changes", "a\nb\nc\nd\n", "a\nx\nc\nd\n", "a\nx\nc\ny\n", "a\nx\nc\ny\n", 0},
+		{"insert at start and end", "a\nb\n", "0\na\nb\n", "a\nb\nc\n", "0\na\nb\nc\n", 0},
+		{"delete at start and end", "a\nb\nc\n", "b\nc\n", "a\nb\n", "b\n", 0},
+		{"same line changed", "a\nb\nc\n", "a\nx\nc\n", "a\ny\nc\n", "a\n<<<<<<< ours\nx\n=======\ny\n>>>>>>> theirs\nc\n", 1},
+		{"adjacent lines changed", "a\nb\nc\nd\n", "a\nx\nc\nd\n", "a\nb\ny\nd\n", "a\n<<<<<<< ours\nx\nc\n=======\nb\ny\n>>>>>>> theirs\nd\n", 1},
+		{"both insert at end", "a\n", "a\nx\n", "a\ny\n", "a\n<<<<<<< ours\nx\n=======\ny\n>>>>>>> theirs\n", 1},
+		{"delete against edit", "a\nb\nc\n", "a\nc\n", "a\nx\nc\n", "a\n<<<<<<< ours\n=======\nx\n>>>>>>> theirs\nc\n", 1},
+
+		// The final line break follows the side that changed it
+		{"base without newline", "a\nb", "x\nb", "a\nb\ny", "x\nb\ny", 0},
+		{"ours drops newline", "a\nb\nc\n", "x\nb\nc", "a\nb\ny\n", "x\nb\ny", 0},
+		{"theirs drops newline", "a\nb\nc\n", "x\nb\nc\n", "a\nb\nc", "x\nb\nc", 0},
+		{"theirs adds newline", "a\nb\nc", "x\nb\nc", "a\nb\nc\n", "x\nb\nc\n", 0},
+		{"neither has newline", "a\nb\nc", "x\nb\nc", "a\nb\ny", "x\nb\ny", 0},
+		{"everything deleted", "a\n", "", "a\n", "", 0},
+	}
+	for _, tt := range tests {
+		got, conflicts := Merge3(tt.base, tt.ours, tt.theirs, "ours", "theirs")
+		if got != tt.want {
+			t.Errorf("%s: Merge3 = %q, want %q", tt.name, got, tt.want)
+		}
+		if len(conflicts) != tt.conflicts {
+			t.Errorf("%s: %d conflicts, want %d", tt.name, len(conflicts), tt.conflicts)
+		}
+	}
+}
+
+func TestMerge3ConflictRegion(t *testing.T) {
+	merged, conflicts := Merge3("a\nb\nc\nd\n", "a\nb\nx\nd\n", "a\nb\ny\nz\nd\n", "ours", "theirs")
+	if len(conflicts) != 1 {
+		t.Fatalf("%d conflicts, want 1", len(conflicts))
+	}
+	c := conflicts[0]
+	if c.Line != 3 {
+		t.Errorf("conflict at line %d, want 3", c.Line)
+	}
+	if !slicesEqual(c.Base, []string{"c"}) || !slicesEqual(c.Ours, []string{"x"}) || !slicesEqual(c.Theirs, []string{"y", "z"}) {
+		t.Errorf("conflict = %+v", c)
+	}
+	if lines := SplitLines(merged); lines[c.Line-1] != "<<<<<<< ours" {
+		t.Errorf("line %d of merge is %q", c.Line, lines[c.Line-1])
+	}
+}
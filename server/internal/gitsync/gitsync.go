@@ -0,0 +1,214 @@
+// Package gitsync reads and writes Git repositories through the git command
+// line. Commits are written with git fast-import, so repositories can be bare
+// and nothing is ever checked out.
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for revisions that do not name a commit
+var ErrNotFound = errors.New("revision not found")
+
+// Repo is a Git repository on disk, bare or not
+type Repo struct {
+	Dir string
+}
+
+// Init opens the bare repository at dir, creating it when missing
+func Init(ctx context.Context, dir string) (*Repo, error) {
+	repo := &Repo{Dir: dir}
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err == nil {
+		return repo, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if _, err := repo.run(ctx, "init", "--bare", "--quiet"); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// Clone copies the repository at url into a new bare repository at dir
+func Clone(ctx context.Context, url, dir string) (*Repo, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	parent := &Repo{Dir: filepath.Dir(dir)}
+	if err := os.MkdirAll(parent.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	if _, err := parent.run(ctx, "clone", "--bare", "--quiet", "--", url, dir); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &Repo{Dir: dir}, nil
+}
+
+// run executes git in the repository and returns its standard output
+func (r *Repo) run(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = r.Dir
+	cmd.Env = gitEnv()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// gitEnv never lets git wait for credentials on a terminal
+func gitEnv() []string {
+	return append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "LC_ALL=C")
+}
+
+// exitCode returns the exit status of a failed git command, -1 for other errors
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// Resolve returns the commit a revision points to
+func (r *Repo) Resolve(ctx context.Context, rev string) (string, error) {
+	out, err := r.run(ctx, "rev-parse", "--verify", "--quiet", "--end-of-options", rev+"^{commit}")
+	if exitCode(err) == 1 {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, rev)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// DefaultBranch returns the branch HEAD points to
+func (r *Repo) DefaultBranch(ctx context.Context) (string, error) {
+	out, err := r.run(ctx, "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// IsAncestor reports whether commit ancestor is reachable from commit
+func (r *Repo) IsAncestor(ctx context.Context, ancestor, commit string) (bool, error) {
+	_, err := r.run(ctx, "merge-base", "--is-ancestor", ancestor, commit)
+	if err == nil {
+		return true, nil
+	}
+	if exitCode(err) == 1 {
+		return false, nil
+	}
+	return false, err
+}
+
+// SetBranch points a branch at commit, provided it still points at old;
+// an empty old creates the branch
+func (r *Repo) SetBranch(ctx context.Context, branch, commit, old string) error {
+	args := []string{"update-ref", "refs/heads/" + branch, commit}
+	if old != "" {
+		args = append(args, old)
+	}
+	_, err := r.run(ctx, args...)
+	return err
+}
+
+// Push sends a branch to a remote, which must fast-forward to it
+func (r *Repo) Push(ctx context.Context, remote, branch string) error {
+	ref := "refs/heads/" + branch
+	_, err := r.run(ctx, "push", "--quiet", remote, ref+":"+ref)
+	return err
+}
+
+// Fetch retrieves a branch of a remote and returns the commit it points to
+func (r *Repo) Fetch(ctx context.Context, remote, branch string) (string, error) {
+	tracking := "refs/remotes/sync/" + branch
+	if _, err := r.run(ctx, "fetch", "--quiet", remote, "+refs/heads/"+branch+":"+tracking); err != nil {
+		return "", err
+	}
+	return r.Resolve(ctx, tracking)
+}
+
+// CommitInfo describes a commit
+type CommitInfo struct {
+	Hash    string
+	Author  Signature
+	Message string
+}
+
+// Commit returns the commit a revision points to
+func (r *Repo) Commit(ctx context.Context, rev string) (*CommitInfo, error) {
+	out, err := r.run(ctx, "log", "-1", "--format=%H%x00%an%x00%ae%x00%at%x00%B", "--end-of-options", rev, "--")
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.SplitN(string(out), "\x00", 5)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, rev)
+	}
+	unix, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &CommitInfo{
+		Hash:    fields[0],
+		Author:  Signature{Name: fields[1], Email: fields[2], When: time.Unix(unix, 0)},
+		Message: strings.TrimSpace(fields[4]),
+	}, nil
+}
+
+// Entry is a file in the tree of a commit
+type Entry struct {
+	Path string
+	Blob string // Object id of the content
+	Size int64
+}
+
+// Tree lists the files of a commit. Symbolic links and submodules are left
+// out: neither has content to take into a project.
+func (r *Repo) Tree(ctx context.Context, rev string) ([]Entry, error) {
+	out, err := r.run(ctx, "ls-tree", "-r", "-z", "--long", "--full-tree", "--end-of-options", rev)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, record := range strings.Split(string(out), "\x00") {
+		// <mode> <type> <object> <size>\t<path>
+		meta, path, ok := strings.Cut(record, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 4 || fields[1] != "blob" || fields[0] == "120000" {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{Path: path, Blob: fields[2], Size: size})
+	}
+	return entries, nil
+}
+
+// ReadBlob returns the content of a blob
+func (r *Repo) ReadBlob(ctx context.Context, blob string) ([]byte, error) {
+	return r.run(ctx, "cat-file", "blob", blob)
+}
@@ -0,0 +1,221 @@
+package gitsync
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func requireGit(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+}
+
+// git runs a plumbing command in dir and returns its trimmed output
+func git(t *testing.T, dir, stdin string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(gitEnv(),
+		"GIT_AUTHOR_NAME=Test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=Test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func initRepo(t *testing.T, name string) *Repo {
+	t.Helper()
+	repo, err := Init(context.Background(), filepath.Join(t.TempDir(), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	git(t, repo.Dir, "", "symbolic-ref", "HEAD", "refs/heads/main")
+	return repo
+}
+
+// writeCommits appends one commit per tree to branch; trees map paths to contents
+func writeCommits(t *testing.T, repo *Repo, branch, parent string, trees ...map[string]string) []string {
+	t.Helper()
+	w, err := repo.NewWriter(context.Background(), branch, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tree := range trees {
+		var files []File
+		for p, content := range tree {
+			blob, err := w.Blob(strings.NewReader(content), int64(len(content)))
+			if err != nil {
+				w.Abort()
+				t.Fatal(err)
+			}
+			files = append(files, File{Path: p, Blob: blob})
+		}
+		err := w.Commit(Commit{
+			Author:  Signature{Name: "Ada", Email: "ada@example.com", When: time.Unix(1700000000+int64(i), 0)},
+			Message: "version " + string(rune('1'+i)),
+			Files:   files,
+		})
+		if err != nil {
+			w.Abort()
+			t.Fatal(err)
+		}
+	}
+	commits, err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commits
+}
+
+// treeOf returns path -> content of a commit
+func treeOf(t *testing.T, repo *Repo, rev string) map[string]string {
+	t.Helper()
+	ctx := context.Background()
+	entries, err := repo.Tree(ctx, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := map[string]string{}
+	for _, e := range entries {
+		content, err := repo.ReadBlob(ctx, e.Blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(content)) != e.Size {
+			t.Errorf("%s: size %d, read %d bytes", e.Path, e.Size, len(content))
+		}
+		tree[e.Path] = string(content)
+	}
+	return tree
+}
+
+func equalTrees(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for p, content := range a {
+		if other, ok := b[p]; !ok || other != content {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTreeSkipsSymlinksAndSubmodules(t *testing.T) {
+	requireGit(t)
+	ctx := context.Background()
+	repo := initRepo(t, "project.git")
+
+	file := git(t, repo.Dir, "main\n", "hash-object", "-w", "--stdin")
+	target := git(t, repo.Dir, "/etc/passwd", "hash-object", "-w", "--stdin")
+	nested := git(t, repo.Dir, "100644 blob "+file+"\tintro.tex\n", "mktree")
+	submodule := strings.Repeat("ab", 20) // Submodule commits live in another repository
+
+	tree := git(t, repo.Dir,
+		"100644 blob "+file+"\tmain.tex\n"+
+			"120000 blob "+target+"\tsecrets.tex\n"+
+			"160000 commit "+submodule+"\tvendor\n"+
+			"040000 tree "+nested+"\tsections\n",
+		"mktree", "--missing")
+	commit := git(t, repo.Dir, "", "commit-tree", tree, "-m", "mixed tree")
+
+	entries, err := repo.Tree(ctx, commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Path)
+	}
+	slices.Sort(got)
+	want := []string{"main.tex", "sections/intro.tex"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Tree paths = %v, want %v", got, want)
+	}
+
+	content, err := repo.ReadBlob(ctx, entries[0].Blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "main\n" {
+		t.Errorf("ReadBlob = %q", content)
+	}
+}
+
+func TestResolveMissingRevision(t *testing.T) {
+	requireGit(t)
+	repo := initRepo(t, "project.git")
+
+	if _, err := repo.Resolve(context.Background(), "main"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve on an empty repository = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCloneFetchPush(t *testing.T) {
+	requireGit(t)
+	ctx := context.Background()
+	origin := initRepo(t, "origin.git")
+	first := writeCommits(t, origin, "main", "", map[string]string{"main.tex": "one\n"})
+
+	clone, err := Clone(ctx, origin.Dir, filepath.Join(t.TempDir(), "clones", "project.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	branch, err := clone.DefaultBranch(ctx)
+	if err != nil || branch != "main" {
+		t.Fatalf("DefaultBranch = %q, %v", branch, err)
+	}
+	if head, err := clone.Resolve(ctx, "main"); err != nil || head != first[0] {
+		t.Fatalf("cloned main = %s, %v; want %s", head, err, first[0])
+	}
+
+	// Push fast-forwards the remote
+	second := writeCommits(t, clone, "main", first[0], map[string]string{"main.tex": "two\n"})
+	if err := clone.Push(ctx, origin.Dir, "main"); err != nil {
+		t.Fatal(err)
+	}
+	if head, _ := origin.Resolve(ctx, "main"); head != second[0] {
+		t.Errorf("origin main = %s after push, want %s", head, second[0])
+	}
+
+	// Fetch returns the remote tip without moving the local branch
+	third := writeCommits(t, origin, "main", second[0], map[string]string{"main.tex": "three\n"})
+	fetched, err := clone.Fetch(ctx, origin.Dir, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched != third[0] {
+		t.Errorf("Fetch = %s, want %s", fetched, third[0])
+	}
+	if head, _ := clone.Resolve(ctx, "main"); head != second[0] {
+		t.Errorf("Fetch moved main to %s", head)
+	}
+	if got := treeOf(t, clone, fetched); got["main.tex"] != "three\n" {
+		t.Errorf("fetched tree = %v", got)
+	}
+
+	// A push that would drop the remote's commit is refused
+	writeCommits(t, clone, "main", second[0], map[string]string{"main.tex": "diverged\n"})
+	if err := clone.Push(ctx, origin.Dir, "main"); err == nil {
+		t.Error("non fast-forward push succeeded")
+	}
+	if head, _ := origin.Resolve(ctx, "main"); head != third[0] {
+		t.Errorf("origin main = %s after refused push, want %s", head, third[0])
+	}
+
+	if _, err := clone.Fetch(ctx, origin.Dir, "missing"); err == nil {
+		t.Error("fetching a missing branch succeeded")
+	}
+}
@@ -0,0 +1,174 @@
+package gitsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature identifies the author of a commit
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// File is a path of a commit's tree and the mark of its content
+type File struct {
+	Path string
+	Blob string
+}
+
+// Commit holds a commit to write. Files is the whole tree, not what changed.
+type Commit struct {
+	Author  Signature
+	Message string
+	Files   []File
+}
+
+// Writer appends commits to a branch through git fast-import
+type Writer struct {
+	cmd    *exec.Cmd
+	pipe   io.WriteCloser
+	in     *bufio.Writer
+	stderr bytes.Buffer
+	marks  string
+
+	ref     string
+	parent  string
+	next    int
+	commits []string
+}
+
+// NewWriter starts appending to branch on top of parent, its current tip;
+// an empty parent starts a new branch. Writing fails if the branch moved.
+func (r *Repo) NewWriter(ctx context.Context, branch, parent string) (*Writer, error) {
+	marks, err := os.CreateTemp("", "gitsync-marks-*")
+	if err != nil {
+		return nil, err
+	}
+	marks.Close()
+
+	w := &Writer{marks: marks.Name(), ref: "refs/heads/" + branch, parent: parent}
+	w.cmd = exec.CommandContext(ctx, "git", "fast-import", "--quiet", "--date-format=raw", "--export-marks="+w.marks)
+	w.cmd.Dir = r.Dir
+	w.cmd.Env = gitEnv()
+	w.cmd.Stderr = &w.stderr
+
+	w.pipe, err = w.cmd.StdinPipe()
+	if err != nil {
+		os.Remove(w.marks)
+		return nil, err
+	}
+	if err := w.cmd.Start(); err != nil {
+		os.Remove(w.marks)
+		return nil, err
+	}
+	w.in = bufio.NewWriter(w.pipe)
+	return w, nil
+}
+
+func (w *Writer) mark() string {
+	w.next++
+	return ":" + strconv.Itoa(w.next)
+}
+
+// Blob writes size bytes of content and returns the mark to use in Files
+func (w *Writer) Blob(content io.Reader, size int64) (string, error) {
+	mark := w.mark()
+	fmt.Fprintf(w.in, "blob\nmark %s\ndata %d\n", mark, size)
+	if _, err := io.CopyN(w.in, content, size); err != nil {
+		return "", err
+	}
+	if _, err := w.in.WriteString("\n"); err != nil {
+		return "", err
+	}
+	return mark, nil
+}
+
+// Commit writes a commit on top of the previous one
+func (w *Writer) Commit(c Commit) error {
+	mark := w.mark()
+	w.commits = append(w.commits, mark)
+
+	author := fmt.Sprintf("%s <%s> %d +0000", clean(c.Author.Name), clean(c.Author.Email), c.Author.When.Unix())
+	fmt.Fprintf(w.in, "commit %s\nmark %s\nauthor %s\ncommitter %s\n", w.ref, mark, author, author)
+	fmt.Fprintf(w.in, "data %d\n%s\n", len(c.Message), c.Message)
+	if len(w.commits) == 1 && w.parent != "" {
+		fmt.Fprintf(w.in, "from %s\n", w.parent)
+	}
+	w.in.WriteString("deleteall\n")
+	for _, f := range c.Files {
+		fmt.Fprintf(w.in, "M 100644 %s %s\n", f.Blob, quote(f.Path))
+	}
+	_, err := w.in.WriteString("\n")
+	return err
+}
+
+// Close finishes the import and returns the ids of the commits written, in
+// order. The branch is only updated when everything was written.
+func (w *Writer) Close() ([]string, error) {
+	defer os.Remove(w.marks)
+
+	flushErr := w.in.Flush()
+	w.pipe.Close()
+	if err := w.cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("git fast-import: %w: %s", err, strings.TrimSpace(w.stderr.String()))
+	}
+	if flushErr != nil {
+		return nil, flushErr
+	}
+
+	data, err := os.ReadFile(w.marks)
+	if err != nil {
+		return nil, err
+	}
+	ids := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if mark, id, ok := strings.Cut(line, " "); ok {
+			ids[mark] = id
+		}
+	}
+
+	commits := make([]string, len(w.commits))
+	for i, mark := range w.commits {
+		commits[i] = ids[mark]
+	}
+	return commits, nil
+}
+
+// Abort stops the import without updating the branch
+func (w *Writer) Abort() {
+	w.cmd.Process.Kill()
+	w.pipe.Close()
+	w.cmd.Wait()
+	os.Remove(w.marks)
+}
+
+// clean drops what an identity cannot hold
+func clean(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '<', '>', '\n':
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// quote writes a path the way fast-import reads it, quoted when it holds a
+// newline, quote or backslash
+func quote(p string) string {
+	if !strings.ContainsAny(p, "\n\"\\") {
+		return p
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(p) + `"`
+}
@@ -0,0 +1,142 @@
+package gitsync
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriterCommits(t *testing.T) {
+	requireGit(t)
+	ctx := context.Background()
+	repo := initRepo(t, "project.git")
+
+	first := map[string]string{"main.tex": "\\documentclass{article}\n"}
+	second := map[string]string{
+		"main.tex":           "\\documentclass{article}\n\\input{sections/intro}\n",
+		"sections/intro.tex": "Hello\n",
+		"odd \"name\".tex":   "quoted\n",
+	}
+	commits := writeCommits(t, repo, "main", "", first, second)
+	if len(commits) != 2 {
+		t.Fatalf("got %d commits, want 2", len(commits))
+	}
+
+	head, err := repo.Resolve(ctx, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != commits[1] {
+		t.Errorf("main at %s, want %s", head, commits[1])
+	}
+
+	if got := treeOf(t, repo, commits[0]); !equalTrees(got, first) {
+		t.Errorf("first tree = %v, want %v", got, first)
+	}
+	if got := treeOf(t, repo, commits[1]); !equalTrees(got, second) {
+		t.Errorf("second tree = %v, want %v", got, second)
+	}
+
+	// The first commit starts the branch, the second follows it
+	if parents := git(t, repo.Dir, "", "rev-list", "--parents", "-n1", commits[0]); parents != commits[0] {
+		t.Errorf("first commit has parents: %s", parents)
+	}
+	if parent := git(t, repo.Dir, "", "rev-parse", commits[1]+"^"); parent != commits[0] {
+		t.Errorf("second commit parent = %s, want %s", parent, commits[0])
+	}
+
+	info, err := repo.Commit(ctx, commits[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Author.Name != "Ada" || info.Author.Email != "ada@example.com" || info.Message != "version 2" {
+		t.Errorf("commit info = %+v", info)
+	}
+	if !info.Author.When.Equal(time.Unix(1700000001, 0)) {
+		t.Errorf("author date = %s", info.Author.When)
+	}
+
+	// A later writer continues from the tip; files left out are deleted
+	third := map[string]string{"main.tex": "\\documentclass{article}\n"}
+	more := writeCommits(t, repo, "main", commits[1], third)
+	if parent := git(t, repo.Dir, "", "rev-parse", more[0]+"^"); parent != commits[1] {
+		t.Errorf("appended commit parent = %s, want %s", parent, commits[1])
+	}
+	if got := treeOf(t, repo, "main"); !equalTrees(got, third) {
+		t.Errorf("tree after deleting files = %v, want %v", got, third)
+	}
+
+	ok, err := repo.IsAncestor(ctx, commits[0], more[0])
+	if err != nil || !ok {
+		t.Errorf("IsAncestor(first, last) = %v, %v", ok, err)
+	}
+	ok, err = repo.IsAncestor(ctx, more[0], commits[0])
+	if err != nil || ok {
+		t.Errorf("IsAncestor(last, first) = %v, %v", ok, err)
+	}
+}
+
+func TestWriterRefusesNonFastForward(t *testing.T) {
+	requireGit(t)
+	ctx := context.Background()
+	repo := initRepo(t, "project.git")
+
+	commits := writeCommits(t, repo, "main", "",
+		map[string]string{"main.tex": "one\n"},
+		map[string]string{"main.tex": "two\n"},
+	)
+
+	// Writing on top of a commit the branch has moved past would drop the second one
+	w, err := repo.NewWriter(ctx, "main", commits[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := w.Blob(strings.NewReader("three\n"), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(Commit{Author: Signature{Name: "Ada", Email: "ada@example.com", When: time.Now()}, Message: "stale", Files: []File{{Path: "main.tex", Blob: blob}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Close(); err == nil {
+		t.Fatal("non fast-forward write succeeded")
+	}
+
+	head, err := repo.Resolve(ctx, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != commits[1] {
+		t.Errorf("main moved to %s, want %s", head, commits[1])
+	}
+
+	// SetBranch only moves a branch still at the expected commit
+	if err := repo.SetBranch(ctx, "main", commits[0], commits[0]); err == nil {
+		t.Error("SetBranch with a stale old commit succeeded")
+	}
+	if err := repo.SetBranch(ctx, "main", commits[0], commits[1]); err != nil {
+		t.Errorf("SetBranch: %v", err)
+	}
+}
+
+func TestWriterAbort(t *testing.T) {
+	requireGit(t)
+	ctx := context.Background()
+	repo := initRepo(t, "project.git")
+
+	w, err := repo.NewWriter(ctx, "main", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := w.Blob(strings.NewReader("x\n"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Commit(Commit{Author: Signature{Name: "Ada", Email: "ada@example.com", When: time.Now()}, Message: "aborted", Files: []File{{Path: "main.tex", Blob: blob}}})
+	w.Abort()
+
+	if _, err := repo.Resolve(ctx, "main"); err == nil {
+		t.Error("aborted writer created the branch")
+	}
+}
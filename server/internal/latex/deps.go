@@ -0,0 +1,256 @@
+// Package latex extracts file dependencies from LaTeX sources.
+package latex
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Kinds of files a reference can point to
+const (
+	KindGraphics     = "graphics"
+	KindTex          = "tex"
+	KindBibliography = "bibliography"
+	KindListing      = "listing"
+	KindPDF          = "pdf"
+)
+
+// Reference is a single file referenced by a LaTeX source
+type Reference struct {
+	Command string // e.g. includegraphics
+	Kind    string
+	Path    string // As written, relative to the directory it is resolved from
+	Dir     string // Directory the reference is resolved from ("" = project root)
+	Source  string // File containing the reference
+	Line    int
+}
+
+// Extensions tried, in order, when a reference has no (known) extension
+var kindExtensions = map[string][]string{
+	KindGraphics:     {".pdf", ".png", ".jpg", ".jpeg", ".eps", ".svg"},
+	KindTex:          {".tex"},
+	KindBibliography: {".bib"},
+	KindPDF:          {".pdf"},
+}
+
+// Candidates returns the project paths the reference may resolve to, most
+// specific first. searchDirs are extra directories (e.g. from \graphicspath).
+func (r Reference) Candidates(searchDirs []string) []string {
+	dirs := []string{r.Dir}
+	if r.Kind == KindGraphics {
+		for _, d := range searchDirs {
+			dirs = append(dirs, path.Join(r.Dir, d))
+		}
+	}
+
+	names := []string{r.Path}
+	ext := strings.ToLower(path.Ext(r.Path))
+	known := false
+	for _, e := range kindExtensions[r.Kind] {
+		if ext == e {
+			known = true
+		}
+	}
+	if !known {
+		for _, e := range kindExtensions[r.Kind] {
+			names = append(names, r.Path+e)
+		}
+	}
+
+	var result []string
+	seen := map[string]bool{}
+	for _, d := range dirs {
+		for _, n := range names {
+			p := Clean(path.Join(d, n))
+			if p == "" || seen[p] {
+				continue
+			}
+			seen[p] = true
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// Clean normalizes a project-relative path; paths escaping the project root yield "".
+func Clean(p string) string {
+	p = path.Clean("/" + strings.ReplaceAll(p, "\\", "/"))
+	p = strings.TrimPrefix(p, "/")
+	if p == "" || p == "." {
+		return ""
+	}
+	return p
+}
+
+// ScanResult holds everything found in a set of sources
+type ScanResult struct {
+	References []Reference
+	// GraphicsPath lists the \graphicspath directories, relative to the project root
+	GraphicsPath []string
+}
+
+var (
+	commandRe      = regexp.MustCompile(`\\(includegraphics|includesvg|input|include|subfile|subimport|import|inputminted|lstinputlisting|includepdf|bibliography|addbibresource|addglobalbib|graphicspath)\*?\s*(?:\[[^\]]*\]\s*)*\{`)
+	graphicsPathRe = regexp.MustCompile(`\{([^{}]*)\}`)
+)
+
+var commandKinds = map[string]string{
+	"includegraphics": KindGraphics,
+	"includesvg":      KindGraphics,
+	"input":           KindTex,
+	"include":         KindTex,
+	"subfile":         KindTex,
+	"import":          KindTex,
+	"subimport":       KindTex,
+	"lstinputlisting": KindListing,
+	"inputminted":     KindListing,
+	"includepdf":      KindPDF,
+	"bibliography":    KindBibliography,
+	"addbibresource":  KindBibliography,
+	"addglobalbib":    KindBibliography,
+}
+
+// Scan extracts references from one source file. name is the file's path
+// relative to the project root.
+func Scan(name, content string) ScanResult {
+	var result ScanResult
+	source := StripComments(content)
+	sourceDir := path.Dir(Clean(name))
+	if sourceDir == "." {
+		sourceDir = ""
+	}
+
+	for _, loc := range commandRe.FindAllStringSubmatchIndex(source, -1) {
+		command := source[loc[2]:loc[3]]
+		args, ok := readArgs(source, loc[1]-1, 2)
+		if !ok || len(args) == 0 {
+			continue
+		}
+		line := strings.Count(source[:loc[0]], "\n") + 1
+
+		ref := Reference{Command: command, Kind: commandKinds[command], Source: name, Line: line}
+
+		switch command {
+		case "graphicspath":
+			for _, m := range graphicsPathRe.FindAllStringSubmatch("{"+args[0]+"}", -1) {
+				if dir := strings.TrimSpace(m[1]); dir != "" {
+					result.GraphicsPath = append(result.GraphicsPath, Clean(dir))
+				}
+			}
+			continue
+
+		case "import", "subimport":
+			// \import{dir}{file}: absolute from the root, \subimport relative to the source
+			if len(args) < 2 {
+				continue
+			}
+			ref.Dir = Clean(args[0])
+			if command == "subimport" {
+				ref.Dir = Clean(path.Join(sourceDir, args[0]))
+			}
+			ref.Path = strings.TrimSpace(args[1])
+			result.References = append(result.References, ref)
+			continue
+
+		case "inputminted":
+			// \inputminted{language}{file}
+			if len(args) < 2 {
+				continue
+			}
+			ref.Path = strings.TrimSpace(args[1])
+			result.References = append(result.References, ref)
+			continue
+
+		case "subfile":
+			// Subfiles are resolved relative to the including file
+			ref.Dir = sourceDir
+		}
+
+		if ref.Kind == KindBibliography && command == "bibliography" {
+			// \bibliography{refs,more}
+			for _, p := range strings.Split(args[0], ",") {
+				if p = strings.TrimSpace(p); p != "" {
+					r := ref
+					r.Path = p
+					result.References = append(result.References, r)
+				}
+			}
+			continue
+		}
+
+		ref.Path = strings.TrimSpace(args[0])
+		if ref.Path != "" {
+			result.References = append(result.References, ref)
+		}
+	}
+
+	return result
+}
+
+// readArgs reads up to n brace-delimited arguments starting at the opening brace at pos
+func readArgs(s string, pos, n int) ([]string, bool) {
+	var args []string
+	for len(args) < n && pos < len(s) && s[pos] == '{' {
+		depth := 0
+		end := -1
+		for i := pos; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++ // Skip escaped characters
+			case '{':
+				depth++
+			case '}':
+				depth--
+			}
+			if depth == 0 {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return args, len(args) > 0
+		}
+		args = append(args, s[pos+1:end])
+
+		pos = end + 1
+		for pos < len(s) && (s[pos] == ' ' || s[pos] == '\t') {
+			pos++
+		}
+	}
+	return args, true
+}
+
+// StripComments removes % comments, keeping escaped \% and line numbering intact.
+func StripComments(content string) string {
+	var b strings.Builder
+	b.Grow(len(content))
+
+	for _, line := range strings.SplitAfter(content, "\n") {
+		cut := len(line)
+		for i := 0; i < len(line); i++ {
+			if line[i] == '\\' {
+				i++
+				continue
+			}
+			if line[i] == '%' {
+				cut = i
+				break
+			}
+		}
+		b.WriteString(line[:cut])
+		if cut < len(line) && strings.HasSuffix(line, "\n") {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// IsSource reports whether a file may contain references worth scanning
+func IsSource(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".tex", ".cls", ".sty", ".ltx":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package latex
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// refs renders references as "kind dir|path:line"
+func refs(result ScanResult) []string {
+	var out []string
+	for _, r := range result.References {
+		out = append(out, fmt.Sprintf("%s %s|%s:%d", r.Kind, r.Dir, r.Path, r.Line))
+	}
+	return out
+}
+
+func TestStripComments(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"a % b\nc\n", "a \nc\n"},
+		{"% whole line\nc", "\nc"},
+		{`100\% sure % really`, `100\% sure `},
+		{`a \\% b`, `a \\`},
+		{"a % no newline", "a "},
+		{"no comment\n", "no comment\n"},
+	}
+	for _, tt := range tests {
+		if got := StripComments(tt.content); got != tt.want {
+			t.Errorf("StripComments(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []string
+	}{
+		{"graphics", "main.tex", `\includegraphics[width=\linewidth]{fig/plot}`, []string{"graphics |fig/plot:1"}},
+		{"commented out", "main.tex", "% \\input{old}\n\\input{new}", []string{"tex |new:2"}},
+		{"escaped percent", "main.tex", `\input{a}\% \input{b}`, []string{"tex |a:1", "tex |b:1"}},
+		{"starred with spaces", "main.tex", `\include* {chap}`, []string{"tex |chap:1"}},
+		{"import from the root", "chapters/main.tex", `\import{parts/}{intro}`, []string{"tex parts|intro:1"}},
+		{"subimport from the source", "chapters/main.tex", `\subimport{parts/}{intro}`, []string{"tex chapters/parts|intro:1"}},
+		{"import without a file", "main.tex", `\import{parts/}`, nil},
+		{"subfile from the source", "chapters/main.tex", `\subfile{intro}`, []string{"tex chapters|intro:1"}},
+		{"bibliography list", "main.tex", `\bibliography{refs, more ,}`, []string{"bibliography |refs:1", "bibliography |more:1"}},
+		{"bibresource keeps commas", "main.tex", `\addbibresource{a,b.bib}`, []string{"bibliography |a,b.bib:1"}},
+		{"minted file", "main.tex", `\inputminted{go}{code/main.go}`, []string{"listing |code/main.go:1"}},
+		{"nested braces", "main.tex", `\includegraphics{\detokenize{a_b}}`, []string{`graphics |\detokenize{a_b}:1`}},
+		{"empty argument", "main.tex", `\input{ }`, nil},
+	}
+	for _, tt := range tests {
+		if got := refs(Scan(tt.file, tt.content)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Scan = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScanGraphicsPath(t *testing.T) {
+	result := Scan("main.tex", "\\graphicspath{{figs/}{./img/}{ }{../out/}}\n% \\graphicspath{{old/}}")
+	if want := []string{"figs", "img", "out"}; !slices.Equal(result.GraphicsPath, want) {
+		t.Errorf("GraphicsPath = %q, want %q", result.GraphicsPath, want)
+	}
+	if len(result.References) != 0 {
+		t.Errorf("graphicspath made references %q", refs(result))
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	tests := []struct {
+		name       string
+		ref        Reference
+		searchDirs []string
+		want       []string
+	}{
+		{"known extension", Reference{Kind: KindGraphics, Path: "plot.PNG"}, nil, []string{"plot.PNG"}},
+		{"graphics extensions", Reference{Kind: KindGraphics, Path: "plot"}, nil,
+			[]string{"plot", "plot.pdf", "plot.png", "plot.jpg", "plot.jpeg", "plot.eps", "plot.svg"}},
+		{"unknown extension", Reference{Kind: KindTex, Path: "chap.v2"}, nil, []string{"chap.v2", "chap.v2.tex"}},
+		{"tex", Reference{Kind: KindTex, Path: "intro.tex", Dir: "chapters"}, nil, []string{"chapters/intro.tex"}},
+		{"bibliography", Reference{Kind: KindBibliography, Path: "refs"}, nil, []string{"refs", "refs.bib"}},
+		{"listing has no extensions", Reference{Kind: KindListing, Path: "main.go"}, nil, []string{"main.go"}},
+		{"graphics search dirs", Reference{Kind: KindGraphics, Path: "a.pdf", Dir: "doc"}, []string{"figs", "../img"},
+			[]string{"doc/a.pdf", "doc/figs/a.pdf", "img/a.pdf"}},
+		{"search dirs only for graphics", Reference{Kind: KindTex, Path: "a.tex"}, []string{"figs"}, []string{"a.tex"}},
+		{"escaping the root", Reference{Kind: KindTex, Path: "../../etc/passwd.tex", Dir: "a"}, nil, []string{"etc/passwd.tex"}},
+	}
+	for _, tt := range tests {
+		if got := tt.ref.Candidates(tt.searchDirs); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Candidates = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		p, want string
+	}{
+		{"a/b.tex", "a/b.tex"},
+		{"./a//b/../c", "a/c"},
+		{`fig\plot`, "fig/plot"},
+		{"/abs/path", "abs/path"},
+		{"../up", "up"},
+		{".", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Clean(tt.p); got != tt.want {
+			t.Errorf("Clean(%q) = %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestIsSource(t *testing.T) {
+	for name, want := range map[string]bool{
+		"main.tex": true, "a/STYLE.STY": true, "x.cls": true, "doc.ltx": true,
+		"refs.bib": false, "fig.pdf": false, "Makefile": false,
+	} {
+		if got := IsSource(name); got != want {
+			t.Errorf("IsSource(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package latex
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var documentClassRe = regexp.MustCompile(`\\documentclass\s*(?:\[[^\]]*\]\s*)?\{`)
+
+// IsRootDocument reports whether a source is a complete document, i.e. it
+// has a \documentclass and a \begin{document} outside of comments.
+func IsRootDocument(content string) bool {
+	source := StripComments(content)
+	return documentClassRe.MatchString(source) && strings.Contains(source, `\begin{document}`)
+}
+
+// PickRoot chooses the main document among root documents: the one closest to
+// the project root, preferring main.tex, then by name.
+func PickRoot(candidates []string) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+	sorted := append([]string(nil), candidates...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if da, db := strings.Count(a, "/"), strings.Count(b, "/"); da != db {
+			return da < db
+		}
+		if ma, mb := path.Base(a) == "main.tex", path.Base(b) == "main.tex"; ma != mb {
+			return ma
+		}
+		return a < b
+	})
+	return sorted[0]
+}
+
+// IsPlaceholder reports whether a file is the empty main.tex a new project
+// starts with, which an imported project may replace
+func IsPlaceholder(name, content string) bool {
+	return name == "main.tex" && strings.TrimSpace(content) == ""
+}
@@ -0,0 +1,99 @@
+// Package paths normalizes project-relative file paths and maps them to
+// object keys, so folders survive uploads, compiles and downloads.
+package paths
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ErrUnsafePath is returned for paths that are absolute or escape the project root
+var ErrUnsafePath = errors.New("unsafe path")
+
+// Clean normalizes a project-relative path to forward slashes without
+// leading "./" or "/", rejecting empty paths and paths leaving the root.
+func Clean(p string) (string, error) {
+	p = strings.ReplaceAll(strings.TrimSpace(p), "\\", "/")
+	if p == "" {
+		return "", fmt.Errorf("%w: empty path", ErrUnsafePath)
+	}
+	if strings.HasPrefix(p, "/") || (len(p) > 1 && p[1] == ':') {
+		return "", fmt.Errorf("%w: %s is absolute", ErrUnsafePath, p)
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: %s leaves the project", ErrUnsafePath, p)
+		}
+	}
+
+	p = path.Clean(p)
+	if p == "." {
+		return "", fmt.Errorf("%w: empty path", ErrUnsafePath)
+	}
+	return p, nil
+}
+
+// AssetObject returns the object key of an asset of a project or template.
+// owner is "project" or "template".
+func AssetObject(owner, ownerID, rel string) string {
+	return fmt.Sprintf("%s/%s/assets/%s", owner, ownerID, rel)
+}
+
+// AssetRelPath recovers the project-relative path from an asset object key.
+// Keys written before folders were preserved yield their base name.
+func AssetRelPath(objectKey string) string {
+	parts := strings.SplitN(objectKey, "/", 4)
+	if len(parts) == 4 && parts[2] == "assets" {
+		return parts[3]
+	}
+	return path.Base(objectKey)
+}
+
+// Join joins a folder and a name; an empty folder is the project root.
+func Join(folder, name string) string {
+	if folder == "" {
+		return name
+	}
+	return folder + "/" + name
+}
+
+// Dir returns the folder containing p, "" for the project root.
+func Dir(p string) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// Within reports whether p lies inside folder (at any depth).
+func Within(p, folder string) bool {
+	return folder == "" || strings.HasPrefix(p, folder+"/")
+}
+
+// Rebase moves p from folder oldFolder to newFolder, keeping its relative part.
+func Rebase(p, oldFolder, newFolder string) string {
+	return Join(newFolder, strings.TrimPrefix(p, oldFolder+"/"))
+}
+
+// CommonRoot returns the top-level folder containing all paths, or "" when
+// they do not share one. Archives created by zipping a folder have one.
+func CommonRoot(ps []string) string {
+	root := ""
+	for i, p := range ps {
+		top, _, nested := strings.Cut(p, "/")
+		if !nested || (i > 0 && top != root) {
+			return ""
+		}
+		root = top
+	}
+	return root
+}
+
+// VersionObject returns the object key of asset content kept for versions of
+// a project. Keys are content-addressed, so unchanged assets are stored once.
+func VersionObject(projectID, hash string) string {
+	return fmt.Sprintf("project/%s/versions/%s", projectID, hash)
+}
@@ -0,0 +1,161 @@
+// Package safezip reads untrusted ZIP archives with limits on their size,
+// entry count, nesting and compression ratio, so a small upload cannot
+// expand into something that fills the disk or memory.
+package safezip
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrTooLarge         = errors.New("archive too large")
+	ErrTooManyEntries   = errors.New("too many entries")
+	ErrTooDeep          = errors.New("path nested too deeply")
+	ErrCompressionRatio = errors.New("suspicious compression ratio")
+	ErrSymlink          = errors.New("symbolic links are not allowed")
+	ErrUnsafePath       = errors.New("unsafe path")
+)
+
+// Entries smaller than this are not checked for their compression ratio;
+// small text files legitimately compress very well.
+const ratioMinSize = 1 << 20
+
+// Limits bounds what an archive may expand to. Zero fields mean no limit.
+type Limits struct {
+	MaxTotalSize int64   // Uncompressed bytes of all entries
+	MaxEntries   int     // Files and directories
+	MaxDepth     int     // Path components of an entry
+	MaxRatio     float64 // Uncompressed / compressed size of an entry
+}
+
+// DefaultLimits allows 512MB uncompressed, 10000 entries, 16 levels and a 100:1 ratio
+func DefaultLimits() Limits {
+	return Limits{
+		MaxTotalSize: 512 << 20,
+		MaxEntries:   10000,
+		MaxDepth:     16,
+		MaxRatio:     100,
+	}
+}
+
+// Reader is a ZIP reader that enforces Limits. Declared sizes are checked
+// up front, and the bytes actually extracted are counted while reading.
+type Reader struct {
+	*zip.Reader
+	limits    Limits
+	extracted int64
+}
+
+// NewReader opens an archive, rejecting it as a whole when it declares more
+// entries or more uncompressed data than allowed.
+func NewReader(r io.ReaderAt, size int64, limits Limits) (*Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if limits.MaxEntries > 0 && len(zr.File) > limits.MaxEntries {
+		return nil, fmt.Errorf("%w: %d entries, the limit is %d", ErrTooManyEntries, len(zr.File), limits.MaxEntries)
+	}
+
+	var total uint64
+	for _, f := range zr.File {
+		total += f.UncompressedSize64
+		if limits.MaxTotalSize > 0 && total > uint64(limits.MaxTotalSize) {
+			return nil, fmt.Errorf("%w: more than %d bytes uncompressed", ErrTooLarge, limits.MaxTotalSize)
+		}
+	}
+
+	return &Reader{Reader: zr, limits: limits}, nil
+}
+
+// Path returns the sanitized, slash-separated path of an entry relative to
+// the archive root. Symlinks, absolute paths and paths leaving the root are
+// rejected.
+func (r *Reader) Path(f *zip.File) (string, error) {
+	if f.Mode()&fs.ModeSymlink != 0 {
+		return "", fmt.Errorf("%w: %s", ErrSymlink, f.Name)
+	}
+
+	name := strings.ReplaceAll(f.Name, "\\", "/")
+	if name == "" || path.IsAbs(name) || (len(name) > 1 && name[1] == ':') {
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, f.Name)
+	}
+
+	// Resolve against a root and make sure the result stays inside it
+	root := string(filepath.Separator) + "archive"
+	rel, err := filepath.Rel(root, filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, f.Name)
+	}
+	rel = filepath.ToSlash(rel)
+
+	if depth := strings.Count(rel, "/") + 1; r.limits.MaxDepth > 0 && depth > r.limits.MaxDepth {
+		return "", fmt.Errorf("%w: %s has %d levels, the limit is %d", ErrTooDeep, rel, depth, r.limits.MaxDepth)
+	}
+	return rel, nil
+}
+
+// Open opens an entry for reading. Reading fails once the entry produces more
+// than it declared, exceeds the compression ratio or the archive's total limit.
+func (r *Reader) Open(f *zip.File) (io.ReadCloser, error) {
+	if err := r.checkRatio(f, f.UncompressedSize64); err != nil {
+		return nil, err
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &limitedEntry{ReadCloser: rc, reader: r, file: f}, nil
+}
+
+func (r *Reader) checkRatio(f *zip.File, size uint64) error {
+	if r.limits.MaxRatio <= 0 || size < ratioMinSize {
+		return nil
+	}
+	compressed := max(f.CompressedSize64, 1)
+	if ratio := float64(size) / float64(compressed); ratio > r.limits.MaxRatio {
+		return fmt.Errorf("%w: %s expands %.0f:1, the limit is %.0f:1", ErrCompressionRatio, f.Name, ratio, r.limits.MaxRatio)
+	}
+	return nil
+}
+
+// limitedEntry counts the bytes of one entry against the limits
+type limitedEntry struct {
+	io.ReadCloser
+	reader *Reader
+	file   *zip.File
+	read   uint64
+}
+
+func (e *limitedEntry) Read(p []byte) (int, error) {
+	n, err := e.ReadCloser.Read(p)
+	e.read += uint64(n)
+	e.reader.extracted += int64(n)
+
+	if e.read > e.file.UncompressedSize64 {
+		return n, fmt.Errorf("%w: %s is larger than declared", ErrTooLarge, e.file.Name)
+	}
+	if ratioErr := e.reader.checkRatio(e.file, e.read); ratioErr != nil {
+		return n, ratioErr
+	}
+	if limit := e.reader.limits.MaxTotalSize; limit > 0 && e.reader.extracted > limit {
+		return n, fmt.Errorf("%w: more than %d bytes uncompressed", ErrTooLarge, limit)
+	}
+	return n, err
+}
+
+// Rejected reports whether err is a limit or path violation rather than a
+// malformed archive or an I/O failure
+func Rejected(err error) bool {
+	return errors.Is(err, ErrTooLarge) || errors.Is(err, ErrTooManyEntries) ||
+		errors.Is(err, ErrTooDeep) || errors.Is(err, ErrCompressionRatio) ||
+		errors.Is(err, ErrSymlink) || errors.Is(err, ErrUnsafePath)
+}
@@ -5,17 +5,21 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
+	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 const (
@@ -26,6 +30,8 @@ const (
 	logTTL = 24 * time.Hour
 	// TTL for status in Redis (1 hour)
 	statusTTL = 1 * time.Hour
+	// Bucket where job sources are uploaded
+	sourcesBucket = "compile-sources"
 )
 
 // CompileStatus represents the minimal status info stored in Redis
@@ -38,13 +44,30 @@ type CompileStatus struct {
 	PdfURL     string    `bson:"pdfUrl,omitempty"`
 }
 
-// CompileJob represents the Mongo document for a compile job (minimal, for historical tracking only)
+// CompileJob represents the Mongo document for a compile job. Unlike the Redis
+// status it does not expire, so it is used to look up the latest build of a project.
 type CompileJob struct {
-	JobID     string    `bson:"jobId" json:"jobId"`
-	UserID    string    `bson:"userId,omitempty" json:"userId,omitempty"`
-	DocID     string    `bson:"docId,omitempty" json:"docId,omitempty"`
-	Status    string    `bson:"status" json:"status"`
-	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	JobID          string     `bson:"jobId" json:"jobId"`
+	Kind           string     `bson:"kind,omitempty" json:"kind,omitempty"` // Empty for plain compiles, KindLatexDiff for change-tracked builds
+	UserID         string     `bson:"userId,omitempty" json:"userId,omitempty"`
+	DocID          string     `bson:"docId,omitempty" json:"docId,omitempty"`         // As given by the client; only used to find assets
+	ProjectID      string     `bson:"projectId,omitempty" json:"projectId,omitempty"` // Set by authenticated callers only
+	Source         string     `bson:"source,omitempty" json:"source,omitempty"`       // SourceProject for builds of a project's working tree
+	SourceHash     string     `bson:"sourceHash,omitempty" json:"sourceHash,omitempty"`
+	Assets         []AssetRef `bson:"assets,omitempty" json:"assets,omitempty"`
+	AssetsRecorded bool       `bson:"assetsRecorded,omitempty" json:"assetsRecorded,omitempty"` // Assets is the complete asset set
+	MainFile       string     `bson:"mainFile,omitempty" json:"mainFile,omitempty"`
+	VersionID      string     `bson:"versionId,omitempty" json:"versionId,omitempty"` // Set when compiling a version snapshot
+	SourceBucket   string     `bson:"sourceBucket,omitempty" json:"sourceBucket,omitempty"`
+	SourceObject   string     `bson:"sourceObject,omitempty" json:"sourceObject,omitempty"`
+	Status         string     `bson:"status" json:"status"`
+	Error          string     `bson:"error,omitempty" json:"error,omitempty"`
+	PdfObject      string     `bson:"pdfObject,omitempty" json:"pdfObject,omitempty"`
+	Diagnostics    []string   `bson:"diagnostics,omitempty" json:"diagnostics,omitempty"` // References the worker could not resolve
+	Pinned         bool       `bson:"pinned,omitempty" json:"pinned,omitempty"`           // Pinned builds are never garbage collected
+	CreatedAt      time.Time  `bson:"createdAt" json:"createdAt"`
+	FinishedAt     time.Time  `bson:"finishedAt,omitempty" json:"finishedAt,omitempty"`
+	PrunedAt       time.Time  `bson:"prunedAt,omitempty" json:"prunedAt,omitempty"` // Set once the janitor removed the PDF
 }
 
 // Handler exposes HTTP handlers for compile jobs.
@@ -83,73 +106,29 @@ func (h *Handler) EnqueueCompile(c *gin.Context) {
 		return
 	}
 
-	userID := h.extractUserID(c)
-	jobID := uuid.New().String()
-	now := time.Now().UTC()
-
-	// Store initial status in Redis
-	status := CompileStatus{
-		JobID:     jobID,
-		Status:    "queued",
-		CreatedAt: now,
-	}
-	if err := h.setStatus(c.Request.Context(), jobID, status); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store status", "details": err.Error()})
-		return
+	// The sources are the client's: the worker reads them, but they are not
+	// recorded on the job, so nothing ever deletes them on its behalf
+	job := CompileJob{
+		JobID:    uuid.New().String(),
+		UserID:   h.extractUserID(c),
+		DocID:    req.DocID,
+		MainFile: req.MainFile,
 	}
-
-	// Optional: Store minimal record in MongoDB for historical tracking
-	if h.JobColl != nil {
-		job := CompileJob{
-			JobID:     jobID,
-			UserID:    userID,
-			DocID:     req.DocID,
-			Status:    "queued",
-			CreatedAt: now,
-		}
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		_, _ = h.JobColl.InsertOne(ctx, job) // Best effort, don't fail if Mongo is down
-	}
-
-	// Prepare payload for Redis queue
-	payload := map[string]interface{}{
-		"jobId":        jobID,
-		"userId":       userID,
-		"sourceBucket": req.SourceBucket,
-		"sourceObject": req.SourceObject,
-		"mainFile":     req.MainFile,
-		"docId":        req.DocID,
-	}
-
-	b, err := json.Marshal(payload)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to marshal job payload", "details": err.Error()})
-		return
-	}
-
-	// Push to Redis queue
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := h.Redis.RPush(ctx, h.QueueName, string(b)).Err(); err != nil {
+	if err := h.enqueue(c.Request.Context(), &job, req.SourceBucket, req.SourceObject); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enqueue job", "details": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusAccepted, gin.H{
-		"jobId":  jobID,
+		"jobId":  job.JobID,
 		"status": "queued",
 	})
 }
 
 // EnqueueCompileInline handles inline compile with files sent as JSON
 func (h *Handler) EnqueueCompileInline(c *gin.Context) {
-	type InlineFile struct {
-		Name    string `json:"name" binding:"required"`
-		Content string `json:"content" binding:"required"`
-	}
 	type InlineRequest struct {
-		Files    []InlineFile `json:"files" binding:"required"`
+		Files    []SourceFile `json:"files" binding:"required"`
 		MainFile string       `json:"mainFile" binding:"required"`
 		DocID    string       `json:"docId,omitempty"`
 	}
@@ -163,102 +142,198 @@ func (h *Handler) EnqueueCompileInline(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "no files provided"})
 		return
 	}
+	for _, f := range req.Files {
+		if f.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file name required for each entry"})
+			return
+		}
+	}
 
-	userID := h.extractUserID(c)
-	jobID := uuid.New().String()
-	now := time.Now().UTC()
+	job, err := h.EnqueueFiles(c.Request.Context(), FilesRequest{
+		UserID:   h.extractUserID(c),
+		DocID:    req.DocID,
+		MainFile: req.MainFile,
+		Files:    req.Files,
+		Prefix:   "inline",
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enqueue job", "details": err.Error()})
+		return
+	}
+
+	log.Printf("[compile_enqueue] inline job enqueued: jobId=%s files=%d", job.JobID, len(req.Files))
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"jobId":        job.JobID,
+		"status":       "queued",
+		"sourceBucket": job.SourceBucket,
+		"sourceObject": job.SourceObject,
+	})
+}
+
+// SourceFile is a single text file of a compile workspace
+type SourceFile struct {
+	Name    string `json:"name" binding:"required"`
+	Content string `json:"content" binding:"required"`
+}
+
+// FilesRequest describes a compile of in-memory files, e.g. a version snapshot.
+type FilesRequest struct {
+	UserID    string
+	DocID     string
+	MainFile  string
+	VersionID string // Optional: links the resulting PDF to this version
+	// ProjectID and Source are trusted: only set them after checking access
+	ProjectID string
+	Source    string
+	// SourceHash identifies the tree being compiled; passed back to OnCompiled
+	SourceHash string
+	Files      []SourceFile
+	Prefix     string // Object prefix in the sources bucket, e.g. "inline"
+
+	// Assets, when AssetsRecorded, replaces the project's current assets,
+	// e.g. with those recorded by a version
+	Assets         []AssetRef
+	AssetsRecorded bool
+
+	// BaseFiles turns the job into a latexdiff build marking changes from BaseFiles to Files
+	BaseFiles []SourceFile
+}
+
+// EnqueueFiles zips the given files into the sources bucket and enqueues a compile job.
+func (h *Handler) EnqueueFiles(ctx context.Context, req FilesRequest) (*CompileJob, error) {
+	if len(req.Files) == 0 {
+		return nil, errors.New("no files provided")
+	}
+
+	files := req.Files
+	kind := ""
+	if len(req.BaseFiles) > 0 {
+		// The base tree travels in a hidden folder next to the head files
+		kind = KindLatexDiff
+		for _, f := range req.BaseFiles {
+			files = append(files, SourceFile{Name: latexdiffBaseDir + "/" + f.Name, Content: f.Content})
+		}
+	}
+
+	archive, err := buildSourceZip(files)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := req.Prefix
+	if prefix == "" {
+		prefix = "inline"
+	}
 
-	// Create in-memory ZIP
+	job := CompileJob{
+		JobID:          uuid.New().String(),
+		Kind:           kind,
+		UserID:         req.UserID,
+		DocID:          req.DocID,
+		ProjectID:      req.ProjectID,
+		Source:         req.Source,
+		SourceHash:     req.SourceHash,
+		Assets:         req.Assets,
+		AssetsRecorded: req.AssetsRecorded,
+		MainFile:       req.MainFile,
+		VersionID:      req.VersionID,
+		SourceBucket:   sourcesBucket,
+	}
+	job.SourceObject = fmt.Sprintf("%s/%s.zip", prefix, job.JobID)
+
+	// Upload to MinIO
+	_, err = h.Minio.PutObject(ctx, job.SourceBucket, job.SourceObject, bytes.NewReader(archive), int64(len(archive)), minio.PutObjectOptions{
+		ContentType: "application/zip",
+		UserTags:    map[string]string{expireTag: "true"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload source zip: %w", err)
+	}
+
+	if err := h.enqueue(ctx, &job, job.SourceBucket, job.SourceObject); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// buildSourceZip creates an in-memory ZIP of the given files
+func buildSourceZip(files []SourceFile) ([]byte, error) {
 	var buf bytes.Buffer
 	zw := zip.NewWriter(&buf)
-	for _, f := range req.Files {
+	for _, f := range files {
 		if f.Name == "" {
 			zw.Close()
-			c.JSON(http.StatusBadRequest, gin.H{"error": "file name required for each entry"})
-			return
+			return nil, errors.New("file name required for each entry")
 		}
 		w, err := zw.Create(f.Name)
 		if err != nil {
 			zw.Close()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create zip entry", "details": err.Error()})
-			return
+			return nil, fmt.Errorf("failed to create zip entry: %w", err)
 		}
-		if _, err := io.Copy(w, bytes.NewReader([]byte(f.Content))); err != nil {
+		if _, err := io.Copy(w, strings.NewReader(f.Content)); err != nil {
 			zw.Close()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to write zip entry", "details": err.Error()})
-			return
+			return nil, fmt.Errorf("failed to write zip entry: %w", err)
 		}
 	}
 	if err := zw.Close(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to finalize zip", "details": err.Error()})
-		return
+		return nil, fmt.Errorf("failed to finalize zip: %w", err)
 	}
+	return buf.Bytes(), nil
+}
 
-	// Upload to MinIO
-	sourcesBucket := "compile-sources"
-	ctx := c.Request.Context()
-	objectName := fmt.Sprintf("inline/%s.zip", jobID)
-	_, err := h.Minio.PutObject(ctx, sourcesBucket, objectName, bytes.NewReader(buf.Bytes()), int64(buf.Len()), minio.PutObjectOptions{ContentType: "application/zip"})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload source zip", "details": err.Error()})
-		return
-	}
+// enqueue stores the initial status of a job and pushes it to the Redis queue.
+// The worker compiles the given sources, which are only recorded on the job
+// when the server wrote them itself.
+func (h *Handler) enqueue(ctx context.Context, job *CompileJob, sourceBucket, sourceObject string) error {
+	job.Status = "queued"
+	job.CreatedAt = time.Now().UTC()
 
 	// Store initial status in Redis
 	status := CompileStatus{
-		JobID:     jobID,
-		Status:    "queued",
-		CreatedAt: now,
+		JobID:     job.JobID,
+		Status:    job.Status,
+		CreatedAt: job.CreatedAt,
 	}
-	if err := h.setStatus(ctx, jobID, status); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store status", "details": err.Error()})
-		return
+	if err := h.setStatus(ctx, job.JobID, status); err != nil {
+		return fmt.Errorf("failed to store status: %w", err)
 	}
 
-	// Optional: Store in MongoDB
+	// Store a record in MongoDB for lookups that outlive the Redis status
 	if h.JobColl != nil {
-		job := CompileJob{
-			JobID:     jobID,
-			UserID:    userID,
-			DocID:     req.DocID,
-			Status:    "queued",
-			CreatedAt: now,
-		}
 		insertCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		_, _ = h.JobColl.InsertOne(insertCtx, job)
+		_, _ = h.JobColl.InsertOne(insertCtx, job) // Best effort, don't fail if Mongo is down
 	}
 
-	// Enqueue job
-	payload := map[string]interface{}{
-		"jobId":        jobID,
-		"userId":       userID,
-		"sourceBucket": sourcesBucket,
-		"sourceObject": objectName,
-		"mainFile":     req.MainFile,
-		"docId":        req.DocID,
+	// Prepare payload for Redis queue
+	payload := JobPayload{
+		Kind:           job.Kind,
+		JobID:          job.JobID,
+		UserID:         job.UserID,
+		DocID:          job.DocID,
+		ProjectID:      job.ProjectID,
+		Source:         job.Source,
+		SourceHash:     job.SourceHash,
+		Assets:         job.Assets,
+		AssetsRecorded: job.AssetsRecorded,
+		VersionID:      job.VersionID,
+		SourceBucket:   sourceBucket,
+		SourceObject:   sourceObject,
+		MainFile:       job.MainFile,
 	}
 	b, err := json.Marshal(payload)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to marshal job payload", "details": err.Error()})
-		return
+		return fmt.Errorf("failed to marshal job payload: %w", err)
 	}
 
+	// Push to Redis queue
 	queueCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := h.Redis.RPush(queueCtx, h.QueueName, string(b)).Err(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enqueue job", "details": err.Error()})
-		return
+		return fmt.Errorf("failed to enqueue job: %w", err)
 	}
-
-	log.Printf("[compile_enqueue] inline job enqueued: jobId=%s files=%d", jobID, len(req.Files))
-
-	c.JSON(http.StatusAccepted, gin.H{
-		"jobId":        jobID,
-		"status":       "queued",
-		"sourceBucket": sourcesBucket,
-		"sourceObject": objectName,
-	})
+	return nil
 }
 
 // GetJobStatus returns the job status and logs from Redis
@@ -333,7 +408,7 @@ func (h *Handler) DownloadPDF(c *gin.Context) {
 	ctx := c.Request.Context()
 
 	// Get PDF from MinIO
-	objectName := fmt.Sprintf("%s.pdf", jobID)
+	objectName := pdfObjectName(jobID)
 	obj, err := h.Minio.GetObject(ctx, h.PdfsBucket, objectName, minio.GetObjectOptions{})
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "pdf not found"})
@@ -361,6 +436,11 @@ func (h *Handler) DownloadPDF(c *gin.Context) {
 
 // Helper methods
 
+// pdfObjectName returns the object key of the PDF produced by a job
+func pdfObjectName(jobID string) string {
+	return fmt.Sprintf("%s.pdf", jobID)
+}
+
 func (h *Handler) extractUserID(c *gin.Context) string {
 	if v, exists := c.Get("userId"); exists {
 		if s, ok := v.(string); ok {
@@ -420,10 +500,92 @@ func (h *Handler) UpdateStatus(ctx context.Context, jobID, status, errorMsg, pdf
 		current.FinishedAt = time.Now().UTC()
 	}
 
-	return h.setStatus(ctx, jobID, *current)
+	if err := h.setStatus(ctx, jobID, *current); err != nil {
+		return err
+	}
+
+	// Mirror the final state into Mongo so builds outlive the Redis status TTL
+	if h.JobColl != nil {
+		set := bson.M{"status": status}
+		if errorMsg != "" {
+			set["error"] = errorMsg
+		}
+		if status == "success" {
+			set["pdfObject"] = pdfObjectName(jobID)
+		}
+		if !current.FinishedAt.IsZero() {
+			set["finishedAt"] = current.FinishedAt
+		}
+		_, _ = h.JobColl.UpdateOne(ctx, bson.M{"jobId": jobID}, bson.M{"$set": set}) // Best effort
+	}
+
+	return nil
+}
+
+// StoreDiagnostics records the unresolved references of a job (called by worker)
+func (h *Handler) StoreDiagnostics(ctx context.Context, jobID string, diagnostics []string) error {
+	if h.JobColl == nil || len(diagnostics) == 0 {
+		return nil
+	}
+	_, err := h.JobColl.UpdateOne(ctx, bson.M{"jobId": jobID}, bson.M{"$set": bson.M{"diagnostics": diagnostics}})
+	return err
+}
+
+// diagnosticsHeader formats diagnostics so they lead the compile log
+func diagnosticsHeader(diagnostics []string) string {
+	if len(diagnostics) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, d := range diagnostics {
+		b.WriteString("warning: unresolved reference: " + d + "\n")
+	}
+	b.WriteString("\n")
+	return b.String()
 }
 
 // StoreLogs stores compilation logs in Redis (called by worker)
 func (h *Handler) StoreLogs(ctx context.Context, jobID, logs string) error {
 	return h.setLogs(ctx, jobID, logs)
 }
+
+// LinkVersionPDF records the job as the PDF of a version snapshot and pins it,
+// so the janitor never removes a tagged submission's PDF. The PDF it replaces
+// is unpinned unless another version links it.
+func (h *Handler) LinkVersionPDF(ctx context.Context, versionID, jobID string) error {
+	if h.JobColl == nil {
+		return errors.New("job collection not configured")
+	}
+
+	versionOID, err := bson.ObjectIDFromHex(versionID)
+	if err != nil {
+		return err
+	}
+
+	versions := h.JobColl.Database().Collection("versions")
+	var previous struct {
+		PdfJobID string `bson:"pdfJobId"`
+	}
+	err = versions.FindOneAndUpdate(ctx,
+		bson.M{"_id": versionOID},
+		bson.M{"$set": bson.M{"pdfJobId": jobID}},
+		options.FindOneAndUpdate().SetProjection(bson.M{"pdfJobId": 1}),
+	).Decode(&previous)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	if _, err := h.SetPinned(ctx, jobID, true); err != nil {
+		return err
+	}
+
+	// The PDF the version had before is unpinned once no version links it
+	if previous.PdfJobID != "" && previous.PdfJobID != jobID {
+		count, err := versions.CountDocuments(ctx, bson.M{"pdfJobId": previous.PdfJobID})
+		if err == nil && count == 0 {
+			_, err = h.SetPinned(ctx, previous.PdfJobID, false)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"github.com/minio/minio-go/v7/pkg/tags"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Janitor metrics, exposed through expvar (/debug/vars)
+var (
+	janitorReclaimedBytes = expvar.NewInt("janitor_reclaimed_bytes")
+	janitorDeletedObjects = expvar.NewInt("janitor_deleted_objects")
+	janitorRuns           = expvar.NewInt("janitor_runs")
+)
+
+// lifecycleRuleID identifies the expiration rule the janitor installs on buckets
+const lifecycleRuleID = "collaboratex-retention"
+
+// expireTag marks objects the lifecycle rule may expire. Sources are tagged on
+// upload and lose the tag when their build is pinned; objects written by
+// others are never matched.
+const expireTag = "collaboratex-expire"
+
+// JanitorConfig controls retention of compile outputs.
+type JanitorConfig struct {
+	// Interval between two garbage collection passes
+	Interval time.Duration
+
+	// KeepLast is the number of successful builds kept per project (0 = unlimited)
+	KeepLast int
+	// MaxAge removes builds older than this (0 = keep forever)
+	MaxAge time.Duration
+
+	SourcesBucket string
+	LogsBucket    string
+	PdfsBucket    string
+}
+
+// janitorStats summarises a single garbage collection pass
+type janitorStats struct {
+	Objects int64
+	Bytes   int64
+}
+
+func (s *janitorStats) add(size int64, removed bool) {
+	if removed {
+		s.Objects++
+		s.Bytes += size
+	}
+}
+
+// RunJanitor periodically deletes compile sources, logs and PDFs that fall outside
+// the retention policy. Pinned builds and the newest build of every project main
+// file are always kept, so the latest-PDF URL never dangles.
+func RunJanitor(ctx context.Context, cfg JanitorConfig, minioClient *minio.Client, jobColl *mongo.Collection) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Hour
+	}
+
+	applyLifecycleRules(ctx, cfg, minioClient)
+
+	log.Printf("janitor started (interval=%s, keepLast=%d, maxAge=%s)", cfg.Interval, cfg.KeepLast, cfg.MaxAge)
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		stats, err := collectGarbage(ctx, cfg, minioClient, jobColl)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("janitor pass failed: %v", err)
+		}
+		janitorRuns.Add(1)
+		janitorReclaimedBytes.Add(stats.Bytes)
+		janitorDeletedObjects.Add(stats.Objects)
+		log.Printf("janitor pass finished: deleted %d objects, reclaimed %d bytes", stats.Objects, stats.Bytes)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// applyLifecycleRules lets MinIO expire sources and logs on its own. PDFs are
+// left to the janitor because pinning and keep-last cannot be expressed as rules.
+// Only objects tagged with expireTag are matched, and rules the operator set on
+// the buckets are kept.
+func applyLifecycleRules(ctx context.Context, cfg JanitorConfig, minioClient *minio.Client) {
+	if cfg.MaxAge <= 0 {
+		return
+	}
+	days := int(cfg.MaxAge / (24 * time.Hour))
+	if days < 1 {
+		days = 1
+	}
+
+	rule := lifecycle.Rule{
+		ID:     lifecycleRuleID,
+		Status: "Enabled",
+		RuleFilter: lifecycle.Filter{
+			Tag: lifecycle.Tag{Key: expireTag, Value: "true"},
+		},
+		Expiration: lifecycle.Expiration{
+			Days: lifecycle.ExpirationDays(days),
+		},
+	}
+
+	for _, bucket := range []string{cfg.SourcesBucket, cfg.LogsBucket} {
+		if bucket == "" {
+			continue
+		}
+		if err := SetLifecycleRule(ctx, minioClient, bucket, rule); err != nil {
+			log.Printf("janitor: could not set lifecycle rule on %s (falling back to sweeping): %v", bucket, err)
+		}
+	}
+}
+
+// SetLifecycleRule installs a lifecycle rule on a bucket. A rule with the same
+// ID is replaced; rules set by operators or other components are kept.
+func SetLifecycleRule(ctx context.Context, minioClient *minio.Client, bucket string, rule lifecycle.Rule) error {
+	config, err := minioClient.GetBucketLifecycle(ctx, bucket)
+	if minio.ToErrorResponse(err).Code == "NoSuchLifecycleConfiguration" {
+		config, err = lifecycle.NewConfiguration(), nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading lifecycle rules: %w", err)
+	}
+	return minioClient.SetBucketLifecycle(ctx, bucket, mergeLifecycleRule(config, rule))
+}
+
+// mergeLifecycleRule replaces the rule with the same ID in config, or adds it
+func mergeLifecycleRule(config *lifecycle.Configuration, rule lifecycle.Rule) *lifecycle.Configuration {
+	rules := make([]lifecycle.Rule, 0, len(config.Rules)+1)
+	for _, r := range config.Rules {
+		if r.ID != rule.ID {
+			rules = append(rules, r)
+		}
+	}
+	config.Rules = append(rules, rule)
+	return config
+}
+
+// tagForExpiry adds or removes expireTag on an object, keeping its other tags
+func tagForExpiry(ctx context.Context, minioClient *minio.Client, bucket, object string, expire bool) error {
+	current, err := minioClient.GetObjectTagging(ctx, bucket, object, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		return err
+	}
+	tagMap := current.ToMap()
+	if expire {
+		tagMap[expireTag] = "true"
+	} else {
+		delete(tagMap, expireTag)
+	}
+	if len(tagMap) == 0 {
+		return minioClient.RemoveObjectTagging(ctx, bucket, object, minio.RemoveObjectTaggingOptions{})
+	}
+	updated, err := tags.NewTags(tagMap, true)
+	if err != nil {
+		return err
+	}
+	return minioClient.PutObjectTagging(ctx, bucket, object, updated, minio.PutObjectTaggingOptions{})
+}
+
+// collectGarbage runs one pass over the compile jobs and the buckets
+func collectGarbage(ctx context.Context, cfg JanitorConfig, minioClient *minio.Client, jobColl *mongo.Collection) (janitorStats, error) {
+	var stats janitorStats
+	now := time.Now().UTC()
+
+	// Newest first, grouped by project, so the rank of a build is its position in the group
+	cursor, err := jobColl.Find(ctx,
+		bson.M{"prunedAt": bson.M{"$exists": false}},
+		options.Find().SetSort(bson.D{{Key: "docId", Value: 1}, {Key: "createdAt", Value: -1}}),
+	)
+	if err != nil {
+		return stats, err
+	}
+	defer cursor.Close(ctx)
+
+	livePdfs := map[string]struct{}{}
+	liveSources := map[string]struct{}{}
+	pinnedJobs := map[string]struct{}{}
+	rank := map[string]int{}
+	newest := map[string]struct{}{}
+
+	for cursor.Next(ctx) {
+		var job CompileJob
+		if err := cursor.Decode(&job); err != nil {
+			continue
+		}
+
+		finished := job.Status == "success" || job.Status == "failed"
+		age := now.Sub(job.CreatedAt)
+		if !job.FinishedAt.IsZero() {
+			age = now.Sub(job.FinishedAt)
+		}
+
+		if job.Pinned {
+			pinnedJobs[job.JobID] = struct{}{}
+		}
+
+		// Sources are only needed while a job runs; keep them until its status
+		// expires, or for good once the build is pinned
+		if ownsSource(cfg.SourcesBucket, job) {
+			if finished && age > statusTTL && !job.Pinned {
+				stats.add(removeObject(ctx, minioClient, job.SourceBucket, job.SourceObject))
+				_, _ = jobColl.UpdateOne(ctx,
+					bson.M{"jobId": job.JobID},
+					bson.M{"$unset": bson.M{"sourceBucket": "", "sourceObject": ""}},
+				)
+			} else {
+				liveSources[job.SourceObject] = struct{}{}
+			}
+		}
+
+		// Builds recorded before pdfObject existed stored their PDF under the
+		// same name; without this the orphan sweep would take them
+		pdfObject := job.PdfObject
+		if pdfObject == "" && job.Status == "success" {
+			pdfObject = pdfObjectName(job.JobID)
+		}
+		if pdfObject == "" {
+			continue
+		}
+
+		position := rank[job.DocID]
+		rank[job.DocID] = position + 1
+
+		// The newest build of each main file backs the project's latest-PDF URL;
+		// like LatestPDF, only working tree builds qualify
+		seen := true
+		if job.Source == SourceProject && job.VersionID == "" && job.Kind != KindLatexDiff {
+			newestKey := job.ProjectID + "\x00" + job.MainFile
+			_, seen = newest[newestKey]
+			newest[newestKey] = struct{}{}
+		}
+
+		if !seen || !shouldPrune(cfg, job, position, age) {
+			livePdfs[pdfObject] = struct{}{}
+			continue
+		}
+
+		stats.add(removeObject(ctx, minioClient, cfg.PdfsBucket, pdfObject))
+		_, _ = jobColl.UpdateOne(ctx,
+			bson.M{"jobId": job.JobID},
+			bson.M{"$set": bson.M{"prunedAt": now}},
+		)
+	}
+	if err := cursor.Err(); err != nil {
+		return stats, err
+	}
+
+	// Orphans: objects without a live job record (Redis expired, Mongo was down, ...)
+	orphanAge := cfg.MaxAge
+	if orphanAge <= 0 {
+		orphanAge = logTTL
+	}
+
+	for obj := range minioClient.ListObjectsIter(ctx, cfg.PdfsBucket, minio.ListObjectsOptions{}) {
+		if obj.Err != nil {
+			return stats, obj.Err
+		}
+		// Only top-level <jobId>.pdf objects belong to compile jobs
+		if strings.Contains(obj.Key, "/") || !strings.HasSuffix(obj.Key, ".pdf") {
+			continue
+		}
+		if _, ok := livePdfs[obj.Key]; ok || now.Sub(obj.LastModified) < orphanAge {
+			continue
+		}
+		stats.add(removeObject(ctx, minioClient, cfg.PdfsBucket, obj.Key))
+	}
+
+	// Visual diffs are cheap to recompute, so they only live as long as orphans do
+	for obj := range minioClient.ListObjectsIter(ctx, cfg.PdfsBucket, minio.ListObjectsOptions{Prefix: "diffs/", Recursive: true}) {
+		if obj.Err != nil {
+			return stats, obj.Err
+		}
+		if now.Sub(obj.LastModified) < orphanAge {
+			continue
+		}
+		stats.add(removeObject(ctx, minioClient, cfg.PdfsBucket, obj.Key))
+	}
+
+	for _, bucket := range []string{cfg.SourcesBucket, cfg.LogsBucket} {
+		if bucket == "" || cfg.MaxAge <= 0 {
+			continue
+		}
+		for obj := range minioClient.ListObjectsIter(ctx, bucket, minio.ListObjectsOptions{Recursive: true}) {
+			if obj.Err != nil {
+				return stats, obj.Err
+			}
+			if _, ok := liveSources[obj.Key]; ok || now.Sub(obj.LastModified) < cfg.MaxAge {
+				continue
+			}
+			// Objects are named after their job, e.g. <prefix>/<jobId>.zip
+			if _, ok := pinnedJobs[strings.TrimSuffix(path.Base(obj.Key), path.Ext(obj.Key))]; ok {
+				continue
+			}
+			stats.add(removeObject(ctx, minioClient, bucket, obj.Key))
+		}
+	}
+
+	return stats, nil
+}
+
+// ownsSource reports whether the sources recorded on a job were written by the
+// server, as EnqueueFiles does: <prefix>/<jobId>.zip in its own bucket. Older
+// records may carry whatever a client sent, which must never be deleted.
+func ownsSource(bucket string, job CompileJob) bool {
+	return job.SourceObject != "" && bucket != "" && job.SourceBucket == bucket &&
+		path.Base(job.SourceObject) == job.JobID+".zip"
+}
+
+// shouldPrune decides whether the PDF of a build falls outside the retention policy.
+// position is the build's rank among the project's builds, newest first.
+func shouldPrune(cfg JanitorConfig, job CompileJob, position int, age time.Duration) bool {
+	if job.Pinned {
+		return false
+	}
+	if cfg.KeepLast > 0 && position >= cfg.KeepLast {
+		return true
+	}
+	if cfg.MaxAge > 0 && age > cfg.MaxAge {
+		return true
+	}
+	return false
+}
+
+// removeObject deletes an object and returns the number of bytes reclaimed
+func removeObject(ctx context.Context, minioClient *minio.Client, bucket, object string) (int64, bool) {
+	if bucket == "" || object == "" {
+		return 0, false
+	}
+	stat, err := minioClient.StatObject(ctx, bucket, object, minio.StatObjectOptions{})
+	if err != nil {
+		return 0, false
+	}
+	if err := minioClient.RemoveObject(ctx, bucket, object, minio.RemoveObjectOptions{}); err != nil {
+		log.Printf("janitor: failed to remove %s/%s: %v", bucket, object, err)
+		return 0, false
+	}
+	return stat.Size, true
+}
+
+// SetPinned pins or unpins a build so the janitor keeps (or may remove) its PDF
+func (h *Handler) SetPinned(ctx context.Context, jobID string, pinned bool) (*CompileJob, error) {
+	if h.JobColl == nil {
+		return nil, errors.New("job collection not configured")
+	}
+
+	var job CompileJob
+	err := h.JobColl.FindOneAndUpdate(ctx,
+		bson.M{"jobId": jobID},
+		bson.M{"$set": bson.M{"pinned": pinned}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("compile job %s not found", jobID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Best effort: the lifecycle rule must not expire a pinned build's sources
+	if ownsSource(sourcesBucket, job) && h.Minio != nil {
+		if err := tagForExpiry(ctx, h.Minio, job.SourceBucket, job.SourceObject, !pinned); err != nil {
+			log.Printf("janitor: could not retag sources of job %s: %v", jobID, err)
+		}
+	}
+	return &job, nil
+}
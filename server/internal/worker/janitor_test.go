@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+func TestOwnsSource(t *testing.T) {
+	tests := []struct {
+		name string
+		job  CompileJob
+		want bool
+	}{
+		{"written by EnqueueFiles", CompileJob{JobID: "j1", SourceBucket: sourcesBucket, SourceObject: "project/p1/j1.zip"}, true},
+		{"no sources", CompileJob{JobID: "j1"}, false},
+		{"another bucket", CompileJob{JobID: "j1", SourceBucket: "assets", SourceObject: "project/p1/assets/fig.png"}, false},
+		{"another job's key", CompileJob{JobID: "j1", SourceBucket: sourcesBucket, SourceObject: "inline/j2.zip"}, false},
+		{"client chosen key", CompileJob{JobID: "j1", SourceBucket: sourcesBucket, SourceObject: "uploads/paper.zip"}, false},
+	}
+	for _, tt := range tests {
+		if got := ownsSource(sourcesBucket, tt.job); got != tt.want {
+			t.Errorf("%s: ownsSource = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if ownsSource("", CompileJob{JobID: "j1", SourceBucket: sourcesBucket, SourceObject: "inline/j1.zip"}) {
+		t.Error("ownsSource without a configured bucket")
+	}
+}
+
+func TestMergeLifecycleRule(t *testing.T) {
+	config := lifecycle.NewConfiguration()
+	config.Rules = []lifecycle.Rule{
+		{ID: "operator-rule", Status: "Enabled"},
+		{ID: lifecycleRuleID, Status: "Disabled"},
+	}
+
+	merged := mergeLifecycleRule(config, lifecycle.Rule{ID: lifecycleRuleID, Status: "Enabled"})
+	if len(merged.Rules) != 2 {
+		t.Fatalf("%d rules, want 2: %+v", len(merged.Rules), merged.Rules)
+	}
+	if merged.Rules[0].ID != "operator-rule" {
+		t.Errorf("operator rule lost: %+v", merged.Rules)
+	}
+	if merged.Rules[1].ID != lifecycleRuleID || merged.Rules[1].Status != "Enabled" {
+		t.Errorf("rule not replaced: %+v", merged.Rules[1])
+	}
+
+	merged = mergeLifecycleRule(lifecycle.NewConfiguration(), lifecycle.Rule{ID: lifecycleRuleID})
+	if len(merged.Rules) != 1 {
+		t.Errorf("%d rules on an empty configuration, want 1", len(merged.Rules))
+	}
+}
@@ -0,0 +1,186 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gollaboratex/server/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// ErrNoBuild is returned when a project has no successful compile yet
+var ErrNoBuild = errors.New("no successful build found")
+
+// LatestPDF returns the most recent successful compile of a project's main file.
+// mainFile may be given with or without the .tex extension. Only builds of the
+// working tree enqueued by the server count, never builds of old versions or
+// compiles posted to the public endpoints.
+func (h *Handler) LatestPDF(ctx context.Context, projectID, mainFile string) (*CompileJob, error) {
+	if h.JobColl == nil {
+		return nil, ErrNoBuild
+	}
+
+	filter := bson.M{
+		"projectId": projectID,
+		"source":    SourceProject,
+		"versionId": bson.M{"$exists": false},
+		"status":    "success",
+		"pdfObject": bson.M{"$exists": true},
+		"prunedAt":  bson.M{"$exists": false},
+		"kind":      bson.M{"$ne": KindLatexDiff}, // Change-tracked builds are not the project's PDF
+	}
+	if mainFile != "" {
+		base := strings.TrimSuffix(mainFile, filepath.Ext(mainFile))
+		filter["mainFile"] = bson.M{"$in": []string{mainFile, base, base + ".tex"}}
+	}
+
+	opts := options.FindOne().SetSort(bson.D{{Key: "finishedAt", Value: -1}})
+
+	var job CompileJob
+	err := h.JobColl.FindOne(ctx, filter, opts).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNoBuild
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetJob returns the Mongo record of a compile job
+func (h *Handler) GetJob(ctx context.Context, jobID string) (*CompileJob, error) {
+	if h.JobColl == nil {
+		return nil, errors.New("job collection not configured")
+	}
+
+	var job CompileJob
+	err := h.JobColl.FindOne(ctx, bson.M{"jobId": jobID}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("compile job %s not found", jobID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// DownloadLatestPDF serves the latest successful PDF of a project's root file
+// under a stable URL, with ETag/Last-Modified so clients can revalidate cheaply.
+// Only builds of the compileProject mutation count: the public /api/compile and
+// /api/compile-inline endpoints take their docId from the client, so their
+// builds are never served here.
+// GET /api/projects/:id/pdf/latest
+func (h *Handler) DownloadLatestPDF(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	projectID, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid project id"})
+		return
+	}
+
+	hasAccess, err := h.checkProjectAccess(ctx, projectID)
+	if err != nil || !hasAccess {
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+		return
+	}
+
+	rootFile, err := h.projectRootFile(ctx, projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project root file not found"})
+		return
+	}
+
+	job, err := h.LatestPDF(ctx, projectID.Hex(), rootFile)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no compiled pdf available"})
+		return
+	}
+
+	obj, err := h.Minio.GetObject(ctx, h.PdfsBucket, job.PdfObject, minio.GetObjectOptions{})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "pdf not found"})
+		return
+	}
+	defer obj.Close()
+
+	stat, err := obj.Stat()
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "pdf not found"})
+		return
+	}
+
+	lastModified := job.FinishedAt
+	if lastModified.IsZero() {
+		lastModified = stat.LastModified
+	}
+
+	// Each build gets its own job id, so it doubles as a strong validator
+	c.Header("ETag", fmt.Sprintf(`"%s"`, job.JobID))
+	c.Header("Cache-Control", "private, no-cache")
+	c.Header("X-Compile-Job-Id", job.JobID)
+	c.Header("Content-Type", "application/pdf")
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, strings.TrimSuffix(filepath.Base(rootFile), filepath.Ext(rootFile))))
+
+	// ServeContent handles If-None-Match, If-Modified-Since and Range requests
+	http.ServeContent(c.Writer, c.Request, "", lastModified, obj)
+}
+
+// projectRootFile returns the name of the project's root file
+func (h *Handler) projectRootFile(ctx context.Context, projectID bson.ObjectID) (string, error) {
+	if h.JobColl == nil {
+		return "", errors.New("job collection not configured")
+	}
+	db := h.JobColl.Database()
+
+	var project struct {
+		RootFileID bson.ObjectID `bson:"rootFileId"`
+	}
+	if err := db.Collection("projects").FindOne(ctx, bson.M{"_id": projectID}).Decode(&project); err != nil {
+		return "", err
+	}
+
+	var file struct {
+		Name string `bson:"name"`
+	}
+	if err := db.Collection("files").FindOne(ctx, bson.M{"_id": project.RootFileID}).Decode(&file); err != nil {
+		return "", err
+	}
+	return file.Name, nil
+}
+
+func (h *Handler) checkProjectAccess(ctx context.Context, projectID bson.ObjectID) (bool, error) {
+	user, err := middleware.GetUserFromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	if h.JobColl == nil {
+		return false, errors.New("job collection not configured")
+	}
+
+	var project struct {
+		OwnerID         bson.ObjectID   `bson:"ownerId"`
+		CollaboratorIDs []bson.ObjectID `bson:"collaboratorIds"`
+	}
+
+	err = h.JobColl.Database().Collection("projects").FindOne(ctx, bson.M{"_id": projectID}).Decode(&project)
+	if err != nil {
+		return false, err
+	}
+
+	if project.OwnerID == user.ID {
+		return true, nil
+	}
+
+	return slices.Contains(project.CollaboratorIDs, user.ID), nil
+}
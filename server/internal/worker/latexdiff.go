@@ -0,0 +1,52 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// latexdiffBaseDir holds the base version inside the workspace of a latexdiff build
+const latexdiffBaseDir = ".latexdiff-base"
+
+// runLatexDiff runs latexdiff in the sandbox between the base and head copies of
+// the main file and returns the generated file, relative to the workspace.
+// --flatten inlines \input/\include so changes in included files are marked too.
+func runLatexDiff(ctx context.Context, dockerCli *client.Client, cfg Config, workspace, mainFile string) (string, string, error) {
+	mainFile = filepath.ToSlash(mainFile)
+	baseFile := path.Join(latexdiffBaseDir, mainFile)
+	if _, err := os.Stat(filepath.Join(workspace, filepath.FromSlash(baseFile))); err != nil {
+		return "", "", fmt.Errorf("main file %s missing from base version", mainFile)
+	}
+
+	// Written next to the main file so relative paths (graphics, bibliography) keep working
+	dir := path.Dir(mainFile)
+	name := path.Base(mainFile)
+	diffName := strings.TrimSuffix(name, path.Ext(name)) + "-diff.tex"
+	relBase, err := filepath.Rel(filepath.FromSlash(dir), filepath.FromSlash(baseFile))
+	if err != nil {
+		return "", "", err
+	}
+
+	cmdStr := fmt.Sprintf("cd %s && latexdiff --flatten --encoding=utf8 %s %s > %s",
+		shellQuote("/workspace/"+dir), shellQuote(filepath.ToSlash(relBase)), shellQuote(name), shellQuote(diffName))
+	output, exitCode, err := runInSandbox(ctx, dockerCli, cfg, workspace, cmdStr)
+	if err != nil {
+		return "", output, fmt.Errorf("latexdiff: %w", err)
+	}
+	if exitCode != 0 {
+		return "", output, fmt.Errorf("latexdiff exited with code %d", exitCode)
+	}
+
+	return path.Join(dir, diffName), output, nil
+}
+
+// shellQuote quotes a value for /bin/sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
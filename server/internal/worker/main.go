@@ -13,11 +13,14 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"time"
 
+	"gollaboratex/server/internal/latex"
+	"gollaboratex/server/internal/safezip"
+
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/mount"
@@ -41,15 +44,52 @@ type Config struct {
 	MemoryBytes int64
 	NanoCPUs    int64
 	Timeout     time.Duration
+
+	// OnCompiled is called after a successful compile of a project's working
+	// tree enqueued by the server (not of a version snapshot, a diff or a
+	// compile posted to the public endpoints)
+	OnCompiled func(ctx context.Context, job JobPayload)
 }
 
+// Job kinds handled by the worker
+const (
+	KindCompile   = "compile"
+	KindPdfDiff   = "pdfdiff"
+	KindLatexDiff = "latexdiff"
+)
+
+// SourceProject marks builds the server enqueued from a project's working
+// tree. Only those count as the project's PDF; the docId of the public
+// compile endpoints is whatever the client sent.
+const SourceProject = "project"
+
 type JobPayload struct {
+	Kind         string `json:"kind,omitempty"` // Empty means KindCompile
 	JobID        string `json:"jobId"`
 	UserID       string `json:"userId"`
 	DocID        string `json:"docId,omitempty"`
+	ProjectID    string `json:"projectId,omitempty"`
+	Source       string `json:"source,omitempty"`
+	SourceHash   string `json:"sourceHash,omitempty"` // Identifies the tree a SourceProject build compiled
+	VersionID    string `json:"versionId,omitempty"`
 	SourceBucket string `json:"sourceBucket"`
 	SourceObject string `json:"sourceObject"`
 	MainFile     string `json:"mainFile"`
+
+	// When AssetsRecorded, Assets is the complete asset set of the build,
+	// e.g. what a version recorded, instead of the project's current assets
+	Assets         []AssetRef `json:"assets,omitempty"`
+	AssetsRecorded bool       `json:"assetsRecorded,omitempty"`
+
+	// PDF diff jobs compare the outputs of two compile jobs
+	BaseJobID string `json:"baseJobId,omitempty"`
+	HeadJobID string `json:"headJobId,omitempty"`
+}
+
+// AssetRef is an object of the assets bucket made available to a build
+type AssetRef struct {
+	Name   string `json:"name" bson:"name"`     // Path inside the project, e.g. figures/plot.png
+	Object string `json:"object" bson:"object"` // Object key in the assets bucket
 }
 
 // Run starts the worker main loop. All clients must be already initialized by the caller.
@@ -103,7 +143,11 @@ func Run(ctx context.Context, cfg Config, redisClient *redis.Client, mongoClient
 		go func(j JobPayload) {
 			ctxJob, cancelJob := context.WithTimeout(ctx, cfg.Timeout+30*time.Second)
 			defer cancelJob()
-			if err := processJob(ctxJob, j, cfg, dockerCli, minioClient, handler); err != nil {
+			process := processJob
+			if j.Kind == KindPdfDiff {
+				process = processPdfDiff
+			}
+			if err := process(ctxJob, j, cfg, dockerCli, minioClient, handler); err != nil {
 				log.Printf("job %s failed: %v", j.JobID, err)
 			} else {
 				log.Printf("job %s finished successfully", j.JobID)
@@ -116,7 +160,7 @@ func Run(ctx context.Context, cfg Config, redisClient *redis.Client, mongoClient
 func processJob(ctx context.Context, job JobPayload, cfg Config, dockerCli *client.Client, minioClient *minio.Client, handler *Handler) error {
 	start := time.Now()
 	logPrefix := fmt.Sprintf("[job=%s] ", job.JobID)
-	log.Printf(logPrefix + "starting")
+	log.Print(logPrefix + "starting")
 
 	// Update status to running
 	_ = handler.UpdateStatus(ctx, job.JobID, "running", "", "")
@@ -131,8 +175,9 @@ func processJob(ctx context.Context, job JobPayload, cfg Config, dockerCli *clie
 		_ = os.RemoveAll(workspace)
 	}()
 
-	// Download sources from MinIO
-	if err := downloadAndExtractFromMinio(ctx, minioClient, job.SourceBucket, job.SourceObject, workspace); err != nil {
+	// Download sources from MinIO; entries that were skipped are reported with the diagnostics
+	skipped, err := downloadAndExtractFromMinio(ctx, minioClient, job.SourceBucket, job.SourceObject, workspace)
+	if err != nil {
 		_ = handler.UpdateStatus(ctx, job.JobID, "failed", "failed to fetch source", "")
 		return fmt.Errorf("download sources: %w", err)
 	}
@@ -145,13 +190,32 @@ func processJob(ctx context.Context, job JobPayload, cfg Config, dockerCli *clie
 	}
 
 	// Fetch missing assets from MinIO
-	fetchMissingAssets(ctx, minioClient, workspace, job, logPrefix)
+	for _, s := range skipped {
+		log.Print(logPrefix + "skipped source entry: " + s)
+	}
+	diagnostics := fetchMissingAssets(ctx, minioClient, workspace, job, logPrefix)
+	for _, d := range diagnostics {
+		log.Print(logPrefix + "unresolved reference: " + d)
+	}
+	diagnostics = append(skipped, diagnostics...)
+	_ = handler.StoreDiagnostics(ctx, job.JobID, diagnostics)
+
+	// Change-tracked builds compile the latexdiff output instead of the main file
+	if job.Kind == KindLatexDiff {
+		diffFile, output, err := runLatexDiff(ctx, dockerCli, cfg, workspace, mainFile)
+		if err != nil {
+			_ = handler.StoreLogs(ctx, job.JobID, output)
+			_ = handler.UpdateStatus(ctx, job.JobID, "failed", "latexdiff failed", "")
+			return err
+		}
+		mainFile = diffFile
+	}
 
 	// Run compilation
 	stdoutStderr, exitCode, err := runTectonicContainer(ctx, dockerCli, cfg, workspace, mainFile)
 
-	// Store logs in Redis (always, even on success)
-	_ = handler.StoreLogs(ctx, job.JobID, stdoutStderr)
+	// Store logs in Redis (always, even on success), unresolved references first
+	_ = handler.StoreLogs(ctx, job.JobID, diagnosticsHeader(diagnostics)+stdoutStderr)
 
 	// Check for compilation errors
 	if err != nil || exitCode != 0 {
@@ -165,7 +229,7 @@ func processJob(ctx context.Context, job JobPayload, cfg Config, dockerCli *clie
 				errMsg = fmt.Sprintf("compile failed: %v", err)
 			}
 			_ = handler.UpdateStatus(ctx, job.JobID, "failed", errMsg, "")
-			return fmt.Errorf(errMsg)
+			return errors.New(errMsg)
 		}
 	}
 
@@ -178,7 +242,7 @@ func processJob(ctx context.Context, job JobPayload, cfg Config, dockerCli *clie
 	}
 
 	// Upload PDF to MinIO
-	pdfObject := fmt.Sprintf("%s.pdf", job.JobID)
+	pdfObject := pdfObjectName(job.JobID)
 	if err := uploadFileToMinio(ctx, minioClient, cfg.MinioBucketPDFs, pdfObject, pdfPath); err != nil {
 		_ = handler.UpdateStatus(ctx, job.JobID, "failed", "failed to upload pdf", "")
 		return fmt.Errorf("upload pdf: %w", err)
@@ -190,6 +254,17 @@ func processJob(ctx context.Context, job JobPayload, cfg Config, dockerCli *clie
 	// Update status to success
 	_ = handler.UpdateStatus(ctx, job.JobID, "success", "", pdfURL)
 
+	// Attach the PDF to the version snapshot it was built from
+	if job.VersionID != "" {
+		if err := handler.LinkVersionPDF(ctx, job.VersionID, job.JobID); err != nil {
+			log.Printf(logPrefix+"failed to link pdf to version %s: %v", job.VersionID, err)
+		}
+	}
+
+	if cfg.OnCompiled != nil && job.Source == SourceProject && job.Kind != KindLatexDiff && job.VersionID == "" && job.ProjectID != "" {
+		cfg.OnCompiled(ctx, job)
+	}
+
 	log.Printf(logPrefix+"completed in %s", time.Since(start))
 	return nil
 }
@@ -224,9 +299,16 @@ func findMainFile(workspace, requested, logPrefix string) (string, error) {
 	found := false
 
 	walkErr := filepath.WalkDir(workspace, func(path string, d fs.DirEntry, err error) error {
-		if err != nil || d.IsDir() {
+		if err != nil {
 			return err
 		}
+		if d.IsDir() {
+			// The base tree of a latexdiff build never holds the main file
+			if d.Name() == latexdiffBaseDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		rel, _ := filepath.Rel(workspace, path)
 		extracted = append(extracted, rel)
 
@@ -269,142 +351,196 @@ func findMainFile(workspace, requested, logPrefix string) (string, error) {
 	return mainFile, nil
 }
 
-func fetchMissingAssets(ctx context.Context, minioClient *minio.Client, workspace string, job JobPayload, logPrefix string) {
-	// regex to find dependencies in \includegraphics, \input, and \include
-	graphicsRe := regexp.MustCompile(`\\(?:includegraphics|input|include)(?:\[[^\]]*\])?\{([^}]+)\}`)
-	refs := map[string]struct{}{}
+// fetchMissingAssets scans the sources for file references and downloads the ones
+// missing from the workspace from the project's assets. References that cannot be
+// resolved are returned as diagnostics.
+func fetchMissingAssets(ctx context.Context, minioClient *minio.Client, workspace string, job JobPayload, logPrefix string) []string {
+	var refs []latex.Reference
+	var searchDirs []string
 
-	// 1. Scan .tex, .cls, and .sty files for all requested paths
-	_ = filepath.WalkDir(workspace, func(path string, d fs.DirEntry, err error) error {
-		if err != nil || d.IsDir() {
+	// 1. Scan the sources for references (comments are ignored)
+	_ = filepath.WalkDir(workspace, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
 			return nil
 		}
-		ext := strings.ToLower(filepath.Ext(path))
-		if ext == ".tex" || ext == ".cls" || ext == ".sty" {
-			b, _ := os.ReadFile(path)
-			matches := graphicsRe.FindAllStringSubmatch(string(b), -1)
-			for _, m := range matches {
-				if len(m) >= 2 {
-					refs[m[1]] = struct{}{}
-				}
+		if d.IsDir() {
+			if d.Name() == latexdiffBaseDir {
+				return filepath.SkipDir
 			}
+			return nil
 		}
+		if !latex.IsSource(p) {
+			return nil
+		}
+		rel, _ := filepath.Rel(workspace, p)
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil
+		}
+		result := latex.Scan(filepath.ToSlash(rel), string(b))
+		refs = append(refs, result.References...)
+		searchDirs = append(searchDirs, result.GraphicsPath...)
 		return nil
 	})
+	if len(refs) == 0 {
+		return nil
+	}
 
-	for ref := range refs {
-		// Normalizing the path (e.g., "images/1.png" -> "images/1.png")
-		cleanRef := strings.TrimPrefix(ref, "./")
-		
-		// 2. Determine target local path and ensure subdirectories exist
-		targetPath := filepath.Join(workspace, cleanRef)
-		targetDir := filepath.Dir(targetPath)
-		if _, err := os.Stat(targetDir); os.IsNotExist(err) {
-			_ = os.MkdirAll(targetDir, 0755)
+	// 2. Index the assets once, by full path and by base name
+	prefix := path.Join("project", job.DocID) + "/"
+	byPath := map[string]string{}
+	byName := map[string][]string{}
+	if job.AssetsRecorded {
+		for _, a := range job.Assets {
+			byPath[a.Name] = a.Object
+			byName[path.Base(a.Name)] = append(byName[path.Base(a.Name)], a.Object)
+		}
+	} else if job.DocID != "" {
+		for obj := range minioClient.ListObjectsIter(ctx, "assets", minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+			if obj.Err != nil {
+				log.Printf(logPrefix+"failed to list assets: %v", obj.Err)
+				break
+			}
+			rel := strings.TrimPrefix(obj.Key, prefix)
+			rel = strings.TrimPrefix(rel, "assets/")
+			byPath[rel] = obj.Key
+			byName[path.Base(rel)] = append(byName[path.Base(rel)], obj.Key)
 		}
+	}
+
+	var diagnostics []string
+	resolved := map[string]bool{}
 
-		// Skip if file already exists locally
-		if _, err := os.Stat(targetPath); err == nil {
+	for _, ref := range refs {
+		candidates := ref.Candidates(searchDirs)
+		key := strings.Join(candidates, "\x00")
+		if resolved[key] {
 			continue
 		}
 
+		// 3. Already in the workspace?
 		found := false
-		// We try to find the file in MinIO. 
-		// If the LaTeX ref is "images/1.png", we check for "1.png" in the project's assets.
-		fileNameOnly := filepath.Base(cleanRef)
-		
-		// Common extensions to try if the reference in LaTeX lacks one
-		extensions := []string{""} 
-		if filepath.Ext(fileNameOnly) == "" {
-			extensions = append(extensions, ".png", ".jpg", ".jpeg", ".pdf")
-		}
-
-		for _, ext := range extensions {
-			searchName := fileNameOnly + ext
-			
-			// Potential paths in MinIO storage
-			candidates := []string{
-				filepath.Join("project", job.DocID, "assets", searchName),
-				filepath.Join("project", job.DocID, searchName),
+		for _, c := range candidates {
+			if info, err := os.Stat(filepath.Join(workspace, filepath.FromSlash(c))); err == nil && info.Mode().IsRegular() {
+				found = true
+				break
 			}
+		}
 
-			for _, key := range candidates {
-				// If the user asked for images/1.png, we save it exactly there
-				// but we ensure we add the extension if we found it via the extension loop
-				localSavePath := targetPath
-				if filepath.Ext(localSavePath) == "" && ext != "" {
-					localSavePath += ext
+		// 4. Exact path in the assets, then a unique base name match (older flat uploads)
+		for i := 0; !found && i < 2; i++ {
+			for _, c := range candidates {
+				objectKey, ok := byPath[c]
+				if i == 1 {
+					keys := byName[path.Base(c)]
+					ok = len(keys) == 1
+					if ok {
+						objectKey = keys[0]
+					}
+				}
+				if !ok {
+					continue
 				}
 
-				if ferr := minioClient.FGetObject(ctx, "assets", key, localSavePath, minio.GetObjectOptions{}); ferr == nil {
-					_ = os.Chmod(localSavePath, 0644)
-					log.Printf(logPrefix+"Found and Downloaded: %s -> %s", key, localSavePath)
-					found = true
-					break
+				target := filepath.Join(workspace, filepath.FromSlash(c))
+				_ = os.MkdirAll(filepath.Dir(target), 0755)
+				if err := minioClient.FGetObject(ctx, "assets", objectKey, target, minio.GetObjectOptions{}); err != nil {
+					log.Printf(logPrefix+"failed to download %s: %v", objectKey, err)
+					continue
 				}
+				_ = os.Chmod(target, 0644)
+				log.Printf(logPrefix+"fetched asset %s -> %s", objectKey, c)
+				found = true
+				break
 			}
-			if found { break }
+		}
+
+		resolved[key] = true
+		if !found {
+			diagnostics = append(diagnostics, fmt.Sprintf("%s:%d: \\%s{%s} could not be resolved (tried %s)",
+				ref.Source, ref.Line, ref.Command, ref.Path, strings.Join(candidates, ", ")))
 		}
 	}
+
+	return diagnostics
 }
 
-func downloadAndExtractFromMinio(ctx context.Context, minioClient *minio.Client, bucket, object, workspace string) error {
+// downloadAndExtractFromMinio fetches the source archive of a job and
+// extracts it into workspace. Archives beyond the size limits fail the job;
+// individual unsafe entries are skipped and returned as messages.
+func downloadAndExtractFromMinio(ctx context.Context, minioClient *minio.Client, bucket, object, workspace string) ([]string, error) {
 	if bucket == "" || object == "" {
-		return fmt.Errorf("missing bucket/object")
+		return nil, fmt.Errorf("missing bucket/object")
 	}
 	tmpZip := filepath.Join(workspace, "source.zip")
 	f, err := os.Create(tmpZip)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer f.Close()
 
 	rc, err := minioClient.GetObject(ctx, bucket, object, minio.GetObjectOptions{})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer rc.Close()
 
-	if _, err := io.Copy(f, rc); err != nil {
-		return err
+	size, err := io.Copy(f, rc)
+	if err != nil {
+		return nil, err
 	}
 
-	r, err := zip.OpenReader(tmpZip)
+	r, err := safezip.NewReader(f, size, safezip.DefaultLimits())
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer r.Close()
 
+	var skipped []string
 	for _, zf := range r.File {
-		if strings.Contains(zf.Name, "..") || strings.HasPrefix(zf.Name, "/") {
+		rel, err := r.Path(zf)
+		if err != nil {
+			skipped = append(skipped, err.Error())
 			continue
 		}
-		targetPath := filepath.Join(workspace, zf.Name)
+		targetPath := filepath.Join(workspace, filepath.FromSlash(rel))
 		if zf.FileInfo().IsDir() {
 			_ = os.MkdirAll(targetPath, 0755)
 			continue
 		}
 		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-			return err
+			return skipped, err
 		}
-		dst, err := os.Create(targetPath)
-		if err != nil {
-			return err
-		}
-		src, err := zf.Open()
-		if err != nil {
-			dst.Close()
-			return err
-		}
-		if _, err := io.Copy(dst, src); err != nil {
-			src.Close()
-			dst.Close()
-			return err
+		if err := extractEntry(r, zf, targetPath); err != nil {
+			if errors.Is(err, safezip.ErrTooLarge) {
+				return skipped, err
+			}
+			if safezip.Rejected(err) {
+				skipped = append(skipped, err.Error())
+				continue
+			}
+			return skipped, err
 		}
-		src.Close()
+	}
+	return skipped, nil
+}
+
+func extractEntry(r *safezip.Reader, zf *zip.File, targetPath string) error {
+	src, err := r.Open(zf)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(targetPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
 		dst.Close()
+		os.Remove(targetPath)
+		return err
 	}
-	return nil
+	return dst.Close()
 }
 
 func uploadFileToMinio(ctx context.Context, minioClient *minio.Client, bucket, objectName, path string) error {
@@ -422,6 +558,15 @@ func uploadFileToMinio(ctx context.Context, minioClient *minio.Client, bucket, o
 }
 
 func runTectonicContainer(ctx context.Context, dockerCli *client.Client, cfg Config, workspace, mainFile string) (string, int, error) {
+	// The root file name comes from the project, so it is quoted for the shell
+	quoted := shellQuote(mainFile)
+	cmdStr := fmt.Sprintf("ls -la /workspace && if command -v tectonic >/dev/null 2>&1; then tectonic --outdir=/workspace %s; else latexmk -pdf -f -interaction=nonstopmode -halt-on-error -file-line-error -no-shell-escape %s; fi", quoted, quoted)
+	return runInSandbox(ctx, dockerCli, cfg, workspace, cmdStr)
+}
+
+// runInSandbox runs a shell command in the compiler image with the workspace
+// mounted at /workspace, no network and the configured resource limits.
+func runInSandbox(ctx context.Context, dockerCli *client.Client, cfg Config, workspace, cmdStr string) (string, int, error) {
 	// Pull image if needed
 	reader, err := dockerCli.ImagePull(ctx, cfg.DockerImage, image.PullOptions{})
 	if err == nil && reader != nil {
@@ -430,7 +575,6 @@ func runTectonicContainer(ctx context.Context, dockerCli *client.Client, cfg Con
 	}
 
 	containerName := fmt.Sprintf("tectonic-%d", time.Now().UnixNano())
-	cmdStr := fmt.Sprintf("ls -la /workspace && if command -v tectonic >/dev/null 2>&1; then tectonic --outdir=/workspace %s; else latexmk -pdf -f -interaction=nonstopmode -halt-on-error -file-line-error -no-shell-escape %s; fi", mainFile, mainFile)
 
 	config := &container.Config{
 		Image:      cfg.DockerImage,
@@ -470,7 +614,7 @@ func runTectonicContainer(ctx context.Context, dockerCli *client.Client, cfg Con
 		errStr := err.Error()
 		if strings.Contains(errStr, "client version") || strings.Contains(errStr, "API version") || strings.Contains(errStr, "too old") {
 			log.Printf("docker API mismatch: %v — falling back to CLI", err)
-			return runTectonicContainerDockerCLI(ctx, cfg, workspace, mainFile)
+			return runInSandboxCLI(ctx, cfg, workspace, cmdStr)
 		}
 		return "", -1, fmt.Errorf("container create: %w", err)
 	}
@@ -511,7 +655,7 @@ func runTectonicContainer(ctx context.Context, dockerCli *client.Client, cfg Con
 	return logs, int(exitCode), nil
 }
 
-func runTectonicContainerDockerCLI(ctx context.Context, cfg Config, workspace, mainFile string) (string, int, error) {
+func runInSandboxCLI(ctx context.Context, cfg Config, workspace, cmdStr string) (string, int, error) {
 	args := []string{
 		"run", "--rm",
 		"-v", fmt.Sprintf("%s:/workspace", workspace),
@@ -532,7 +676,6 @@ func runTectonicContainerDockerCLI(ctx context.Context, cfg Config, workspace, m
 		args = append(args, "--cpus", fmt.Sprintf("%g", cpus))
 	}
 
-	cmdStr := fmt.Sprintf("ls -la /workspace && if command -v tectonic >/dev/null 2>&1; then tectonic --outdir=/workspace %s; else latexmk -pdf -f -interaction=nonstopmode -halt-on-error -file-line-error -no-shell-escape %s; fi", mainFile, mainFile)
 	args = append(args, "--entrypoint", "/bin/sh", cfg.DockerImage, "-c", cmdStr)
 
 	cmd := exec.CommandContext(ctx, "docker", args...)
@@ -0,0 +1,388 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/go-redis/redis/v8"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// Resolution used to rasterize pages for visual diffs
+	diffDPI = 72
+	// Pages beyond this are not compared
+	diffMaxPages = 300
+	// Per-channel difference (16-bit scale) below which pixels count as equal,
+	// so anti-aliasing noise does not flag a page as changed
+	diffThreshold = 0x1000
+)
+
+// Page statuses of a PDF diff
+const (
+	PageUnchanged = "unchanged"
+	PageChanged   = "changed"
+	PageAdded     = "added"
+	PageRemoved   = "removed"
+)
+
+// PdfPageDiff describes the comparison of a single page
+type PdfPageDiff struct {
+	Page          int     `json:"page"`
+	Status        string  `json:"status"`
+	ChangedPixels int     `json:"changedPixels"`
+	ChangedRatio  float64 `json:"changedRatio"`
+	ImageObject   string  `json:"imageObject,omitempty"` // Highlighted diff image, only for changed pages
+}
+
+// PdfDiff is the summary of a visual diff stored next to the diff images
+type PdfDiff struct {
+	ID           string        `json:"id"`
+	BaseJobID    string        `json:"baseJobId"`
+	HeadJobID    string        `json:"headJobId"`
+	Status       string        `json:"status"` // queued, running, success, failed
+	Error        string        `json:"error,omitempty"`
+	Pages        []PdfPageDiff `json:"pages"`
+	ChangedPages []int         `json:"changedPages"`
+	CreatedAt    time.Time     `json:"createdAt"`
+}
+
+// pdfDiffID is deterministic so a pair of builds is only ever diffed once
+func pdfDiffID(baseJobID, headJobID string) string {
+	return fmt.Sprintf("pdfdiff-%s-%s", baseJobID, headJobID)
+}
+
+// pdfDiffPrefix is where the summary and diff images of a diff are stored
+func pdfDiffPrefix(diffID string) string {
+	return "diffs/" + diffID + "/"
+}
+
+// RequestPdfDiff returns the diff of two builds, enqueueing it on first request.
+// While the worker is busy the returned diff only carries its status.
+func (h *Handler) RequestPdfDiff(ctx context.Context, userID, baseJobID, headJobID string) (*PdfDiff, error) {
+	if baseJobID == "" || headJobID == "" {
+		return nil, errors.New("both baseJobId and headJobId are required")
+	}
+	diffID := pdfDiffID(baseJobID, headJobID)
+
+	// Finished diffs are served from MinIO
+	if diff, err := h.loadPdfDiff(ctx, diffID); err == nil {
+		return diff, nil
+	}
+
+	pending := &PdfDiff{
+		ID:           diffID,
+		BaseJobID:    baseJobID,
+		HeadJobID:    headJobID,
+		Pages:        []PdfPageDiff{},
+		ChangedPages: []int{},
+	}
+
+	status, err := h.getStatus(ctx, diffID)
+	if err == nil && status.Status != "success" {
+		// Failed attempts are reported until their status expires, then retried
+		pending.Status = status.Status
+		pending.Error = status.Error
+		pending.CreatedAt = status.CreatedAt
+		return pending, nil
+	}
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	// Not computed yet: enqueue it
+	pending.Status = "queued"
+	pending.CreatedAt = time.Now().UTC()
+	if err := h.setStatus(ctx, diffID, CompileStatus{JobID: diffID, Status: "queued", CreatedAt: pending.CreatedAt}); err != nil {
+		return nil, fmt.Errorf("failed to store status: %w", err)
+	}
+
+	b, err := json.Marshal(JobPayload{
+		Kind:      KindPdfDiff,
+		JobID:     diffID,
+		UserID:    userID,
+		BaseJobID: baseJobID,
+		HeadJobID: headJobID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal job payload: %w", err)
+	}
+	if err := h.Redis.RPush(ctx, h.QueueName, string(b)).Err(); err != nil {
+		return nil, fmt.Errorf("failed to enqueue job: %w", err)
+	}
+
+	return pending, nil
+}
+
+func (h *Handler) loadPdfDiff(ctx context.Context, diffID string) (*PdfDiff, error) {
+	obj, err := h.Minio.GetObject(ctx, h.PdfsBucket, pdfDiffPrefix(diffID)+"summary.json", minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	var diff PdfDiff
+	if err := json.NewDecoder(obj).Decode(&diff); err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
+
+// processPdfDiff rasterizes both PDFs in the sandbox and compares them page by page.
+func processPdfDiff(ctx context.Context, job JobPayload, cfg Config, dockerCli *client.Client, minioClient *minio.Client, handler *Handler) error {
+	start := time.Now()
+	logPrefix := fmt.Sprintf("[pdfdiff=%s] ", job.JobID)
+	_ = handler.UpdateStatus(ctx, job.JobID, "running", "", "")
+
+	fail := func(msg string, err error) error {
+		_ = handler.UpdateStatus(ctx, job.JobID, "failed", msg, "")
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+
+	workspace, err := os.MkdirTemp("", "pdfdiff-")
+	if err != nil {
+		return fail("workspace creation failed", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(workspace)
+	}()
+
+	for name, jobID := range map[string]string{"base": job.BaseJobID, "head": job.HeadJobID} {
+		target := filepath.Join(workspace, name+".pdf")
+		if err := minioClient.FGetObject(ctx, cfg.MinioBucketPDFs, pdfObjectName(jobID), target, minio.GetObjectOptions{}); err != nil {
+			return fail(fmt.Sprintf("pdf of job %s not found", jobID), err)
+		}
+	}
+
+	cmdStr := fmt.Sprintf(
+		"gs -q -dSAFER -dBATCH -dNOPAUSE -dLastPage=%[1]d -sDEVICE=png16m -r%[2]d -sOutputFile=base-%%03d.png base.pdf && "+
+			"gs -q -dSAFER -dBATCH -dNOPAUSE -dLastPage=%[1]d -sDEVICE=png16m -r%[2]d -sOutputFile=head-%%03d.png head.pdf",
+		diffMaxPages, diffDPI,
+	)
+	output, exitCode, err := runInSandbox(ctx, dockerCli, cfg, workspace, cmdStr)
+	if err != nil || exitCode != 0 {
+		if err == nil {
+			err = fmt.Errorf("exit code %d", exitCode)
+		}
+		log.Printf(logPrefix+"rasterization output: %s", output)
+		return fail("failed to rasterize pdfs", err)
+	}
+
+	basePages, err := listPageImages(workspace, "base")
+	if err != nil {
+		return fail("failed to read rasterized pages", err)
+	}
+	headPages, err := listPageImages(workspace, "head")
+	if err != nil {
+		return fail("failed to read rasterized pages", err)
+	}
+
+	diff := PdfDiff{
+		ID:           job.JobID,
+		BaseJobID:    job.BaseJobID,
+		HeadJobID:    job.HeadJobID,
+		Status:       "success",
+		Pages:        []PdfPageDiff{},
+		ChangedPages: []int{},
+		CreatedAt:    time.Now().UTC(),
+	}
+	prefix := pdfDiffPrefix(job.JobID)
+
+	pageCount := max(len(basePages), len(headPages))
+	for page := 1; page <= pageCount; page++ {
+		pageDiff, highlighted, err := comparePage(basePages[page], headPages[page])
+		if err != nil {
+			return fail(fmt.Sprintf("failed to compare page %d", page), err)
+		}
+		pageDiff.Page = page
+
+		if highlighted != nil {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, highlighted); err != nil {
+				return fail("failed to encode diff image", err)
+			}
+			pageDiff.ImageObject = fmt.Sprintf("%spage-%03d.png", prefix, page)
+			_, err := minioClient.PutObject(ctx, cfg.MinioBucketPDFs, pageDiff.ImageObject, &buf, int64(buf.Len()), minio.PutObjectOptions{ContentType: "image/png"})
+			if err != nil {
+				return fail("failed to upload diff image", err)
+			}
+		}
+
+		if pageDiff.Status != PageUnchanged {
+			diff.ChangedPages = append(diff.ChangedPages, page)
+		}
+		diff.Pages = append(diff.Pages, pageDiff)
+	}
+
+	summary, err := json.Marshal(diff)
+	if err != nil {
+		return fail("failed to marshal diff summary", err)
+	}
+	_, err = minioClient.PutObject(ctx, cfg.MinioBucketPDFs, prefix+"summary.json", bytes.NewReader(summary), int64(len(summary)), minio.PutObjectOptions{ContentType: "application/json"})
+	if err != nil {
+		return fail("failed to upload diff summary", err)
+	}
+
+	_ = handler.UpdateStatus(ctx, job.JobID, "success", "", "")
+	log.Printf(logPrefix+"%d pages compared, %d changed in %s", pageCount, len(diff.ChangedPages), time.Since(start))
+	return nil
+}
+
+var pageImageRe = regexp.MustCompile(`^(base|head)-0*(\d+)\.png$`)
+
+// listPageImages maps page numbers to the rasterized images of one side
+func listPageImages(workspace, side string) (map[int]string, error) {
+	entries, err := os.ReadDir(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := map[int]string{}
+	for _, e := range entries {
+		m := pageImageRe.FindStringSubmatch(e.Name())
+		if m == nil || m[1] != side {
+			continue
+		}
+		page, err := strconv.Atoi(m[2])
+		if err != nil {
+			continue
+		}
+		pages[page] = filepath.Join(workspace, e.Name())
+	}
+	return pages, nil
+}
+
+// comparePage diffs two rasterized pages (either may be missing) and returns a
+// highlighted image of the head page for anything that is not unchanged.
+func comparePage(basePath, headPath string) (PdfPageDiff, image.Image, error) {
+	var base, head image.Image
+	var err error
+	if basePath != "" {
+		if base, err = decodePNG(basePath); err != nil {
+			return PdfPageDiff{}, nil, err
+		}
+	}
+	if headPath != "" {
+		if head, err = decodePNG(headPath); err != nil {
+			return PdfPageDiff{}, nil, err
+		}
+	}
+
+	switch {
+	case base == nil && head == nil:
+		return PdfPageDiff{Status: PageUnchanged}, nil, nil
+	case base == nil:
+		return PdfPageDiff{Status: PageAdded, ChangedPixels: pixelCount(head), ChangedRatio: 1}, highlightAll(head), nil
+	case head == nil:
+		return PdfPageDiff{Status: PageRemoved, ChangedPixels: pixelCount(base), ChangedRatio: 1}, highlightAll(base), nil
+	}
+
+	changed, highlighted := diffImages(base, head)
+	total := pixelCount(highlighted)
+	result := PdfPageDiff{Status: PageUnchanged, ChangedPixels: changed}
+	if total > 0 {
+		result.ChangedRatio = float64(changed) / float64(total)
+	}
+	if changed == 0 {
+		return result, nil, nil
+	}
+	result.Status = PageChanged
+	return result, highlighted, nil
+}
+
+func decodePNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func pixelCount(img image.Image) int {
+	b := img.Bounds()
+	return b.Dx() * b.Dy()
+}
+
+var highlightColor = color.RGBA{R: 230, G: 30, B: 30, A: 255}
+
+// diffImages compares two pages pixel by pixel. The result shows the head page
+// faded to grey with differing pixels painted red.
+func diffImages(base, head image.Image) (int, *image.RGBA) {
+	bb, hb := base.Bounds(), head.Bounds()
+	width := max(bb.Dx(), hb.Dx())
+	height := max(bb.Dy(), hb.Dy())
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	changed := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			inBase := x < bb.Dx() && y < bb.Dy()
+			inHead := x < hb.Dx() && y < hb.Dy()
+
+			var bc, hc color.Color
+			if inBase {
+				bc = base.At(bb.Min.X+x, bb.Min.Y+y)
+			}
+			if inHead {
+				hc = head.At(hb.Min.X+x, hb.Min.Y+y)
+			}
+
+			if !inBase || !inHead || !similar(bc, hc) {
+				changed++
+				out.SetRGBA(x, y, highlightColor)
+				continue
+			}
+			out.SetRGBA(x, y, faded(hc))
+		}
+	}
+	return changed, out
+}
+
+// highlightAll marks a whole page as changed (added or removed pages)
+func highlightAll(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := faded(img.At(b.Min.X+x, b.Min.Y+y))
+			// Keep the content readable under a red tint
+			out.SetRGBA(x, y, color.RGBA{R: 255, G: c.G / 2, B: c.B / 2, A: 255})
+		}
+	}
+	return out
+}
+
+func similar(a, b color.Color) bool {
+	ar, ag, ab, _ := a.RGBA()
+	br, bg, bb, _ := b.RGBA()
+	return absDiff(ar, br) < diffThreshold && absDiff(ag, bg) < diffThreshold && absDiff(ab, bb) < diffThreshold
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// faded converts a pixel to a light grey so highlights stand out
+func faded(c color.Color) color.RGBA {
+	r, g, b, _ := c.RGBA()
+	gray := uint8(((r + g + b) / 3) >> 8)
+	v := 255 - (255-gray)/3
+	return color.RGBA{R: v, G: v, B: v, A: 255}
+}